@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// BackpressureConfig bounds the work /event takes on. WarningReserve is
+// the share of every limit only Warning events may use, so that they are
+// still accepted after Normal events start being shed.
+type BackpressureConfig struct {
+	MaxInFlight    int           `yaml:"maxInFlight" json:"maxInFlight" env:"BACKPRESSURE_MAX_IN_FLIGHT"`
+	QueueWait      time.Duration `yaml:"queueWait" json:"queueWait" env:"BACKPRESSURE_QUEUE_WAIT"`
+	WarningReserve float64       `yaml:"warningReserve" json:"warningReserve" env:"BACKPRESSURE_WARNING_RESERVE"`
+	RetryAfter     time.Duration `yaml:"retryAfter" json:"retryAfter" env:"BACKPRESSURE_RETRY_AFTER"`
+}
+
+// Function to validate the backpressure settings
+func (c BackpressureConfig) validate() error {
+	var errs []error
+	if c.MaxInFlight <= 0 {
+		errs = append(errs, errors.New("backpressure.maxInFlight: must be positive"))
+	}
+	if c.QueueWait <= 0 {
+		errs = append(errs, errors.New("backpressure.queueWait: must be positive"))
+	}
+	if c.WarningReserve < 0 || c.WarningReserve >= 1 {
+		errs = append(errs, fmt.Errorf("backpressure.warningReserve: %v is not in [0, 1)", c.WarningReserve))
+	}
+	if c.RetryAfter < time.Second {
+		errs = append(errs, errors.New("backpressure.retryAfter: must be at least 1s"))
+	}
+	return errors.Join(errs...)
+}
+
+// admission decides whether /event can take on another request
+type admission struct {
+	config   BackpressureConfig
+	inFlight atomic.Int64
+	shed     metric.Int64Counter
+}
+
+// Function to create the admission control and its metrics
+func newAdmission(config BackpressureConfig, meter metric.Meter) (*admission, error) {
+	shed, err := meter.Int64Counter(
+		"events_shed",
+		metric.WithDescription("Events rejected because the service was saturated"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create shed counter: %w", err)
+	}
+	return &admission{config: config, shed: shed}, nil
+}
+
+// Function to compute the share of a limit an event may use. Normal
+// events always get at least one slot, so that a small limit does not
+// shed them all while the server is idle.
+func (a *admission) limit(capacity int, warning bool) int {
+	if warning {
+		return capacity
+	}
+	return max(1, int(float64(capacity)*(1-a.config.WarningReserve)))
+}
+
+// Function to take an in-flight slot; release must be called when the
+// request is done
+func (a *admission) admit(warning bool) (release func(), ok bool) {
+	limit := int64(a.limit(a.config.MaxInFlight, warning))
+	for {
+		n := a.inFlight.Load()
+		if n >= limit {
+			return nil, false
+		}
+		if a.inFlight.CompareAndSwap(n, n+1) {
+			return func() { a.inFlight.Add(-1) }, true
+		}
+	}
+}
+
+// Function to find an internal buffer too full to take the event. Normal
+// events are turned away below the high-water mark, leaving the rest of
+// each buffer to Warning events.
+func (s *Server) saturatedBuffer(warning bool) (string, bool) {
+	s.buffersMu.Lock()
+	defer s.buffersMu.Unlock()
+	for _, gauge := range s.buffers {
+		if gauge.depth() >= s.admission.limit(gauge.highWater, warning) {
+			return gauge.name, true
+		}
+	}
+	return "", false
+}
+
+// Function to context-bound how long an event may wait for queue space;
+// clientCtx ends when the client goes away
+func (s *Server) queueContext(clientCtx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(clientCtx, s.admission.config.QueueWait)
+}
+
+// Function to count a shed event
+func (a *admission) countShed(ctx context.Context, reason string, warning bool) {
+	priority := "normal"
+	if warning {
+		priority = "warning"
+	}
+	a.shed.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("reason", reason),
+		attribute.String("priority", priority),
+	))
+}
+
+// Function to format the Retry-After header sent with shed events
+func (a *admission) retryAfter() string {
+	return retryAfterSeconds(a.config.RetryAfter)
+}
+
+// Function to format a delay as a Retry-After header value
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// refusedStarting is the reason given while the mappings are being set up
+const refusedStarting = "starting"
+
+// refusal says why a request was turned away before any of its events were
+// taken on: the service is starting, or it is saturated. Each transport
+// reports it in its own terms.
+type refusal struct {
+	reason     string // refusedStarting, "in_flight" or the full buffer
+	retryAfter time.Duration
+}
+
+// Function to take on a request whose events include a Warning if warning
+// is set. Writing before the mappings exist would let OpenSearch guess
+// them, and load is shed before doing any work, Normal events first. The
+// returned context detaches the writes from the client connection so a
+// disconnect does not abandon them halfway; done must be called once they
+// are finished, and shutdown waits for it.
+func (s *Server) accept(clientCtx context.Context, warning bool) (ctx context.Context, done func(), refused *refusal) {
+	if !s.ready.Load() {
+		return nil, nil, &refusal{reason: refusedStarting, retryAfter: 5 * time.Second}
+	}
+	release, ok := s.admission.admit(warning)
+	if !ok {
+		s.admission.countShed(clientCtx, "in_flight", warning)
+		return nil, nil, &refusal{reason: "in_flight", retryAfter: s.admission.config.RetryAfter}
+	}
+	if name, full := s.saturatedBuffer(warning); full {
+		release()
+		s.admission.countShed(clientCtx, name, warning)
+		return nil, nil, &refusal{reason: name, retryAfter: s.admission.config.RetryAfter}
+	}
+
+	s.pending.Add(1)
+	done = func() {
+		s.pending.Done()
+		release()
+	}
+	return context.WithoutCancel(clientCtx), done, nil
+}
+
+// Function to answer an HTTP request that was refused. 429 tells the
+// producer it is sending too fast, 503 that we cannot keep up downstream
+// or are still starting; all carry Retry-After.
+func refuse(w http.ResponseWriter, refused *refusal) {
+	w.Header().Set("Retry-After", retryAfterSeconds(refused.retryAfter))
+	switch refused.reason {
+	case refusedStarting:
+		http.Error(w, "Service is starting", http.StatusServiceUnavailable)
+	case "in_flight":
+		http.Error(w, "Service saturated (in_flight), retry later", http.StatusTooManyRequests)
+	default:
+		http.Error(w, "Service saturated ("+refused.reason+"), retry later", http.StatusServiceUnavailable)
+	}
+}
+
+// Function to reject an event the buffers could not take after all, with
+// status 503 and Retry-After
+func (s *Server) shed(w http.ResponseWriter, r *http.Request, reason string, warning bool) {
+	s.admission.countShed(r.Context(), reason, warning)
+	w.Header().Set("Retry-After", s.admission.retryAfter())
+	http.Error(w, "Service saturated ("+reason+"), retry later", http.StatusServiceUnavailable)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+)
+
+// BatchConfig limits the requests accepted by /events and the OTLP logs
+// receiver; an OTLP export counts its log records as items. MaxBytes
+// applies to the body after decompression.
+type BatchConfig struct {
+	MaxItems int   `yaml:"maxItems" json:"maxItems" env:"BATCH_MAX_ITEMS"`
+	MaxBytes int64 `yaml:"maxBytes" json:"maxBytes" env:"BATCH_MAX_BYTES"`
+}
+
+// Function to validate the batch settings
+func (c BatchConfig) validate() error {
+	var errs []error
+	if c.MaxItems <= 0 {
+		errs = append(errs, errors.New("batch.maxItems: must be positive"))
+	}
+	if c.MaxBytes <= 0 {
+		errs = append(errs, errors.New("batch.maxBytes: must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+var (
+	errTooManyItems         = errors.New("too many events in batch")
+	errUnsupportedMediaType = errors.New("unsupported media type")
+)
+
+// batchItem is the outcome of one event of a /events request. Index is
+// the event's position in the batch and Status an HTTP status code, so
+// that producers can resend only the items that failed.
+type batchItem struct {
+	Index      int    `json:"index"`
+	Status     int    `json:"status"`
+	ID         string `json:"id,omitempty"`
+	DocumentID string `json:"documentId,omitempty"`
+	Result     string `json:"result"`
+	Error      string `json:"error,omitempty"`
+
+	// Documents lists what was persisted, once the event was waited for
+	Documents []documentStatus `json:"documents,omitempty"`
+}
+
+// Handler for ingesting a batch of events, sent as a JSON array or as
+// NDJSON. The response lists the outcome of every event; a failed event
+// does not fail the others.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	wait, err := s.syncRequested(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	raw, err := s.readBatch(w, r)
+	if errors.Is(err, errTooManyItems) {
+		http.Error(w, fmt.Sprintf("Batch exceeds %d events", s.config.Batch.MaxItems), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	items := make([]batchItem, len(raw))
+	events := make([]*Event, len(raw))
+	warning := false
+	for i, item := range raw {
+		items[i].Index = i
+		var event Event
+		err := s.decodeEvent(item, &event)
+		if err == nil {
+			err = validateEvent(event)
+		}
+		if err != nil {
+			items[i].Status, items[i].Result, items[i].Error = http.StatusBadRequest, "rejected", "invalid event: "+err.Error()
+			continue
+		}
+		events[i] = &event
+		warning = warning || event.Type == "Warning"
+	}
+
+	// The whole request takes one in-flight slot and is refused only when
+	// not even its most urgent event would fit; otherwise individual events
+	// are shed as the buffers fill up
+	ctx, done, refused := s.accept(r.Context(), warning)
+	if refused != nil {
+		refuse(w, refused)
+		return
+	}
+	defer done()
+
+	// Producer idempotency keys cover a single event, so a batch relies on
+	// the configured key fields
+	meta := newRequestMeta(r)
+	shed := false
+	var ings []*ingestion
+	var positions []int
+	for i, event := range events {
+		if event == nil {
+			continue
+		}
+		eventWarning := event.Type == "Warning"
+		if name, full := s.saturatedBuffer(eventWarning); full {
+			s.admission.countShed(r.Context(), name, eventWarning)
+			items[i].Status, items[i].Result, items[i].Error = http.StatusServiceUnavailable, "rejected", "service saturated ("+name+")"
+			shed = true
+			continue
+		}
+		ing, err := s.newIngestion(ctx, meta, "", *event)
+		if err != nil {
+			log.Printf("Failed to prepare event: %v", err)
+			items[i].Status, items[i].Result, items[i].Error = http.StatusInternalServerError, "rejected", "internal server error"
+			continue
+		}
+		ings = append(ings, ing)
+		positions = append(positions, i)
+	}
+
+	for n, err := range s.submit(ctx, r.Context(), ings, wait) {
+		item, ing := &items[positions[n]], ings[n]
+		item.ID = ing.id
+		if err != nil {
+			if reason, ok := s.shedReason(err); ok {
+				s.admission.countShed(r.Context(), reason, ing.warning)
+				shed = true
+				err = fmt.Errorf("service saturated (%s)", reason)
+			} else {
+				log.Printf("Failed to spool event: %v", err)
+			}
+			item.Status, item.Result, item.Error = http.StatusServiceUnavailable, "rejected", err.Error()
+			continue
+		}
+
+		if !wait {
+			status, _ := s.statuses.get(ing.id)
+			item.Status, item.Result, item.DocumentID = http.StatusAccepted, "accepted", status.documentID()
+			continue
+		}
+		status, ok := s.statuses.wait(r.Context(), ing.id)
+		item.setStatus(status, ok)
+	}
+
+	errorsSeen := false
+	for _, item := range items {
+		errorsSeen = errorsSeen || item.Status >= 400
+	}
+	if shed {
+		w.Header().Set("Retry-After", s.admission.retryAfter())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"errors": errorsSeen,
+		"items":  items,
+	})
+}
+
+// Function to fill in the outcome of an event that was waited for
+func (item *batchItem) setStatus(status ingestStatus, ok bool) {
+	if !ok {
+		item.Status, item.Result, item.Error = http.StatusInternalServerError, stateFailed, "delivery status expired"
+		return
+	}
+
+	item.Result, item.DocumentID, item.Documents = status.State, status.documentID(), status.Documents
+	switch status.State {
+	case stateIndexed:
+		item.Status = http.StatusCreated
+	case stateDuplicate:
+		item.Status = http.StatusOK
+	case stateFailed, stateDeadLettered:
+		item.Status = http.StatusInternalServerError
+		for _, d := range status.Documents {
+			if d.Required && d.Error != "" {
+				item.Error = d.Kind + ": " + d.Error
+				break
+			}
+		}
+	default:
+		// The client went away before the event was written
+		item.Status, item.Error = http.StatusInternalServerError, "not written yet"
+	}
+}
+
+// Function to read the events of a batch request without decoding them,
+// so that one malformed event does not reject the rest
+func (s *Server) readBatch(w http.ResponseWriter, r *http.Request) ([]json.RawMessage, error) {
+	mediaType := "application/json"
+	if header := r.Header.Get("Content-Type"); header != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(header); err != nil {
+			return nil, errUnsupportedMediaType
+		}
+	}
+	read := readJSONArray
+	switch mediaType {
+	case "application/json":
+	case "application/x-ndjson", "application/ndjson":
+		read = readNDJSON
+	default:
+		return nil, errUnsupportedMediaType
+	}
+
+	body, err := s.openBody(w, r, s.config.Batch.MaxBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return read(body, s.config.Batch.MaxItems)
+}
+
+// Function to split a JSON array into its elements
+func readJSONArray(body io.Reader, maxItems int) ([]json.RawMessage, error) {
+	dec := json.NewDecoder(body)
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('[') {
+		return nil, errors.New("expected a JSON array of events")
+	}
+
+	var items []json.RawMessage
+	for dec.More() {
+		if len(items) == maxItems {
+			return nil, errTooManyItems
+		}
+		var item json.RawMessage
+		if err := dec.Decode(&item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the array")
+	}
+	return items, nil
+}
+
+// Function to split NDJSON into its lines, skipping blank ones. Lines are
+// not checked here; a malformed one only rejects its own event.
+func readNDJSON(body io.Reader, maxItems int) ([]json.RawMessage, error) {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 64*1024), 64<<20)
+	var items []json.RawMessage
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if len(items) == maxItems {
+			return nil, errTooManyItems
+		}
+		items = append(items, append(json.RawMessage(nil), line...))
+	}
+	return items, sc.Err()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// Function to create a ready server writing to a stand-in OpenSearch
+// that rejects documents mentioning "unmappable" with a mapping error
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	opensearchServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/_bulk" {
+			fmt.Fprint(w, `{"version":{"number":"2.11.0","distribution":"opensearch"}}`)
+			return
+		}
+		var items []string
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]struct {
+				Index string `json:"_index"`
+			}
+			json.Unmarshal(scanner.Bytes(), &action)
+			for name, meta := range action {
+				item := fmt.Sprintf(`"_index":%q,"_id":"id-%d","status":201`, meta.Index, len(items))
+				if name != "delete" && scanner.Scan() && strings.Contains(scanner.Text(), "unmappable") {
+					item = fmt.Sprintf(`"_index":%q,"status":400,"error":{"type":"mapper_parsing_exception","reason":"because"}`, meta.Index)
+				}
+				items = append(items, fmt.Sprintf(`{%q:{%s}}`, name, item))
+			}
+		}
+		fmt.Fprintf(w, `{"took":1,"errors":true,"items":[%s]}`, strings.Join(items, ","))
+	}))
+	t.Cleanup(opensearchServer.Close)
+
+	config := defaultConfig()
+	config.Bulk.FlushInterval = 10 * time.Millisecond
+	config.Retry.MaxAttempts = 1
+	client, err := opensearch.NewClient(opensearch.Config{Addresses: []string{opensearchServer.URL}, DisableRetry: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	indices, err := newIndexNames(config.Indices)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meter := noop.NewMeterProvider().Meter("test")
+	s := &Server{config: config, client: client, indices: indices, statuses: newStatusStore(config.Ingest)}
+	s.eventCounter, _ = meter.Int64Counter("event_frequency")
+	s.durationHistogram, _ = meter.Float64Histogram("event_duration")
+	s.statusCounter, _ = meter.Int64Counter("event_status_distribution")
+	s.errorRateCounter, _ = meter.Int64Counter("error_rate")
+	if s.retry, err = newRetryPolicy(config.Retry, meter); err != nil {
+		t.Fatal(err)
+	}
+	if s.admission, err = newAdmission(config.Backpressure, meter); err != nil {
+		t.Fatal(err)
+	}
+	s.bulk = newBulkIndexer(client, config.Bulk, s.retry)
+	s.bulk.start()
+	t.Cleanup(func() { s.bulk.close(context.Background()) })
+	s.ready.Store(true)
+	return s
+}
+
+func TestHandleEventsReportsEachItem(t *testing.T) {
+	valid := `{"kind":"Event","involvedObject":{"kind":"Pod","name":"web-1"},"type":"Normal","metadata":{"message":"%s"}}`
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		sync        bool
+		statuses    []int
+		errors      []string
+	}{
+		{
+			name:        "array with malformed and invalid events",
+			contentType: "application/json",
+			body: "[" + strings.Join([]string{
+				fmt.Sprintf(valid, "one"),
+				`{"kind": 3}`,
+				`{"kind":"Event","involvedObject":{"kind":"Pod","name":"web-1"},"type":"Fatal"}`,
+				fmt.Sprintf(valid, "four"),
+				`{"kind":"Event","involvedObject":{"kind":"Pod"},"eventTime":"yesterday"}`,
+			}, ",") + "]",
+			statuses: []int{202, 400, 400, 202, 400},
+			errors:   []string{"", "invalid event", "type", "", "eventTime"},
+		},
+		{
+			name:        "NDJSON with blank lines and a broken line",
+			contentType: "application/x-ndjson",
+			body:        fmt.Sprintf(valid, "one") + "\n\n{not json\n" + fmt.Sprintf(valid, "three") + "\n",
+			statuses:    []int{202, 400, 202},
+			errors:      []string{"", "invalid event", ""},
+		},
+		{
+			name:        "waiting reports write failures in place",
+			contentType: "application/x-ndjson",
+			body:        fmt.Sprintf(valid, "one") + "\n" + fmt.Sprintf(valid, "unmappable") + "\n" + `{"type":"Normal"}` + "\n" + fmt.Sprintf(valid, "four") + "\n",
+			sync:        true,
+			statuses:    []int{201, 500, 400, 201},
+			errors:      []string{"", "mapper_parsing_exception", "involvedObject.kind", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/events?sync=%v", tt.sync), strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			s.handleEvents(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			var res struct {
+				Errors bool        `json:"errors"`
+				Items  []batchItem `json:"items"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if !res.Errors {
+				t.Error("errors is false")
+			}
+			if len(res.Items) != len(tt.statuses) {
+				t.Fatalf("got %d items, want %d: %s", len(res.Items), len(tt.statuses), w.Body)
+			}
+			for i, item := range res.Items {
+				if item.Index != i || item.Status != tt.statuses[i] || !strings.Contains(item.Error, tt.errors[i]) || (tt.errors[i] == "") != (item.Error == "") {
+					t.Errorf("item %d: index %d, status %d, error %q; want status %d, error containing %q", i, item.Index, item.Status, item.Error, tt.statuses[i], tt.errors[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHandleEventsRejectsWholeRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{name: "not an array", contentType: "application/json", body: `{"kind":"Event"}`, status: http.StatusBadRequest},
+		{name: "trailing data", contentType: "application/json", body: `[] []`, status: http.StatusBadRequest},
+		{name: "unsupported media type", contentType: "text/plain", body: `[]`, status: http.StatusUnsupportedMediaType},
+		{name: "too many events", contentType: "application/x-ndjson", body: strings.Repeat("{}\n", 3), status: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.config.Batch.MaxItems = 2
+			r := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			s.handleEvents(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status %d (%s), want %d", w.Code, strings.TrimSpace(w.Body.String()), tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// BodyConfig limits ingestion request bodies. MaxCompressedBytes caps what
+// is read off the wire; MaxEventBytes caps a decoded /event body, while
+// /events is capped by batch.maxBytes. MaxDepth bounds JSON nesting.
+type BodyConfig struct {
+	MaxCompressedBytes int64 `yaml:"maxCompressedBytes" json:"maxCompressedBytes" env:"BODY_MAX_COMPRESSED_BYTES"`
+	MaxEventBytes      int64 `yaml:"maxEventBytes" json:"maxEventBytes" env:"BODY_MAX_EVENT_BYTES"`
+	MaxDepth           int   `yaml:"maxDepth" json:"maxDepth" env:"BODY_MAX_DEPTH"`
+}
+
+// Function to validate the request body settings
+func (c BodyConfig) validate() error {
+	var errs []error
+	if c.MaxCompressedBytes <= 0 {
+		errs = append(errs, errors.New("body.maxCompressedBytes: must be positive"))
+	}
+	if c.MaxEventBytes <= 0 {
+		errs = append(errs, errors.New("body.maxEventBytes: must be positive"))
+	}
+	if c.MaxDepth <= 0 {
+		errs = append(errs, errors.New("body.maxDepth: must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+var (
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+	errTooDeep             = errors.New("JSON nested too deeply")
+)
+
+// decodedBody is a request body read through its decompressor
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *decodedBody) Close() error {
+	var errs []error
+	for _, c := range b.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Function to open a request body for reading, decompressing it according
+// to Content-Encoding. Both the compressed and the decoded size are capped;
+// reading past either fails with *http.MaxBytesError.
+func (s *Server) openBody(w http.ResponseWriter, r *http.Request, maxBytes int64) (io.ReadCloser, error) {
+	wire := http.MaxBytesReader(w, r.Body, s.config.Body.MaxCompressedBytes)
+	body := &decodedBody{Reader: wire, closers: []io.Closer{wire}}
+
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(wire)
+		if err != nil {
+			return nil, fmt.Errorf("error reading gzip body: %w", err)
+		}
+		body.Reader, body.closers = gz, append(body.closers, gz)
+	case "deflate":
+		// Meant to be zlib-wrapped, but some clients send raw deflate
+		buffered := bufio.NewReader(wire)
+		if header, err := buffered.Peek(2); err == nil && isZlibHeader(header) {
+			zr, err := zlib.NewReader(buffered)
+			if err != nil {
+				return nil, fmt.Errorf("error reading deflate body: %w", err)
+			}
+			body.Reader, body.closers = zr, append(body.closers, zr)
+		} else {
+			fr := flate.NewReader(buffered)
+			body.Reader, body.closers = fr, append(body.closers, fr)
+		}
+	case "zstd":
+		zr, err := zstd.NewReader(wire, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(maxBytes)))
+		if err != nil {
+			return nil, fmt.Errorf("error reading zstd body: %w", err)
+		}
+		body.Reader, body.closers = zr, append(body.closers, zr.IOReadCloser())
+	default:
+		return nil, errUnsupportedEncoding
+	}
+
+	// A second cap on the decoded stream guards against compression bombs
+	decoded := http.MaxBytesReader(w, io.NopCloser(body.Reader), maxBytes)
+	body.Reader = decoded
+	return body, nil
+}
+
+// Function to tell whether two bytes start a zlib stream (RFC 1950)
+func isZlibHeader(b []byte) bool {
+	return b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+// Function to read a whole request body through openBody
+func (s *Server) readBody(w http.ResponseWriter, r *http.Request, maxBytes int64) ([]byte, error) {
+	body, err := s.openBody(w, r, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// Function to answer a request whose body could not be read
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, zstd.ErrWindowSizeExceeded), errors.Is(err, zstd.ErrDecoderSizeExceeded):
+		// zstd refuses frames that would need more memory than the limit
+		http.Error(w, "Decompressed request body is too large", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errUnsupportedEncoding):
+		http.Error(w, "Content-Encoding must be gzip, deflate, zstd or identity", http.StatusUnsupportedMediaType)
+	case errors.Is(err, errUnsupportedMediaType):
+		http.Error(w, "Content-Type must be application/json or application/x-ndjson", http.StatusUnsupportedMediaType)
+	default:
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+	}
+}
+
+// Function to decode an event after checking how deeply it nests
+func (s *Server) decodeEvent(data []byte, event *Event) error {
+	if err := checkDepth(data, s.config.Body.MaxDepth); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, event)
+}
+
+// Function to check that JSON does not nest objects and arrays deeper
+// than maxDepth. It does not validate the JSON otherwise.
+func checkDepth(data []byte, maxDepth int) error {
+	depth, inString, escaped := 0, false, false
+	for _, c := range data {
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+			if depth > maxDepth {
+				return fmt.Errorf("%w (more than %d levels)", errTooDeep, maxDepth)
+			}
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return nil
+}
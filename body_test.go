@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Function to compress data with a writer from one of the compress
+// packages
+func compress(t *testing.T, data []byte, newWriter func(io.Writer) (io.WriteCloser, error)) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := newWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadBody(t *testing.T) {
+	event := []byte(`{"kind":"Event","message":"hello"}`)
+	large := bytes.Repeat([]byte("a"), 4096)
+
+	gzipWriter := func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }
+	zlibWriter := func(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriter(w), nil }
+	flateWriter := func(w io.Writer) (io.WriteCloser, error) { return flate.NewWriter(w, flate.DefaultCompression) }
+	zstdWriter := func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) }
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		maxBytes int64
+		want     []byte
+		status   int
+	}{
+		{name: "identity", body: event, want: event},
+		{name: "explicit identity", encoding: "identity", body: event, want: event},
+		{name: "gzip", encoding: "gzip", body: compress(t, event, gzipWriter), want: event},
+		{name: "x-gzip, mixed case", encoding: " X-Gzip ", body: compress(t, event, gzipWriter), want: event},
+		{name: "zlib deflate", encoding: "deflate", body: compress(t, event, zlibWriter), want: event},
+		{name: "raw deflate", encoding: "deflate", body: compress(t, event, flateWriter), want: event},
+		{name: "zstd", encoding: "zstd", body: compress(t, event, zstdWriter), want: event},
+		{name: "unsupported encoding", encoding: "br", body: event, status: http.StatusUnsupportedMediaType},
+		{name: "corrupt gzip", encoding: "gzip", body: event, status: http.StatusBadRequest},
+		{name: "corrupt zstd", encoding: "zstd", body: event, status: http.StatusBadRequest},
+		{name: "plain body over the limit", body: large, maxBytes: 1024, status: http.StatusRequestEntityTooLarge},
+		{name: "compressed body under the wire limit", encoding: "gzip", body: compress(t, large, gzipWriter), maxBytes: 8192, want: large},
+		{name: "gzip bomb", encoding: "gzip", body: compress(t, large, gzipWriter), maxBytes: 1024, status: http.StatusRequestEntityTooLarge},
+		{name: "deflate bomb", encoding: "deflate", body: compress(t, large, zlibWriter), maxBytes: 1024, status: http.StatusRequestEntityTooLarge},
+		{name: "zstd bomb", encoding: "zstd", body: compress(t, large, zstdWriter), maxBytes: 1024, status: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{config: defaultConfig()}
+			s.config.Body.MaxCompressedBytes = 2048
+			if tt.maxBytes == 0 {
+				tt.maxBytes = 1024
+			}
+			r := httptest.NewRequest(http.MethodPost, "/event", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				r.Header.Set("Content-Encoding", tt.encoding)
+			}
+			w := httptest.NewRecorder()
+
+			got, err := s.readBody(w, r, tt.maxBytes)
+			if tt.status != 0 {
+				if err == nil {
+					t.Fatalf("read %d bytes, want status %d", len(got), tt.status)
+				}
+				writeBodyError(w, err)
+				if w.Code != tt.status {
+					t.Errorf("status %d (%v), want %d", w.Code, err, tt.status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadBodyCapsWireBytes(t *testing.T) {
+	s := &Server{config: defaultConfig()}
+	s.config.Body.MaxCompressedBytes = 16
+	r := httptest.NewRequest(http.MethodPost, "/event", strings.NewReader(strings.Repeat("a", 32)))
+	w := httptest.NewRecorder()
+	_, err := s.readBody(w, r, 1024)
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) || tooLarge.Limit != 16 {
+		t.Fatalf("error %v, want the 16 byte wire limit", err)
+	}
+}
+
+func TestCheckDepth(t *testing.T) {
+	tests := []struct {
+		name  string
+		json  string
+		depth int
+		err   bool
+	}{
+		{name: "flat", json: `{"a":1}`, depth: 1},
+		{name: "at the limit", json: `{"a":[{"b":1}]}`, depth: 3},
+		{name: "over the limit", json: `{"a":[{"b":1}]}`, depth: 2, err: true},
+		{name: "brackets in strings", json: `{"a":"[[[{{{"}`, depth: 1},
+		{name: "escaped quote in string", json: `{"a":"\"[[["}`, depth: 1},
+		{name: "escaped backslash ends string", json: `{"a":"\\","b":[[1]]}`, depth: 2, err: true},
+		{name: "scalar", json: `"x"`, depth: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDepth([]byte(tt.json), tt.depth)
+			if tt.err != errors.Is(err, errTooDeep) {
+				t.Errorf("error %v, want too deep %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+// BootstrapConfig controls the startup phase that waits for the cluster and
+// installs the index mappings
+type BootstrapConfig struct {
+	WaitForStatus  string        `yaml:"waitForStatus" json:"waitForStatus" env:"BOOTSTRAP_WAIT_FOR_STATUS"`
+	InitialBackoff time.Duration `yaml:"initialBackoff" json:"initialBackoff" env:"BOOTSTRAP_INITIAL_BACKOFF"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff" json:"maxBackoff" env:"BOOTSTRAP_MAX_BACKOFF"`
+}
+
+// Function to validate the bootstrap settings
+func (c BootstrapConfig) validate() error {
+	var errs []error
+	if c.WaitForStatus != "green" && c.WaitForStatus != "yellow" {
+		errs = append(errs, fmt.Errorf("bootstrap.waitForStatus: %q is not one of green, yellow", c.WaitForStatus))
+	}
+	if c.InitialBackoff <= 0 || c.MaxBackoff < c.InitialBackoff {
+		errs = append(errs, errors.New("bootstrap: initialBackoff must be positive and not exceed maxBackoff"))
+	}
+	return errors.Join(errs...)
+}
+
+// openSearchError is the error body OpenSearch returns for failed requests
+type openSearchError struct {
+	Error struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+	Status int `json:"status"`
+}
+
+// Function to decode an OpenSearch error body, tolerating non-JSON bodies
+func parseOpenSearchError(body []byte) openSearchError {
+	var out openSearchError
+	if err := json.Unmarshal(body, &out); err != nil {
+		out.Error.Reason = string(body)
+	}
+	return out
+}
+
+// Function to create an index with its mapping, or register the mapping
+// as an index template when the index name varies per event. An index that
+// already exists is not an error; any other rejection is.
+func ensureIndex(ctx context.Context, client *opensearch.Client, m managedIndex) error {
+	name := m.name
+	if !m.index.static() {
+		// During a rolling deploy an older pod must not downgrade the
+		// template a newer one installed
+		live, err := liveTemplateVersion(ctx, client, m)
+		if err != nil {
+			return err
+		}
+		if live > m.mapping.version {
+			log.Printf("Warning: index template %s is v%d, newer than v%d; leaving it alone", m.index.templateName(name), live, m.mapping.version)
+			return nil
+		}
+		return putIndexTemplate(ctx, client, m)
+	}
+
+	req := opensearchapi.IndicesCreateRequest{
+		Index: m.index.render(Event{}, time.Time{}),
+		Body:  strings.NewReader(m.mapping.body),
+	}
+
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return fmt.Errorf("error creating %s index: %w", name, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		respBody, _ := io.ReadAll(res.Body)
+		if parseOpenSearchError(respBody).Error.Type == "resource_already_exists_exception" {
+			return nil
+		}
+		return fmt.Errorf("error creating %s index: Status: %d, Response: %s", name, res.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+// Function to block until the cluster reaches the configured health
+func waitForCluster(ctx context.Context, client *opensearch.Client, c BootstrapConfig) error {
+	backoff := c.InitialBackoff
+	for {
+		err := checkClusterHealth(ctx, client, c.WaitForStatus)
+		if err == nil {
+			return nil
+		}
+		log.Printf("Waiting for OpenSearch (retrying in %s): %v", backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting for OpenSearch: %w", errors.Join(ctx.Err(), err))
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, c.MaxBackoff)
+	}
+}
+
+// Function to ask the cluster whether it has reached the given status
+func checkClusterHealth(ctx context.Context, client *opensearch.Client, status string) error {
+	req := opensearchapi.ClusterHealthRequest{
+		WaitForStatus: status,
+		Timeout:       10 * time.Second,
+	}
+
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return fmt.Errorf("error checking cluster health: %w", err)
+	}
+	defer res.Body.Close()
+
+	respBody, _ := io.ReadAll(res.Body)
+	if res.IsError() {
+		return fmt.Errorf("error checking cluster health: Status: %d, Response: %s", res.StatusCode, string(respBody))
+	}
+
+	var health struct {
+		Status   string `json:"status"`
+		TimedOut bool   `json:"timed_out"`
+	}
+	if err := json.Unmarshal(respBody, &health); err != nil {
+		return fmt.Errorf("error parsing cluster health: %w", err)
+	}
+	if health.TimedOut {
+		return fmt.Errorf("cluster status is %s, waiting for %s", health.Status, status)
+	}
+	return nil
+}
+
+// Function to wait for the cluster, install both index mappings and mark
+// the server ready. Mapping errors are retried with the same backoff as
+// the health check so a transient failure does not leave us not-ready.
+func (s *Server) bootstrap(ctx context.Context) error {
+	c := s.config.Bootstrap
+	backoff := c.InitialBackoff
+	for {
+		if err := waitForCluster(ctx, s.client, c); err != nil {
+			return err
+		}
+
+		var errs []error
+		for _, m := range s.managed {
+			errs = append(errs, installIndex(ctx, s.client, m))
+		}
+		err := errors.Join(errs...)
+		if err == nil {
+			break
+		}
+		log.Printf("Failed to install index mappings (retrying in %s): %v", backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up installing index mappings: %w", errors.Join(ctx.Err(), err))
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, c.MaxBackoff)
+	}
+
+	s.ready.Store(true)
+	log.Printf("Bootstrap complete, accepting events")
+	return nil
+}
+
+// Function to read the version of the installed index template, 0 if none
+func liveTemplateVersion(ctx context.Context, client *opensearch.Client, m managedIndex) (int, error) {
+	templateName := m.index.templateName(m.name)
+	res, err := opensearchapi.IndicesGetIndexTemplateRequest{Name: []string{templateName}}.Do(ctx, client)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching index template %s: %w", templateName, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return 0, nil
+	}
+	respBody, _ := io.ReadAll(res.Body)
+	if res.IsError() {
+		return 0, fmt.Errorf("error fetching index template %s: Status: %d, Response: %s", templateName, res.StatusCode, string(respBody))
+	}
+
+	var out struct {
+		IndexTemplates []struct {
+			IndexTemplate struct {
+				Version int `json:"version"`
+			} `json:"index_template"`
+		} `json:"index_templates"`
+	}
+	if err := json.Unmarshal(respBody, &out); err != nil {
+		return 0, fmt.Errorf("error parsing index template %s: %w", templateName, err)
+	}
+	if len(out.IndexTemplates) == 0 {
+		return 0, nil
+	}
+	return out.IndexTemplates[0].IndexTemplate.Version, nil
+}
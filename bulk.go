@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+// BulkConfig controls how documents are batched into _bulk requests. A
+// batch is sent when it reaches MaxDocs or MaxBytes, or FlushInterval
+// after the previous flush, whichever comes first. QueueSize counts units,
+// e.g. an event together with its metrics.
+type BulkConfig struct {
+	MaxDocs       int           `yaml:"maxDocs" json:"maxDocs" env:"BULK_MAX_DOCS"`
+	MaxBytes      int           `yaml:"maxBytes" json:"maxBytes" env:"BULK_MAX_BYTES"`
+	FlushInterval time.Duration `yaml:"flushInterval" json:"flushInterval" env:"BULK_FLUSH_INTERVAL"`
+	Workers       int           `yaml:"workers" json:"workers" env:"BULK_WORKERS"`
+	QueueSize     int           `yaml:"queueSize" json:"queueSize" env:"BULK_QUEUE_SIZE"`
+}
+
+// Function to validate the bulk settings
+func (c BulkConfig) validate() error {
+	var errs []error
+	if c.MaxDocs <= 0 {
+		errs = append(errs, errors.New("bulk.maxDocs: must be positive"))
+	}
+	if c.MaxBytes <= 0 {
+		errs = append(errs, errors.New("bulk.maxBytes: must be positive"))
+	}
+	if c.FlushInterval <= 0 {
+		errs = append(errs, errors.New("bulk.flushInterval: must be positive"))
+	}
+	if c.Workers <= 0 {
+		errs = append(errs, errors.New("bulk.workers: must be positive"))
+	}
+	if c.QueueSize <= 0 {
+		errs = append(errs, errors.New("bulk.queueSize: must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// bulkDoc is a document waiting for the next _bulk request. The action
+// defaults to "index"; "create" fails if the id is taken and "delete"
+// takes no body. An empty id lets OpenSearch generate one.
+type bulkDoc struct {
+	action   string
+	index    string
+	id       string
+	body     []byte
+	done     func(bulkResult)
+	deadline time.Time
+}
+
+// bulkResult is the outcome of indexing a single document. Duplicate is
+// set, without an error, when a create found the document already there.
+type bulkResult struct {
+	Index     string
+	ID        string
+	Status    int
+	Duplicate bool
+	Err       error
+}
+
+// bulkItemError is the per-document error reported in a _bulk response
+type bulkItemError struct {
+	Status int
+	Type   string
+	Reason string
+}
+
+func (e *bulkItemError) Error() string {
+	return fmt.Sprintf("error indexing document: Status: %d, Type: %s, Reason: %s", e.Status, e.Type, e.Reason)
+}
+
+var errBulkClosed = errors.New("bulk indexer is closed")
+
+// bulkIndexer batches documents for any index into _bulk requests
+type bulkIndexer struct {
+	client  *opensearch.Client
+	config  BulkConfig
+	retry   *retryPolicy
+	queue   chan []bulkDoc
+	workers chan struct{}
+	flushes sync.WaitGroup
+	done    chan struct{}
+
+	// closing is closed first, so that adds waiting for room give up
+	// before close takes the lock they hold
+	closing     chan struct{}
+	closingOnce sync.Once
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// Function to create a bulk indexer; call start before adding documents
+func newBulkIndexer(client *opensearch.Client, config BulkConfig, retry *retryPolicy) *bulkIndexer {
+	return &bulkIndexer{
+		client:  client,
+		config:  config,
+		retry:   retry,
+		queue:   make(chan []bulkDoc, config.QueueSize),
+		workers: make(chan struct{}, config.Workers),
+		done:    make(chan struct{}),
+		closing: make(chan struct{}),
+	}
+}
+
+// Function to start collecting queued documents into batches
+func (b *bulkIndexer) start() {
+	go b.run()
+}
+
+// Function to report how many units are queued but not yet batched
+func (b *bulkIndexer) depth() int {
+	return len(b.queue)
+}
+
+// Function to queue documents as a unit, which is sent in a single _bulk
+// request that keeps to ctx's deadline. It blocks while the queue is full
+// and fails if ctx ends first or the indexer is closed meanwhile.
+func (b *bulkIndexer) add(ctx context.Context, docs ...bulkDoc) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return errBulkClosed
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		for i := range docs {
+			docs[i].deadline = deadline
+		}
+	}
+
+	select {
+	case b.queue <- docs:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.closing:
+		return errBulkClosed
+	}
+}
+
+// Function to queue documents and wait for the result of each one. The
+// results are in the same order as docs.
+func (b *bulkIndexer) index(ctx context.Context, docs []bulkDoc) []bulkResult {
+	units := make([][]bulkDoc, len(docs))
+	for i, doc := range docs {
+		units[i] = []bulkDoc{doc}
+	}
+	results := make([]bulkResult, 0, len(docs))
+	for _, unit := range b.indexUnits(ctx, units) {
+		results = append(results, unit...)
+	}
+	return results
+}
+
+// Function to queue units of documents and wait for their results, in the
+// same shape as units
+func (b *bulkIndexer) indexUnits(ctx context.Context, units [][]bulkDoc) [][]bulkResult {
+	results := make([][]bulkResult, len(units))
+	var wg sync.WaitGroup
+	for u, unit := range units {
+		unit = slices.Clone(unit)
+		wg.Add(1)
+		collectResults(unit, func(res []bulkResult) {
+			results[u] = res
+			wg.Done()
+		})
+		if err := b.add(ctx, unit...); err != nil {
+			for _, doc := range unit {
+				doc.done(bulkResult{Index: doc.index, Err: err})
+			}
+		}
+	}
+	wg.Wait()
+	return results
+}
+
+// Function to set the callbacks of docs so that done is called once, with
+// the results of all of them in order
+func collectResults(docs []bulkDoc, done func([]bulkResult)) {
+	if len(docs) == 0 {
+		done(nil)
+		return
+	}
+	results := make([]bulkResult, len(docs))
+	var remaining atomic.Int64
+	remaining.Store(int64(len(docs)))
+	for i := range docs {
+		docs[i].done = func(res bulkResult) {
+			results[i] = res
+			if remaining.Add(-1) == 0 {
+				done(results)
+			}
+		}
+	}
+}
+
+// Function to stop accepting documents, flush what is queued and wait for
+// the outstanding _bulk requests, giving up when ctx is done
+func (b *bulkIndexer) close(ctx context.Context) error {
+	b.closingOnce.Do(func() { close(b.closing) })
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return waitForGroup(ctx, &b.flushes)
+}
+
+// Function to collect queued units into batches until the queue is
+// closed
+func (b *bulkIndexer) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.config.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []bulkDoc
+	size := 0
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		b.dispatch(batch)
+		batch, size = nil, 0
+	}
+
+	for {
+		select {
+		case unit, ok := <-b.queue:
+			if !ok {
+				flush()
+				return
+			}
+			unitSize := 0
+			for _, doc := range unit {
+				unitSize += len(doc.body)
+			}
+			// Keep batches within the limits unless a single unit exceeds
+			// them; units are never split
+			if len(batch) > 0 && (size+unitSize > b.config.MaxBytes || len(batch)+len(unit) > b.config.MaxDocs) {
+				flush()
+			}
+			batch = append(batch, unit...)
+			size += unitSize
+			if len(batch) >= b.config.MaxDocs || size >= b.config.MaxBytes {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Function to send a batch on a free worker. It blocks while every worker
+// is busy, which in turn lets the queue fill up and push back on callers.
+func (b *bulkIndexer) dispatch(batch []bulkDoc) {
+	b.workers <- struct{}{}
+	b.flushes.Add(1)
+	go func() {
+		defer func() {
+			<-b.workers
+			b.flushes.Done()
+		}()
+		ctx, cancel := batchContext(batch)
+		defer cancel()
+		results := b.write(ctx, batch)
+		for i, doc := range batch {
+			if doc.done != nil {
+				doc.done(results[i])
+			}
+		}
+	}()
+}
+
+// Function to bound a batch by the deadlines its documents were queued
+// with. The batch may run until the latest of them, so that no caller is
+// cut short; a document queued without one leaves the batch unbounded.
+func batchContext(batch []bulkDoc) (context.Context, context.CancelFunc) {
+	var latest time.Time
+	for _, doc := range batch {
+		if doc.deadline.IsZero() {
+			return context.WithCancel(context.Background())
+		}
+		if doc.deadline.After(latest) {
+			latest = doc.deadline
+		}
+	}
+	return context.WithDeadline(context.Background(), latest)
+}
+
+// Function to send a batch, retrying the documents that failed for a
+// retryable reason until they succeed, the attempts run out, ctx ends or
+// the retry timeout passes
+func (b *bulkIndexer) write(ctx context.Context, batch []bulkDoc) []bulkResult {
+	ctx, cancel := context.WithTimeout(ctx, b.retry.config.Timeout)
+	defer cancel()
+
+	results := make([]bulkResult, len(batch))
+	pending := make([]int, len(batch))
+	for i := range batch {
+		pending[i] = i
+	}
+
+	for attempt := 1; ; attempt++ {
+		docs := make([]bulkDoc, len(pending))
+		for i, idx := range pending {
+			docs[i] = batch[idx]
+		}
+
+		var retry []int
+		var retryAfter time.Duration
+		var lastErr error
+		for i, res := range b.send(ctx, docs) {
+			results[pending[i]] = res
+			if res.Err != nil && retryable(res.Err) {
+				retry = append(retry, pending[i])
+				lastErr = res.Err
+				var statusErr *statusError
+				if errors.As(res.Err, &statusErr) {
+					retryAfter = max(retryAfter, statusErr.RetryAfter)
+				}
+			}
+		}
+		if len(retry) == 0 {
+			return results
+		}
+		if attempt >= b.retry.config.MaxAttempts {
+			b.retry.countExhausted(ctx, len(retry))
+			return results
+		}
+		if err := b.retry.wait(ctx, attempt, retryAfter); err != nil {
+			b.retry.countExhausted(ctx, len(retry))
+			for _, idx := range retry {
+				results[idx].Err = fmt.Errorf("%w (gave up retrying: %v)", results[idx].Err, err)
+			}
+			return results
+		}
+		b.retry.countRetries(ctx, len(retry), retryReason(lastErr))
+		pending = retry
+	}
+}
+
+// bulkResponse is the part of a _bulk response we read
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Index  string `json:"_index"`
+		ID     string `json:"_id"`
+		Status int    `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// Function to send one _bulk request and return a result per document. A
+// failure of the request as a whole is reported against every document.
+func (b *bulkIndexer) send(ctx context.Context, batch []bulkDoc) []bulkResult {
+	results := make([]bulkResult, len(batch))
+	fail := func(err error) []bulkResult {
+		for i, doc := range batch {
+			results[i] = bulkResult{Index: doc.index, Err: err}
+		}
+		return results
+	}
+
+	var body bytes.Buffer
+	for _, doc := range batch {
+		name := doc.action
+		if name == "" {
+			name = "index"
+		}
+		meta := map[string]string{"_index": doc.index}
+		if doc.id != "" {
+			meta["_id"] = doc.id
+		}
+		action, err := json.Marshal(map[string]any{name: meta})
+		if err != nil {
+			return fail(fmt.Errorf("error encoding bulk action: %w", err))
+		}
+		body.Write(action)
+		body.WriteByte('\n')
+		if name != "delete" {
+			body.Write(doc.body)
+			body.WriteByte('\n')
+		}
+	}
+
+	res, err := opensearchapi.BulkRequest{Body: &body}.Do(ctx, b.client)
+	if err != nil {
+		return fail(fmt.Errorf("error sending bulk request: %w", err))
+	}
+	defer res.Body.Close()
+
+	respBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fail(fmt.Errorf("error reading bulk response: %w", err))
+	}
+	if res.IsError() {
+		return fail(&statusError{
+			Status:     res.StatusCode,
+			RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+			Body:       string(respBody),
+		})
+	}
+
+	var parsed bulkResponse
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		return fail(fmt.Errorf("error parsing bulk response: %w", err))
+	}
+	if len(parsed.Items) != len(batch) {
+		return fail(fmt.Errorf("bulk response has %d items for %d documents", len(parsed.Items), len(batch)))
+	}
+
+	// Items come back in request order, each keyed by its action
+	for i, item := range parsed.Items {
+		for _, r := range item {
+			results[i] = bulkResult{Index: r.Index, ID: r.ID, Status: r.Status}
+			if batch[i].action == "create" && r.Status == http.StatusConflict {
+				results[i].Duplicate = true
+			} else if r.Error != nil {
+				results[i].Err = &bulkItemError{Status: r.Status, Type: r.Error.Type, Reason: r.Error.Reason}
+			} else if r.Status >= 300 {
+				results[i].Err = &bulkItemError{Status: r.Status}
+			}
+		}
+	}
+	return results
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// Function to start a stand-in OpenSearch that answers each _bulk request
+// with the next of responses, and to create a bulk indexer that uses it
+func newTestBulkIndexer(t *testing.T, responses ...func(w http.ResponseWriter, actions []string)) (*bulkIndexer, *[][]string) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The client checks what it is talking to before its first request
+		if r.URL.Path != "/_bulk" {
+			fmt.Fprint(w, `{"version":{"number":"2.11.0","distribution":"opensearch"}}`)
+			return
+		}
+		var actions []string
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var line map[string]json.RawMessage
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				continue
+			}
+			for _, name := range []string{"index", "create", "delete"} {
+				if _, ok := line[name]; ok {
+					actions = append(actions, name)
+				}
+			}
+		}
+		mu.Lock()
+		n := len(requests)
+		requests = append(requests, actions)
+		mu.Unlock()
+		if n >= len(responses) {
+			t.Errorf("unexpected bulk request %d", n+1)
+			http.Error(w, "unexpected", http.StatusInternalServerError)
+			return
+		}
+		responses[n](w, actions)
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := opensearch.NewClient(opensearch.Config{Addresses: []string{server.URL}, DisableRetry: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := defaultConfig()
+	config.Retry.InitialBackoff, config.Retry.MaxBackoff = time.Millisecond, time.Millisecond
+	retry, err := newRetryPolicy(config.Retry, noop.NewMeterProvider().Meter("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newBulkIndexer(client, config.Bulk, retry), &requests
+}
+
+// Function to answer a _bulk request with one item per action, given as
+// the status and error type of each
+func bulkItems(items ...string) func(w http.ResponseWriter, actions []string) {
+	return func(w http.ResponseWriter, actions []string) {
+		var out []string
+		for i, item := range items {
+			status, errType, _ := strings.Cut(item, " ")
+			entry := fmt.Sprintf(`"_index":"events","_id":"%d","status":%s`, i, status)
+			if errType != "" {
+				entry += fmt.Sprintf(`,"error":{"type":%q,"reason":"because"}`, errType)
+			}
+			out = append(out, fmt.Sprintf(`{%q:{%s}}`, actions[i], entry))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"took":1,"errors":true,"items":[%s]}`, strings.Join(out, ","))
+	}
+}
+
+func TestBulkSendParsesItems(t *testing.T) {
+	b, _ := newTestBulkIndexer(t, bulkItems(
+		"201",
+		"200",
+		"409 version_conflict_engine_exception",
+		"409 version_conflict_engine_exception",
+		"400 mapper_parsing_exception",
+		"404",
+	))
+	docs := []bulkDoc{
+		{action: "create", index: "events", id: "0", body: []byte(`{}`)},
+		{index: "events", id: "1", body: []byte(`{}`)},
+		{action: "create", index: "events", id: "2", body: []byte(`{}`)},
+		{index: "events", id: "3", body: []byte(`{}`)},
+		{index: "events", id: "4", body: []byte(`{}`)},
+		{action: "delete", index: "events", id: "5"},
+	}
+
+	tests := []struct {
+		status    int
+		duplicate bool
+		errType   string
+	}{
+		{status: 201},
+		{status: 200},
+		{status: 409, duplicate: true},
+		{status: 409, errType: "version_conflict_engine_exception"},
+		{status: 400, errType: "mapper_parsing_exception"},
+		{status: 404, errType: ""},
+	}
+	results := b.send(context.Background(), docs)
+	for i, want := range tests {
+		got := results[i]
+		if got.Status != want.status || got.Duplicate != want.duplicate || got.ID != fmt.Sprint(i) {
+			t.Errorf("item %d: status %d, duplicate %v, id %s; want %d, %v, %d", i, got.Status, got.Duplicate, got.ID, want.status, want.duplicate, i)
+		}
+		failed := want.status >= 300 && !want.duplicate
+		if failed != (got.Err != nil) {
+			t.Errorf("item %d: error %v, want failure %v", i, got.Err, failed)
+			continue
+		}
+		var itemErr *bulkItemError
+		if failed && (!errors.As(got.Err, &itemErr) || itemErr.Status != want.status || itemErr.Type != want.errType) {
+			t.Errorf("item %d: error %v, want a %d %q item error", i, got.Err, want.status, want.errType)
+		}
+	}
+}
+
+func TestBulkSendFailsEveryDocument(t *testing.T) {
+	tests := []struct {
+		name     string
+		response func(w http.ResponseWriter, actions []string)
+		status   int
+	}{
+		{
+			name: "request rejected",
+			response: func(w http.ResponseWriter, actions []string) {
+				w.Header().Set("Retry-After", "3")
+				http.Error(w, `{"error":"too many requests"}`, http.StatusTooManyRequests)
+			},
+			status: http.StatusTooManyRequests,
+		},
+		{
+			name: "unparsable response",
+			response: func(w http.ResponseWriter, actions []string) {
+				fmt.Fprint(w, `not json`)
+			},
+		},
+		{
+			name:     "too few items",
+			response: bulkItems("201"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := newTestBulkIndexer(t, tt.response)
+			docs := []bulkDoc{{index: "events", body: []byte(`{}`)}, {index: "events", body: []byte(`{}`)}}
+			for i, res := range b.send(context.Background(), docs) {
+				if res.Err == nil {
+					t.Fatalf("document %d succeeded", i)
+				}
+				var statusErr *statusError
+				if tt.status != 0 && (!errors.As(res.Err, &statusErr) || statusErr.Status != tt.status || statusErr.RetryAfter != 3*time.Second) {
+					t.Errorf("document %d: error %v, want status %d with Retry-After 3s", i, res.Err, tt.status)
+				}
+			}
+		})
+	}
+}
+
+func TestBulkWriteRetriesOnlyRetryableItems(t *testing.T) {
+	b, requests := newTestBulkIndexer(t,
+		bulkItems("201", "429 es_rejected_execution_exception", "400 mapper_parsing_exception"),
+		bulkItems("201"),
+	)
+	docs := []bulkDoc{
+		{index: "events", body: []byte(`{}`)},
+		{index: "events", body: []byte(`{}`)},
+		{index: "events", body: []byte(`{}`)},
+	}
+	results := b.write(context.Background(), docs)
+
+	if len(*requests) != 2 || len((*requests)[1]) != 1 {
+		t.Fatalf("requests %v, want the rejected document sent again alone", *requests)
+	}
+	if results[0].Err != nil || results[1].Err != nil {
+		t.Errorf("results %+v, want the first two stored", results)
+	}
+	if results[2].Err == nil {
+		t.Error("mapping error was not reported")
+	}
+}
+
+func TestBulkCloseReleasesBlockedAdd(t *testing.T) {
+	config := defaultConfig().Bulk
+	config.QueueSize = 1
+	// Not started, so nothing drains the queue
+	b := newBulkIndexer(nil, config, nil)
+	if err := b.add(context.Background(), bulkDoc{index: "events"}); err != nil {
+		t.Fatal(err)
+	}
+
+	added := make(chan error, 1)
+	go func() { added <- b.add(context.Background(), bulkDoc{index: "events"}) }()
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	closed := make(chan struct{})
+	go func() {
+		b.close(ctx)
+		close(closed)
+	}()
+
+	select {
+	case err := <-added:
+		if !errors.Is(err, errBulkClosed) {
+			t.Errorf("blocked add returned %v, want %v", err, errBulkClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("add still blocked after close")
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("close outlasted its context")
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/debug"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+// Build information, set at link time with e.g.
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// Function to print the command summary
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, `Usage: go-opensearch-logging <command> [flags]
+
+Commands:
+  serve          run the event ingest server (default)
+  init-indices   create or update index mappings and templates, then exit
+  check          validate the configuration and OpenSearch connectivity
+  replay-dlq     re-submit dead-lettered documents, e.g. after a mapping fix
+  version        print build information
+
+Run "go-opensearch-logging <command> -h" for the flags of a command.
+`)
+}
+
+// Function to print build information, falling back to the VCS stamp the
+// Go toolchain embeds when no link-time values were set
+func printVersion(w io.Writer) {
+	rev, date := commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && rev == "":
+				rev = setting.Value
+			case setting.Key == "vcs.time" && date == "":
+				date = setting.Value
+			}
+		}
+	}
+	if rev == "" {
+		rev = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	fmt.Fprintf(w, "go-opensearch-logging %s\ncommit: %s\nbuilt: %s\ngo: %s %s/%s\n",
+		version, rev, date, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// Function to create or update both index mappings (the init-indices
+// command). Meant to run as a one-off Kubernetes Job ahead of the ingest
+// pods.
+func runInitIndices(args []string) int {
+	config, ok := loadValidConfig("init-indices", args, nil)
+	if !ok {
+		return 0
+	}
+
+	client, err := newOpenSearchClient(config)
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+	indices, err := newIndexNames(config.Indices)
+	if err != nil {
+		log.Printf("Invalid index names: %v", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := waitForCluster(ctx, client, config.Bootstrap); err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+
+	managed, err := newManagedIndices(indices, config.Indices.FieldOverrides, config.DeadLetter.Target == "index")
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+
+	var errs []error
+	for _, m := range managed {
+		if err := installIndex(ctx, client, m); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Printf("Index %s (%s) is at mapping v%d (%s)", m.name, m.index.pattern(), m.mapping.version, m.mapping.checksum)
+	}
+	if err := errors.Join(errs...); err != nil {
+		log.Printf("Failed to initialize indices:\n%v", err)
+		return 1
+	}
+	return 0
+}
+
+// Function to validate the configuration and run the OpenSearch
+// dependency checks once (the check command)
+func runCheck(args []string) int {
+	config, ok := loadValidConfig("check", args, nil)
+	if !ok {
+		return 0
+	}
+	fmt.Println("configuration: ok")
+
+	client, err := newOpenSearchClient(config)
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+	indices, err := newIndexNames(config.Indices)
+	if err != nil {
+		log.Printf("Invalid index names: %v", err)
+		return 1
+	}
+
+	managed, err := newManagedIndices(indices, config.Indices.FieldOverrides, config.DeadLetter.Target == "index")
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+
+	server := &Server{config: config, client: client, indices: indices, managed: managed}
+	results := server.runChecks(context.Background(), server.dependencyChecks())
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	code := 0
+	for _, res := range results {
+		fmt.Fprintf(tw, "%s:\t%s\t%.1fms\t%s\n", res.Name, res.Status, res.LatencyMS, res.Error)
+		if res.Status != "ok" {
+			code = 1
+		}
+	}
+	tw.Flush()
+	return code
+}
+
+// Function to re-submit dead-lettered documents to their original index
+// (the replay-dlq command). Replayed entries are removed from the
+// dead-letter store; the rest stay for a later run.
+func runReplayDLQ(args []string) int {
+	var dryRun bool
+	var errorTypes string
+	config, ok := loadValidConfig("replay-dlq", args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&dryRun, "dry-run", false, "list the dead letters that would be replayed without writing anything")
+		fs.StringVar(&errorTypes, "error-type", "", "comma-separated error types to replay, e.g. mapper_parsing_exception (default all)")
+	})
+	if !ok {
+		return 0
+	}
+	if config.DeadLetter.Target == "none" {
+		log.Printf("Dead-lettering is disabled (deadLetter.target is none), nothing to replay")
+		return 1
+	}
+
+	client, err := newOpenSearchClient(config)
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+	indices, err := newIndexNames(config.Indices)
+	if err != nil {
+		log.Printf("Invalid index names: %v", err)
+		return 1
+	}
+	retry, err := newRetryPolicy(config.Retry, otel.GetMeterProvider().Meter("replay-dlq"))
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	bulk := newBulkIndexer(client, config.Bulk, retry)
+	bulk.start()
+	defer bulk.close(context.Background())
+
+	replayer := &deadLetterReplayer{
+		bulk:       bulk,
+		errorTypes: splitList(errorTypes),
+		dryRun:     dryRun,
+		out:        os.Stdout,
+	}
+	var stats replayStats
+	if config.DeadLetter.Target == "file" {
+		stats, err = replayer.replayFile(ctx, config.DeadLetter.Path)
+	} else {
+		stats, err = replayer.replayIndex(ctx, client, indices.deadLetters.render(Event{}, time.Time{}))
+	}
+
+	if dryRun {
+		fmt.Printf("%d dead letters would be replayed\n", stats.matched)
+	} else {
+		fmt.Printf("%d dead letters matched, %d replayed, %d failed again\n", stats.matched, stats.replayed, stats.failed)
+	}
+	if err != nil {
+		log.Printf("%v", err)
+		return 1
+	}
+	if stats.failed > 0 {
+		return 1
+	}
+	return 0
+}
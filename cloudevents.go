@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CloudEventsConfig controls how CloudEvents sent to /event become events.
+// Mapping assigns context attributes, by name, to dotted JSON paths into
+// the event; an empty path leaves the attribute unmapped. Attributes that
+// are not mapped, extensions included, are kept in the event's attributes.
+// An event whose kind is set by neither is of kind CloudEvent.
+type CloudEventsConfig struct {
+	Mapping map[string]string `yaml:"mapping" json:"mapping"`
+}
+
+// Function to validate the CloudEvents mapping
+func (c CloudEventsConfig) validate() error {
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(c.Mapping)) {
+		if !validAttributeName(name) {
+			errs = append(errs, fmt.Errorf("cloudEvents.mapping: %q is not a CloudEvents attribute name", name))
+			continue
+		}
+		if name == "data" || name == "data_base64" {
+			errs = append(errs, fmt.Errorf("cloudEvents.mapping: %q is the event data, not an attribute", name))
+			continue
+		}
+		if path := c.Mapping[name]; path != "" {
+			if _, err := eventField(path); err != nil {
+				errs = append(errs, fmt.Errorf("cloudEvents.mapping.%s: %w", name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Function to check a CloudEvents attribute name: lower-case ASCII
+// letters and digits only
+func validAttributeName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+var errUnsupportedDataType = errors.New("unsupported CloudEvents data content type")
+
+// Function to tell whether a request carries a CloudEvent, in structured
+// mode (the whole event as the body) or binary mode (attributes in ce-
+// headers, data as the body)
+func isCloudEvent(r *http.Request) bool {
+	if r.Header.Get("Ce-Specversion") != "" {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/cloudevents+json"
+}
+
+// Function to decode a CloudEvent sent to /event into a valid event. It
+// also returns an idempotency key derived from source and id, which the
+// specification requires to be unique per event.
+func (s *Server) decodeCloudEvent(r *http.Request, body []byte) (Event, string, error) {
+	var attrs map[string]string
+	var data []byte
+	var err error
+	if r.Header.Get("Ce-Specversion") != "" {
+		attrs, err = binaryAttributes(r.Header)
+		data = body
+	} else {
+		attrs, data, err = s.structuredCloudEvent(body)
+	}
+	if err != nil {
+		return Event{}, "", err
+	}
+
+	if attrs["specversion"] != "1.0" {
+		return Event{}, "", fmt.Errorf("unsupported CloudEvents specversion %q", attrs["specversion"])
+	}
+	for _, name := range []string{"id", "source", "type"} {
+		if attrs[name] == "" {
+			return Event{}, "", fmt.Errorf("CloudEvent is missing the %s attribute", name)
+		}
+	}
+
+	var event Event
+	if len(data) > 0 {
+		if err := s.decodeCloudEventData(attrs["datacontenttype"], data, &event); err != nil {
+			return Event{}, "", err
+		}
+	}
+
+	// Attributes take precedence over what the data says
+	v := reflect.ValueOf(&event).Elem()
+	for _, name := range slices.Sorted(maps.Keys(attrs)) {
+		path := s.config.CloudEvents.Mapping[name]
+		if path == "" {
+			if event.Attributes == nil {
+				event.Attributes = map[string]string{}
+			}
+			event.Attributes[name] = attrs[name]
+			continue
+		}
+		// Paths are checked when the configuration is loaded
+		index, _ := eventField(path)
+		if err := setField(v.FieldByIndex(index), attrs[name]); err != nil {
+			return Event{}, "", fmt.Errorf("CloudEvents attribute %s: %w", name, err)
+		}
+	}
+
+	// CloudEvents carry no severity or kind; the data may still give them.
+	// The kind names indices and labels metrics, so it falls back to a
+	// fixed one rather than to anything as varied as the source.
+	if event.Type == "" {
+		event.Type = "Normal"
+	}
+	if event.InvolvedObject.Kind == "" {
+		event.InvolvedObject.Kind = "CloudEvent"
+	}
+	if err := validateEvent(event); err != nil {
+		return Event{}, "", err
+	}
+	return event, "cloudevent\x00" + attrs["source"] + "\x00" + attrs["id"], nil
+}
+
+// Function to read the attributes of a binary mode CloudEvent from its
+// ce- headers, which are percent-encoded. The Content-Type is the data's.
+func binaryAttributes(header http.Header) (map[string]string, error) {
+	attrs := map[string]string{}
+	for key, values := range header {
+		name, ok := strings.CutPrefix(strings.ToLower(key), "ce-")
+		if !ok || len(values) == 0 {
+			continue
+		}
+		if !validAttributeName(name) {
+			return nil, fmt.Errorf("%q is not a CloudEvents attribute name", name)
+		}
+		value, err := url.PathUnescape(values[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid CloudEvents attribute %s: %w", name, err)
+		}
+		attrs[name] = value
+	}
+	if contentType := header.Get("Content-Type"); contentType != "" {
+		attrs["datacontenttype"] = contentType
+	}
+	return attrs, nil
+}
+
+// Function to split a structured mode CloudEvent into its attributes and
+// data. Attribute values that are not strings, such as integer or boolean
+// extensions, are kept in their JSON form.
+func (s *Server) structuredCloudEvent(body []byte) (map[string]string, []byte, error) {
+	if err := checkDepth(body, s.config.Body.MaxDepth); err != nil {
+		return nil, nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, nil, err
+	}
+
+	attrs := map[string]string{}
+	var data []byte
+	for name, raw := range fields {
+		switch name {
+		case "data":
+			data = raw
+			continue
+		case "data_base64":
+			var encoded string
+			if err := json.Unmarshal(raw, &encoded); err != nil {
+				return nil, nil, fmt.Errorf("invalid data_base64: %w", err)
+			}
+			decoded, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid data_base64: %w", err)
+			}
+			data = decoded
+			continue
+		}
+		if !validAttributeName(name) {
+			return nil, nil, fmt.Errorf("%q is not a CloudEvents attribute name", name)
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = string(raw)
+		}
+		attrs[name] = value
+	}
+	return attrs, data, nil
+}
+
+// Function to decode a CloudEvent's data into the event. Data is JSON
+// unless its content type says otherwise. A JSON object is read like an
+// /event body, a JSON or plain text string becomes the message; other data
+// is not understood.
+func (s *Server) decodeCloudEventData(contentType string, data []byte, event *Event) error {
+	mediaType := "application/json"
+	if contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return fmt.Errorf("%w: %q", errUnsupportedDataType, contentType)
+		}
+	}
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var message string
+		if err := json.Unmarshal(data, &message); err == nil {
+			event.Metadata.Message = message
+			return nil
+		}
+		if err := s.decodeEvent(data, event); err != nil {
+			return fmt.Errorf("invalid CloudEvents data: %w", err)
+		}
+		return nil
+	case strings.HasPrefix(mediaType, "text/"):
+		event.Metadata.Message = string(data)
+		return nil
+	}
+	return fmt.Errorf("%w: %q", errUnsupportedDataType, mediaType)
+}
+
+// Function to assign an attribute to a string or integer field of the
+// event
+func setField(field reflect.Value, value string) error {
+	if field.Kind() == reflect.Int {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		field.SetInt(int64(n))
+		return nil
+	}
+	field.SetString(value)
+	return nil
+}
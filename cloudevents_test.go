@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCloudEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  map[string]string
+		body    string
+		want    Event
+		key     string
+		err     string
+		errType error
+	}{
+		{
+			name: "binary mode with JSON data",
+			header: map[string]string{
+				"Ce-Specversion": "1.0",
+				"Ce-Id":          "a-1",
+				"Ce-Source":      "%2Fcheckout",
+				"Ce-Type":        "order.created",
+				"Ce-Time":        "2025-06-15T10:00:00Z",
+				"Ce-Subject":     "order-42",
+				"Content-Type":   "application/json",
+			},
+			body: `{"type":"Warning","metadata":{"message":"stock low"}}`,
+			want: func() Event {
+				var e Event
+				e.Metadata.Message = "stock low"
+				e.InvolvedObject.Kind, e.InvolvedObject.Name = "CloudEvent", "/checkout"
+				e.Action, e.Type, e.CorrelationID, e.EventTime = "order.created", "Warning", "a-1", "2025-06-15T10:00:00Z"
+				e.Attributes = map[string]string{"specversion": "1.0", "subject": "order-42", "datacontenttype": "application/json"}
+				return e
+			}(),
+			key: "cloudevent\x00/checkout\x00a-1",
+		},
+		{
+			name: "binary mode with text data",
+			header: map[string]string{
+				"Ce-Specversion": "1.0",
+				"Ce-Id":          "a-2",
+				"Ce-Source":      "checkout",
+				"Ce-Type":        "order.created",
+				"Content-Type":   "text/plain; charset=utf-8",
+			},
+			body: "plain words",
+			want: func() Event {
+				var e Event
+				e.Metadata.Message = "plain words"
+				e.InvolvedObject.Kind, e.InvolvedObject.Name = "CloudEvent", "checkout"
+				e.Action, e.Type, e.CorrelationID = "order.created", "Normal", "a-2"
+				e.Attributes = map[string]string{"specversion": "1.0", "datacontenttype": "text/plain; charset=utf-8"}
+				return e
+			}(),
+			key: "cloudevent\x00checkout\x00a-2",
+		},
+		{
+			name:   "structured mode with extensions and string data",
+			header: map[string]string{"Content-Type": "application/cloudevents+json"},
+			body:   `{"specversion":"1.0","id":"b-1","source":"billing","type":"invoice.paid","priority":3,"data":"paid in full"}`,
+			want: func() Event {
+				var e Event
+				e.Metadata.Message = "paid in full"
+				e.InvolvedObject.Kind, e.InvolvedObject.Name = "CloudEvent", "billing"
+				e.Action, e.Type, e.CorrelationID = "invoice.paid", "Normal", "b-1"
+				e.Attributes = map[string]string{"specversion": "1.0", "priority": "3"}
+				return e
+			}(),
+			key: "cloudevent\x00billing\x00b-1",
+		},
+		{
+			name:   "structured mode with base64 data keeps the data's kind",
+			header: map[string]string{"Content-Type": "application/cloudevents+json"},
+			body:   `{"specversion":"1.0","id":"b-2","source":"billing","type":"invoice.paid","datacontenttype":"application/json","data_base64":"eyJpbnZvbHZlZE9iamVjdCI6eyJraW5kIjoiSW52b2ljZSJ9fQ=="}`,
+			want: func() Event {
+				var e Event
+				e.InvolvedObject.Kind, e.InvolvedObject.Name = "Invoice", "billing"
+				e.Action, e.Type, e.CorrelationID = "invoice.paid", "Normal", "b-2"
+				e.Attributes = map[string]string{"specversion": "1.0", "datacontenttype": "application/json"}
+				return e
+			}(),
+			key: "cloudevent\x00billing\x00b-2",
+		},
+		{
+			name:   "binary mode without a source",
+			header: map[string]string{"Ce-Specversion": "1.0", "Ce-Id": "c-1", "Ce-Type": "t"},
+			err:    "missing the source attribute",
+		},
+		{
+			name:   "unsupported specversion",
+			header: map[string]string{"Content-Type": "application/cloudevents+json"},
+			body:   `{"specversion":"0.3","id":"c-2","source":"s","type":"t"}`,
+			err:    `unsupported CloudEvents specversion "0.3"`,
+		},
+		{
+			name:   "invalid attribute name",
+			header: map[string]string{"Content-Type": "application/cloudevents+json"},
+			body:   `{"specversion":"1.0","id":"c-3","source":"s","type":"t","Bad-Name":"x"}`,
+			err:    "is not a CloudEvents attribute name",
+		},
+		{
+			name:    "binary data of an unsupported type",
+			header:  map[string]string{"Ce-Specversion": "1.0", "Ce-Id": "c-4", "Ce-Source": "s", "Ce-Type": "t", "Content-Type": "application/octet-stream"},
+			body:    "\x00\x01",
+			errType: errUnsupportedDataType,
+		},
+		{
+			name:   "time that is not RFC 3339",
+			header: map[string]string{"Ce-Specversion": "1.0", "Ce-Id": "c-5", "Ce-Source": "s", "Ce-Type": "t", "Ce-Time": "yesterday"},
+			err:    "eventTime",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{config: defaultConfig()}
+			r := httptest.NewRequest(http.MethodPost, "/event", strings.NewReader(tt.body))
+			for key, value := range tt.header {
+				r.Header.Set(key, value)
+			}
+			if !isCloudEvent(r) {
+				t.Fatal("request is not recognized as a CloudEvent")
+			}
+
+			event, key, err := s.decodeCloudEvent(r, []byte(tt.body))
+			switch {
+			case tt.errType != nil:
+				if !errors.Is(err, tt.errType) {
+					t.Fatalf("error %v, want %v", err, tt.errType)
+				}
+				return
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error %v, want %q", err, tt.err)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(event, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", event, tt.want)
+			}
+			if key != tt.key {
+				t.Errorf("key %q, want %q", key, tt.key)
+			}
+		})
+	}
+}
+
+func TestIsCloudEvent(t *testing.T) {
+	tests := []struct {
+		header map[string]string
+		want   bool
+	}{
+		{header: map[string]string{"Content-Type": "application/json"}},
+		{header: map[string]string{"Content-Type": "application/cloudevents+json; charset=utf-8"}, want: true},
+		{header: map[string]string{"Content-Type": "application/json", "Ce-Specversion": "1.0"}, want: true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/event", nil)
+		for key, value := range tt.header {
+			r.Header.Set(key, value)
+		}
+		if got := isCloudEvent(r); got != tt.want {
+			t.Errorf("isCloudEvent(%v) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the effective service configuration. Values are resolved in
+// order: built-in defaults, the config file, environment variables, flags.
+type Config struct {
+	OpenSearch   OpenSearchConfig   `yaml:"opensearch" json:"opensearch"`
+	Server       ServerConfig       `yaml:"server" json:"server"`
+	Indices      IndicesConfig      `yaml:"indices" json:"indices"`
+	Trackers     TrackersConfig     `yaml:"trackers" json:"trackers"`
+	Bootstrap    BootstrapConfig    `yaml:"bootstrap" json:"bootstrap"`
+	Health       HealthConfig       `yaml:"health" json:"health"`
+	Bulk         BulkConfig         `yaml:"bulk" json:"bulk"`
+	Ingest       IngestConfig       `yaml:"ingest" json:"ingest"`
+	Spool        SpoolConfig        `yaml:"spool" json:"spool"`
+	Retry        RetryConfig        `yaml:"retry" json:"retry"`
+	DeadLetter   DeadLetterConfig   `yaml:"deadLetter" json:"deadLetter"`
+	Backpressure BackpressureConfig `yaml:"backpressure" json:"backpressure"`
+	Idempotency  IdempotencyConfig  `yaml:"idempotency" json:"idempotency"`
+	Batch        BatchConfig        `yaml:"batch" json:"batch"`
+	Body         BodyConfig         `yaml:"body" json:"body"`
+	Kubernetes   KubernetesConfig   `yaml:"kubernetes" json:"kubernetes"`
+	CloudEvents  CloudEventsConfig  `yaml:"cloudEvents" json:"cloudEvents"`
+	GRPC         GRPCConfig         `yaml:"grpc" json:"grpc"`
+	Syslog       SyslogConfig       `yaml:"syslog" json:"syslog"`
+}
+
+// OpenSearchConfig holds the cluster connection settings
+type OpenSearchConfig struct {
+	Addresses   []string          `yaml:"addresses" json:"addresses" env:"OPENSEARCH_ADDRESSES"`
+	Username    string            `yaml:"username" json:"username" env:"OPENSEARCH_USERNAME"`
+	Password    string            `yaml:"password" json:"password" env:"OPENSEARCH_PASSWORD" secret:"true"`
+	TLS         TLSConfig         `yaml:"tls" json:"tls"`
+	Credentials CredentialsConfig `yaml:"credentials" json:"credentials"`
+	AWS         AWSConfig         `yaml:"aws" json:"aws"`
+
+	// CompressRequests gzips request bodies sent to OpenSearch
+	CompressRequests bool `yaml:"compressRequests" json:"compressRequests" env:"OPENSEARCH_COMPRESS_REQUESTS"`
+}
+
+// ServerConfig holds the HTTP listener settings
+type ServerConfig struct {
+	ListenAddress   string        `yaml:"listenAddress" json:"listenAddress" env:"LISTEN_ADDRESS"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" json:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT"`
+}
+
+// IndicesConfig holds the index name templates documents are written to;
+// see indexTemplate for the supported placeholders
+type IndicesConfig struct {
+	Prefix  string `yaml:"prefix" json:"prefix" env:"INDEX_PREFIX"`
+	Events  string `yaml:"events" json:"events" env:"EVENTS_INDEX"`
+	Metrics string `yaml:"metrics" json:"metrics" env:"METRICS_INDEX"`
+
+	// DeadLetters is the index used when deadLetter.target is index
+	DeadLetters string `yaml:"deadLetters" json:"deadLetters" env:"DEAD_LETTER_INDEX"`
+
+	// FieldOverrides replaces generated field mappings, keyed by index
+	// ("events" or "metrics") and then by dotted field path
+	FieldOverrides map[string]fieldOverrides `yaml:"fieldOverrides" json:"fieldOverrides"`
+}
+
+// TrackersConfig toggles the individual metric trackers
+type TrackersConfig struct {
+	Frequency bool `yaml:"frequency" json:"frequency" env:"TRACK_FREQUENCY"`
+	Duration  bool `yaml:"duration" json:"duration" env:"TRACK_DURATION"`
+	Status    bool `yaml:"status" json:"status" env:"TRACK_STATUS"`
+	ErrorRate bool `yaml:"errorRate" json:"errorRate" env:"TRACK_ERROR_RATE"`
+}
+
+// Function to build the configuration used when nothing overrides it
+func defaultConfig() Config {
+	return Config{
+		OpenSearch: OpenSearchConfig{
+			Addresses: []string{"https://localhost:9200"},
+			Username:  "admin",
+			TLS: TLSConfig{
+				MinVersion: "1.2",
+			},
+			Credentials: CredentialsConfig{
+				Provider: "static",
+			},
+			AWS: AWSConfig{
+				Service: "es",
+			},
+		},
+		Server: ServerConfig{
+			ListenAddress:   ":8080",
+			ShutdownTimeout: 25 * time.Second,
+		},
+		Indices: IndicesConfig{
+			Events:      "events",
+			Metrics:     "metrics2",
+			DeadLetters: "dead-letters",
+		},
+		Trackers: TrackersConfig{
+			Frequency: true,
+			Duration:  true,
+			Status:    true,
+			ErrorRate: true,
+		},
+		Bootstrap: BootstrapConfig{
+			WaitForStatus:  "yellow",
+			InitialBackoff: time.Second,
+			MaxBackoff:     30 * time.Second,
+		},
+		Health: HealthConfig{
+			CheckTimeout: 2 * time.Second,
+		},
+		Bulk: BulkConfig{
+			MaxDocs:       500,
+			MaxBytes:      5 << 20,
+			FlushInterval: 200 * time.Millisecond,
+			Workers:       2,
+			QueueSize:     10000,
+		},
+		Ingest: IngestConfig{
+			Mode:          "async",
+			StatusTTL:     time.Hour,
+			StatusEntries: 100000,
+		},
+		Spool: SpoolConfig{
+			Dir:          "/var/lib/go-opensearch-logging/spool",
+			SegmentBytes: 16 << 20,
+			MaxBytes:     1 << 30,
+			MaxAge:       24 * time.Hour,
+			SyncWrites:   true,
+			BatchRecords: 500,
+		},
+		Retry: RetryConfig{
+			MaxAttempts:    5,
+			InitialBackoff: 200 * time.Millisecond,
+			MaxBackoff:     10 * time.Second,
+			Timeout:        time.Minute,
+		},
+		DeadLetter: DeadLetterConfig{
+			Target: "none",
+			Path:   "/var/lib/go-opensearch-logging/dead-letters.ndjson",
+		},
+		Backpressure: BackpressureConfig{
+			MaxInFlight:    512,
+			QueueWait:      250 * time.Millisecond,
+			WarningReserve: 0.2,
+			RetryAfter:     time.Second,
+		},
+		Idempotency: IdempotencyConfig{
+			Header: "Idempotency-Key",
+			Key:    []string{"correlationId", "involvedObject.uuid", "eventTime", "action"},
+		},
+		Batch: BatchConfig{
+			MaxItems: 1000,
+			MaxBytes: 10 << 20,
+		},
+		Body: BodyConfig{
+			MaxCompressedBytes: 10 << 20,
+			MaxEventBytes:      1 << 20,
+			MaxDepth:           32,
+		},
+		Kubernetes: KubernetesConfig{
+			API: "events.k8s.io/v1",
+		},
+		CloudEvents: CloudEventsConfig{
+			Mapping: map[string]string{
+				"id":     "correlationId",
+				"source": "involvedObject.name",
+				"type":   "action",
+				"time":   "eventTime",
+			},
+		},
+		GRPC: GRPCConfig{
+			Reflection: true,
+		},
+		Syslog: SyslogConfig{
+			MaxMessageBytes:       64 << 10,
+			FailureSampleInterval: time.Minute,
+		},
+	}
+}
+
+// Function to load the configuration from file, environment and flags.
+// commandFlags, if set, registers flags specific to the command.
+func loadConfig(cmd string, args []string, commandFlags func(*flag.FlagSet)) (Config, bool, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("go-opensearch-logging "+cmd, flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or JSON config file")
+	printConfig := fs.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	listen := fs.String("listen", "", "HTTP listen address, e.g. :8080")
+	addresses := fs.String("opensearch-addresses", "", "comma-separated list of OpenSearch node URLs")
+	eventsIndex := fs.String("events-index", "", "name template of the events index")
+	metricsIndex := fs.String("metrics-index", "", "name template of the metrics index")
+	if commandFlags != nil {
+		commandFlags(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, false, err
+	}
+
+	if *configPath != "" {
+		if err := loadConfigFile(*configPath, &cfg); err != nil {
+			return cfg, false, err
+		}
+	}
+
+	if err := applyEnvOverrides(reflect.ValueOf(&cfg).Elem(), os.LookupEnv); err != nil {
+		return cfg, false, err
+	}
+
+	if *listen != "" {
+		cfg.Server.ListenAddress = *listen
+	}
+	if *addresses != "" {
+		cfg.OpenSearch.Addresses = splitList(*addresses)
+	}
+	if *eventsIndex != "" {
+		cfg.Indices.Events = *eventsIndex
+	}
+	if *metricsIndex != "" {
+		cfg.Indices.Metrics = *metricsIndex
+	}
+
+	return cfg, *printConfig, nil
+}
+
+// Function to decode a config file; JSON is accepted as a subset of YAML
+func loadConfigFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Function to apply environment variables named by `env` struct tags
+func applyEnvOverrides(v reflect.Value, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnvOverrides(value, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := field.Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		raw, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setFromString(value, raw); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Function to assign a string to a config field of any supported kind
+func setFromString(v reflect.Value, raw string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", v.Type())
+		}
+		v.Set(reflect.ValueOf(splitList(raw)))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// Function to split a comma-separated list, dropping empty entries
+func splitList(raw string) []string {
+	var out []string
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// Function to validate the configuration and report every problem found
+func (c Config) validate() error {
+	var errs []error
+
+	if len(c.OpenSearch.Addresses) == 0 {
+		errs = append(errs, errors.New("opensearch.addresses: at least one address is required"))
+	}
+	for _, addr := range c.OpenSearch.Addresses {
+		u, err := url.Parse(addr)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("opensearch.addresses: %q is not a valid http(s) URL", addr))
+		}
+	}
+	if c.OpenSearch.Password != "" && c.OpenSearch.Username == "" {
+		errs = append(errs, errors.New("opensearch.username: required when a password is set"))
+	}
+	if err := c.OpenSearch.TLS.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.OpenSearch.Credentials.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.OpenSearch.AWS.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.ListenAddress); err != nil {
+		errs = append(errs, fmt.Errorf("server.listenAddress: %q is not a valid host:port: %v", c.Server.ListenAddress, err))
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("server.shutdownTimeout: must be positive"))
+	}
+
+	if _, err := newIndexNames(c.Indices); err != nil {
+		errs = append(errs, err)
+	}
+	for name := range c.Indices.FieldOverrides {
+		if name != "events" && name != "metrics" {
+			errs = append(errs, fmt.Errorf("indices.fieldOverrides: unknown index %q, expected events or metrics", name))
+		}
+	}
+	if err := c.Bootstrap.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Health.CheckTimeout <= 0 {
+		errs = append(errs, errors.New("health.checkTimeout: must be positive"))
+	}
+	if err := c.Bulk.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Ingest.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Spool.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Retry.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.DeadLetter.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Backpressure.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Idempotency.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Batch.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Body.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Kubernetes.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.CloudEvents.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.GRPC.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Syslog.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Function to check a name against the OpenSearch index naming rules
+func validateIndexName(name string) error {
+	if name == "" {
+		return errors.New("index name is required")
+	}
+	if name != strings.ToLower(name) {
+		return fmt.Errorf("%q must be lowercase", name)
+	}
+	if strings.HasPrefix(name, "_") || strings.HasPrefix(name, "-") || strings.HasPrefix(name, "+") {
+		return fmt.Errorf("%q must not start with _, - or +", name)
+	}
+	if strings.ContainsAny(name, `\/*?"<>| ,#:`) {
+		return fmt.Errorf("%q contains characters not allowed in index names", name)
+	}
+	return nil
+}
+
+// Function to render the configuration as YAML with secrets redacted
+func (c Config) redacted() ([]byte, error) {
+	v := reflect.ValueOf(&c).Elem()
+	redactSecrets(v)
+	return yaml.Marshal(c)
+}
+
+// Function to blank out fields tagged `secret:"true"`
+func redactSecrets(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			redactSecrets(value)
+			continue
+		}
+		if field.Tag.Get("secret") == "true" && value.Kind() == reflect.String && value.String() != "" {
+			value.SetString("REDACTED")
+		}
+	}
+}
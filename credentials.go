@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CredentialsConfig selects where OpenSearch credentials come from
+type CredentialsConfig struct {
+	Provider     string `yaml:"provider" json:"provider" env:"OPENSEARCH_CREDENTIALS_PROVIDER"`
+	Token        string `yaml:"token" json:"token" env:"OPENSEARCH_TOKEN" secret:"true"`
+	UsernameFile string `yaml:"usernameFile" json:"usernameFile" env:"OPENSEARCH_USERNAME_FILE"`
+	PasswordFile string `yaml:"passwordFile" json:"passwordFile" env:"OPENSEARCH_PASSWORD_FILE"`
+	TokenFile    string `yaml:"tokenFile" json:"tokenFile" env:"OPENSEARCH_TOKEN_FILE"`
+}
+
+// Function to validate the credential provider settings
+func (c CredentialsConfig) validate() error {
+	switch c.Provider {
+	case "static", "env":
+		return nil
+	case "file":
+		if c.PasswordFile == "" && c.TokenFile == "" {
+			return errors.New("opensearch.credentials: the file provider needs passwordFile or tokenFile")
+		}
+		if c.PasswordFile != "" && c.UsernameFile == "" {
+			return errors.New("opensearch.credentials: usernameFile is required with passwordFile")
+		}
+		return nil
+	default:
+		return fmt.Errorf("opensearch.credentials.provider: %q is not one of static, file, env", c.Provider)
+	}
+}
+
+// Credentials authenticate a single request; a bearer token wins over
+// basic auth when both are present
+type Credentials struct {
+	Username string
+	Password string
+	Token    string
+}
+
+// Function to set the Authorization header for these credentials
+func (c Credentials) apply(req *http.Request) {
+	switch {
+	case c.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	case c.Username != "":
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+}
+
+// CredentialProvider hands out the current credentials. Refresh forces the
+// provider to re-read its source and reports whether anything changed.
+type CredentialProvider interface {
+	Credentials() (Credentials, error)
+	Refresh() (bool, error)
+}
+
+// staticCredentials never change
+type staticCredentials struct {
+	creds Credentials
+}
+
+func (p staticCredentials) Credentials() (Credentials, error) { return p.creds, nil }
+
+func (p staticCredentials) Refresh() (bool, error) { return false, nil }
+
+// envCredentials reads the process environment on every call
+type envCredentials struct{}
+
+func (envCredentials) Credentials() (Credentials, error) {
+	return Credentials{
+		Username: os.Getenv("OPENSEARCH_USERNAME"),
+		Password: os.Getenv("OPENSEARCH_PASSWORD"),
+		Token:    os.Getenv("OPENSEARCH_TOKEN"),
+	}, nil
+}
+
+func (envCredentials) Refresh() (bool, error) { return false, nil }
+
+// fileCredentials reads credentials from mounted secret files and re-reads
+// them whenever a file's modification time changes
+type fileCredentials struct {
+	usernameFile string
+	passwordFile string
+	tokenFile    string
+
+	mu       sync.Mutex
+	creds    Credentials
+	modTimes map[string]time.Time
+}
+
+// Function to build a file provider and read the initial credentials
+func newFileCredentials(c CredentialsConfig) (*fileCredentials, error) {
+	p := &fileCredentials{
+		usernameFile: c.UsernameFile,
+		passwordFile: c.PasswordFile,
+		tokenFile:    c.TokenFile,
+	}
+	if _, err := p.Refresh(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *fileCredentials) Credentials() (Credentials, error) {
+	if _, err := p.reload(false); err != nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		// Kubernetes swaps secret mounts via symlinks, so a read can race
+		// the swap; the previous credentials are still the best guess
+		log.Printf("Warning: keeping previous OpenSearch credentials: %v", err)
+		return p.creds, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.creds, nil
+}
+
+func (p *fileCredentials) Refresh() (bool, error) {
+	return p.reload(true)
+}
+
+// Function to re-read the secret files if they changed, or always if forced
+func (p *fileCredentials) reload(force bool) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	files := []string{p.usernameFile, p.passwordFile, p.tokenFile}
+	if !force && p.modTimes != nil {
+		stale := false
+		for _, path := range files {
+			if path == "" {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return false, fmt.Errorf("error reading credentials file: %w", err)
+			}
+			if !info.ModTime().Equal(p.modTimes[path]) {
+				stale = true
+			}
+		}
+		if !stale {
+			return false, nil
+		}
+	}
+
+	var next Credentials
+	modTimes := make(map[string]time.Time)
+	for _, f := range []struct {
+		path string
+		dst  *string
+	}{
+		{p.usernameFile, &next.Username},
+		{p.passwordFile, &next.Password},
+		{p.tokenFile, &next.Token},
+	} {
+		if f.path == "" {
+			continue
+		}
+		info, err := os.Stat(f.path)
+		if err != nil {
+			return false, fmt.Errorf("error reading credentials file: %w", err)
+		}
+		data, err := os.ReadFile(f.path)
+		if err != nil {
+			return false, fmt.Errorf("error reading credentials file: %w", err)
+		}
+		*f.dst = strings.TrimSpace(string(data))
+		modTimes[f.path] = info.ModTime()
+	}
+
+	changed := next != p.creds
+	if changed && p.modTimes != nil {
+		log.Printf("Reloaded OpenSearch credentials from disk")
+	}
+	p.creds = next
+	p.modTimes = modTimes
+	return changed, nil
+}
+
+// Function to build the credential provider described by the config
+func newCredentialProvider(c OpenSearchConfig) (CredentialProvider, error) {
+	switch c.Credentials.Provider {
+	case "file":
+		return newFileCredentials(c.Credentials)
+	case "env":
+		return envCredentials{}, nil
+	default:
+		return staticCredentials{creds: Credentials{
+			Username: c.Username,
+			Password: c.Password,
+			Token:    c.Credentials.Token,
+		}}, nil
+	}
+}
+
+// authTransport authenticates every outgoing request with the provider's
+// current credentials, so a rotation applies from the next request on
+// without disturbing requests already in flight
+type authTransport struct {
+	base     http.RoundTripper
+	provider CredentialProvider
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	creds, err := t.provider.Credentials()
+	if err != nil {
+		return nil, fmt.Errorf("error resolving OpenSearch credentials: %w", err)
+	}
+
+	res, err := t.base.RoundTrip(t.authenticate(req, creds))
+	if err != nil || (res.StatusCode != http.StatusUnauthorized && res.StatusCode != http.StatusForbidden) {
+		return res, err
+	}
+
+	// The secret may have rotated since it was last read; re-read it once
+	// and retry only if that produced different credentials
+	changed, refreshErr := t.provider.Refresh()
+	if refreshErr != nil {
+		log.Printf("Warning: failed to refresh OpenSearch credentials after %d: %v", res.StatusCode, refreshErr)
+		return res, nil
+	}
+	if !changed || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		return res, nil
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return res, nil
+		}
+		retry.Body = body
+	}
+	creds, err = t.provider.Credentials()
+	if err != nil {
+		return res, nil
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+
+	log.Printf("Retrying OpenSearch request with refreshed credentials after %d", res.StatusCode)
+	return t.base.RoundTrip(t.authenticate(retry, creds))
+}
+
+// Function to copy the request with the given credentials applied
+func (t *authTransport) authenticate(req *http.Request, creds Credentials) *http.Request {
+	out := req.Clone(req.Context())
+	out.Header.Del("Authorization")
+	creds.apply(out)
+	return out
+}
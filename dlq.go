@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+// DeadLetterConfig controls where documents that could not be written are
+// kept for the replay-dlq command: "none", "file" (an NDJSON file at Path)
+// or "index" (the indices.deadLetters index)
+type DeadLetterConfig struct {
+	Target string `yaml:"target" json:"target" env:"DEAD_LETTER_TARGET"`
+	Path   string `yaml:"path" json:"path" env:"DEAD_LETTER_PATH"`
+}
+
+// Function to validate the dead-letter settings
+func (c DeadLetterConfig) validate() error {
+	switch c.Target {
+	case "none", "index":
+	case "file":
+		if c.Path == "" {
+			return errors.New("deadLetter.path: required when the target is file")
+		}
+	default:
+		return fmt.Errorf("deadLetter.target: %q is not one of none, file, index", c.Target)
+	}
+	return nil
+}
+
+// errSpoolExpired marks documents given up on after spool.maxAge
+var errSpoolExpired = errors.New("expired in the spool")
+
+// requestMeta is what we keep of the request that delivered an event
+type requestMeta struct {
+	ReceivedAt time.Time `json:"received_at" opensearch:"date"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+}
+
+// Function to capture the metadata of an incoming request
+func newRequestMeta(r *http.Request) requestMeta {
+	return requestMeta{
+		ReceivedAt: time.Now().UTC(),
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+		Path:       r.URL.Path,
+		RequestID:  r.Header.Get("X-Request-Id"),
+	}
+}
+
+// Function to describe events that did not arrive over HTTP; path names
+// the source
+func sourceMeta(path string) requestMeta {
+	return requestMeta{ReceivedAt: time.Now().UTC(), Path: path}
+}
+
+// deadLetter is a document that could not be written, why, and how it
+// arrived
+type deadLetter struct {
+	Timestamp   time.Time       `json:"@timestamp" opensearch:"date"`
+	IngestionID string          `json:"ingestion_id"`
+	Kind        string          `json:"kind"`
+	Index       string          `json:"index"`
+	DocumentID  string          `json:"document_id,omitempty"`
+	Status      int             `json:"status,omitempty"`
+	ErrorType   string          `json:"error_type"`
+	ErrorReason string          `json:"error_reason" opensearch:"text,keyword"`
+	Request     requestMeta     `json:"request"`
+	Document    json.RawMessage `json:"document" opensearch:"disabled"`
+}
+
+// Function to fill in the error fields of a dead letter from a failure
+func (dl *deadLetter) setError(err error) {
+	var itemErr *bulkItemError
+	var statusErr *statusError
+	switch {
+	case errors.As(err, &itemErr):
+		dl.Status, dl.ErrorType, dl.ErrorReason = itemErr.Status, itemErr.Type, itemErr.Reason
+	case errors.As(err, &statusErr):
+		parsed := parseOpenSearchError([]byte(statusErr.Body))
+		dl.Status, dl.ErrorType, dl.ErrorReason = statusErr.Status, parsed.Error.Type, parsed.Error.Reason
+	case errors.Is(err, errSpoolExpired):
+		dl.Status, dl.ErrorType, dl.ErrorReason = 0, "spool_expired", err.Error()
+	default:
+		dl.Status, dl.ErrorType, dl.ErrorReason = 0, "transport", err.Error()
+	}
+	if dl.ErrorType == "" {
+		dl.ErrorType = "http_" + strconv.Itoa(dl.Status)
+	}
+}
+
+// deadLetterSink stores dead letters; done is called once the write is
+// durable or has failed
+type deadLetterSink interface {
+	write(dl deadLetter, done func(error))
+	close(ctx context.Context) error
+}
+
+// Function to create the configured dead-letter sink, nil for none
+func newDeadLetterSink(config Config, client *opensearch.Client, indices indexNames, retry *retryPolicy) (deadLetterSink, error) {
+	switch config.DeadLetter.Target {
+	case "file":
+		if err := os.MkdirAll(filepath.Dir(config.DeadLetter.Path), 0o750); err != nil {
+			return nil, fmt.Errorf("error creating dead-letter directory: %w", err)
+		}
+		return &fileDeadLetters{path: config.DeadLetter.Path}, nil
+	case "index":
+		// A separate indexer, so that dead-lettering from a bulk worker
+		// never waits on the queue that worker drains
+		bulk := newBulkIndexer(client, config.Bulk, retry)
+		bulk.start()
+		return &indexDeadLetters{
+			index:   indices.deadLetters.render(Event{}, time.Time{}),
+			bulk:    bulk,
+			timeout: config.Server.ShutdownTimeout,
+		}, nil
+	}
+	return nil, nil
+}
+
+// fileDeadLetters appends dead letters to an NDJSON file. The file is
+// opened for every write so that replay-dlq can move it aside safely.
+type fileDeadLetters struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (f *fileDeadLetters) write(dl deadLetter, done func(error)) {
+	line, err := json.Marshal(dl)
+	if err != nil {
+		done(fmt.Errorf("error encoding dead letter: %w", err))
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	done(appendLines(f.path, [][]byte{line}))
+}
+
+func (f *fileDeadLetters) close(context.Context) error {
+	return nil
+}
+
+// indexDeadLetters writes dead letters to an OpenSearch index. A write
+// gives up after timeout, so that a bulk worker reporting a failure never
+// outlasts the shutdown drain waiting for room in the queue.
+type indexDeadLetters struct {
+	index   string
+	bulk    *bulkIndexer
+	timeout time.Duration
+}
+
+func (d *indexDeadLetters) write(dl deadLetter, done func(error)) {
+	body, err := json.Marshal(dl)
+	if err != nil {
+		done(fmt.Errorf("error encoding dead letter: %w", err))
+		return
+	}
+	doc := bulkDoc{index: d.index, body: body, done: func(res bulkResult) { done(res.Err) }}
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	if err := d.bulk.add(ctx, doc); err != nil {
+		done(fmt.Errorf("error queueing dead letter: %w", err))
+	}
+}
+
+func (d *indexDeadLetters) close(ctx context.Context) error {
+	return d.bulk.close(ctx)
+}
+
+// Function to append lines to a file and sync it
+func appendLines(path string, lines [][]byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", path, err)
+	}
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("error syncing %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// Function to record a document's result and dead-letter it if it could
+// not be written
+func (s *Server) recordDelivery(id string, i int, o outgoingDoc, body []byte, meta requestMeta, res bulkResult) {
+	s.recordResult(id, i, o, res)
+	if res.Err == nil || s.deadLetters == nil {
+		return
+	}
+
+	dl := deadLetter{
+		Timestamp:   time.Now().UTC(),
+		IngestionID: id,
+		Kind:        o.kind,
+		Index:       o.index,
+		DocumentID:  o.id,
+		Request:     meta,
+		Document:    body,
+	}
+	dl.setError(res.Err)
+	s.deadLetters.write(dl, func(err error) {
+		if err != nil {
+			log.Printf("Failed to dead-letter %s (ingestion %s): %v", o.kind, id, err)
+			return
+		}
+		s.statuses.mark(id, i, stateDeadLettered, nil)
+	})
+}
+
+// replayStats summarizes a replay-dlq run
+type replayStats struct {
+	matched, replayed, failed int
+}
+
+// deadLetterReplayer re-submits dead letters to their original index
+type deadLetterReplayer struct {
+	bulk       *bulkIndexer
+	errorTypes []string
+	dryRun     bool
+	out        io.Writer
+}
+
+// Function to tell whether a dead letter passes the error-type filter
+func (r *deadLetterReplayer) matches(dl deadLetter) bool {
+	if len(r.errorTypes) == 0 {
+		return true
+	}
+	for _, t := range r.errorTypes {
+		if dl.ErrorType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to re-submit dead letters, returning the error of each one
+// (nil when it was written)
+func (r *deadLetterReplayer) replay(ctx context.Context, dls []deadLetter) []error {
+	errs := make([]error, len(dls))
+	if r.dryRun {
+		for _, dl := range dls {
+			fmt.Fprintf(r.out, "would replay\t%s\t%s\t%s\t%s\n", dl.Timestamp.Format(time.RFC3339), dl.Kind, dl.Index, dl.ErrorType)
+		}
+		return errs
+	}
+
+	docs := make([]bulkDoc, len(dls))
+	for i, dl := range dls {
+		docs[i] = outgoingDoc{index: dl.Index, id: dl.DocumentID}.bulkDoc(dl.Document)
+	}
+	for i, res := range r.bulk.index(ctx, docs) {
+		errs[i] = res.Err
+		if res.Err != nil {
+			fmt.Fprintf(r.out, "failed\t%s\t%s\t%s\t%v\n", dls[i].IngestionID, dls[i].Kind, dls[i].Index, res.Err)
+		}
+	}
+	return errs
+}
+
+// Function to replay the dead letters of an NDJSON file. The file is
+// first moved aside so the server can keep appending to a fresh one;
+// entries that are filtered out or fail again are appended back.
+func (r *deadLetterReplayer) replayFile(ctx context.Context, path string) (replayStats, error) {
+	source := path
+	if !r.dryRun {
+		source = path + ".replaying"
+		if _, err := os.Stat(source); err == nil {
+			fmt.Fprintf(r.out, "resuming the interrupted replay of %s\n", source)
+		} else if err := os.Rename(path, source); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return replayStats{}, nil
+			}
+			return replayStats{}, fmt.Errorf("error claiming %s: %w", path, err)
+		}
+	}
+
+	data, err := os.ReadFile(source)
+	if errors.Is(err, os.ErrNotExist) {
+		return replayStats{}, nil
+	}
+	if err != nil {
+		return replayStats{}, fmt.Errorf("error reading %s: %w", source, err)
+	}
+
+	var stats replayStats
+	var keep [][]byte
+	var matched []deadLetter
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 64<<20)
+	for sc.Scan() {
+		line := append([]byte(nil), sc.Bytes()...)
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var dl deadLetter
+		if err := json.Unmarshal(line, &dl); err != nil {
+			log.Printf("Warning: keeping unreadable dead letter: %v", err)
+			keep = append(keep, line)
+			continue
+		}
+		if !r.matches(dl) {
+			keep = append(keep, line)
+			continue
+		}
+		matched = append(matched, dl)
+	}
+	if err := sc.Err(); err != nil {
+		return stats, fmt.Errorf("error reading %s: %w", source, err)
+	}
+
+	stats.matched = len(matched)
+	errs := r.replay(ctx, matched)
+	if r.dryRun {
+		return stats, nil
+	}
+	for i, err := range errs {
+		if err == nil {
+			stats.replayed++
+			continue
+		}
+		stats.failed++
+		dl := matched[i]
+		dl.Timestamp = time.Now().UTC()
+		dl.setError(err)
+		line, _ := json.Marshal(dl)
+		keep = append(keep, line)
+	}
+
+	if len(keep) > 0 {
+		if err := appendLines(path, keep); err != nil {
+			return stats, fmt.Errorf("error putting back %d dead letters (they remain in %s): %w", len(keep), source, err)
+		}
+	}
+	if err := os.Remove(source); err != nil {
+		return stats, fmt.Errorf("error removing %s: %w", source, err)
+	}
+	return stats, nil
+}
+
+// deadLetterHits is the part of a search response we read
+type deadLetterHits struct {
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []struct {
+			ID     string     `json:"_id"`
+			Source deadLetter `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// Function to replay the dead letters stored in an index, deleting each
+// one once it has been written
+func (r *deadLetterReplayer) replayIndex(ctx context.Context, client *opensearch.Client, index string) (replayStats, error) {
+	query := map[string]any{"match_all": map[string]any{}}
+	if len(r.errorTypes) > 0 {
+		query = map[string]any{"terms": map[string]any{"error_type": r.errorTypes}}
+	}
+	body, err := json.Marshal(map[string]any{"query": query, "sort": []string{"_doc"}})
+	if err != nil {
+		return replayStats{}, err
+	}
+
+	size := 500
+	res, err := opensearchapi.SearchRequest{
+		Index:  []string{index},
+		Body:   bytes.NewReader(body),
+		Scroll: time.Minute,
+		Size:   &size,
+	}.Do(ctx, client)
+	page, err := readDeadLetterHits(res, err, index)
+	if err != nil {
+		return replayStats{}, err
+	}
+	// Each page may carry a new scroll ID; the last one is cleared
+	scrollID := page.ScrollID
+	defer func() {
+		if scrollID != "" {
+			opensearchapi.ClearScrollRequest{ScrollID: []string{scrollID}}.Do(context.Background(), client)
+		}
+	}()
+
+	var stats replayStats
+	for len(page.Hits.Hits) > 0 {
+		dls := make([]deadLetter, len(page.Hits.Hits))
+		for i, hit := range page.Hits.Hits {
+			dls[i] = hit.Source
+		}
+		stats.matched += len(dls)
+
+		var replayed []bulkDoc
+		for i, err := range r.replay(ctx, dls) {
+			switch {
+			case r.dryRun:
+			case err != nil:
+				stats.failed++
+			default:
+				stats.replayed++
+				replayed = append(replayed, bulkDoc{action: "delete", index: index, id: page.Hits.Hits[i].ID})
+			}
+		}
+		for _, res := range r.bulk.index(ctx, replayed) {
+			if res.Err != nil {
+				log.Printf("Warning: replayed dead letter %s was not deleted: %v", res.ID, res.Err)
+			}
+		}
+
+		if page.ScrollID == "" {
+			break
+		}
+		res, err := opensearchapi.ScrollRequest{ScrollID: page.ScrollID, Scroll: time.Minute}.Do(ctx, client)
+		if page, err = readDeadLetterHits(res, err, index); err != nil {
+			return stats, err
+		}
+		if page.ScrollID != "" {
+			scrollID = page.ScrollID
+		}
+	}
+	return stats, nil
+}
+
+// Function to decode one page of dead-letter search results
+func readDeadLetterHits(res *opensearchapi.Response, err error, index string) (deadLetterHits, error) {
+	var page deadLetterHits
+	if err != nil {
+		return page, fmt.Errorf("error searching %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	respBody, _ := io.ReadAll(res.Body)
+	if res.IsError() {
+		return page, fmt.Errorf("error searching %s: Status: %d, Response: %s", index, res.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	if err := json.Unmarshal(respBody, &page); err != nil {
+		return page, fmt.Errorf("error parsing %s search results: %w", index, err)
+	}
+	return page, nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// deadLetterStore is a stand-in OpenSearch for replays. Documents for the
+// index "broken" fail with a mapping error and a create of the id "dup"
+// finds it already there. Search results come back a page at a time,
+// each page under a new scroll ID.
+type deadLetterStore struct {
+	mu      sync.Mutex
+	pages   [][]string
+	written []string
+	deleted []string
+	cleared []string
+}
+
+func (d *deadLetterStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.URL.Path == "/_bulk":
+		d.bulk(w, r.Body)
+	case strings.HasSuffix(r.URL.Path, "/_search"):
+		d.page(w, 0)
+	case r.Method == http.MethodPost && r.URL.Path == "/_search/scroll":
+		var n int
+		fmt.Sscanf(r.URL.Query().Get("scroll_id"), "scroll-%d", &n)
+		d.page(w, n+1)
+	case r.Method == http.MethodDelete:
+		d.cleared = append(d.cleared, strings.TrimPrefix(r.URL.Path, "/_search/scroll/"))
+		fmt.Fprint(w, `{"succeeded":true}`)
+	default:
+		fmt.Fprint(w, `{"version":{"number":"2.11.0","distribution":"opensearch"}}`)
+	}
+}
+
+// Function to answer a _bulk request
+func (d *deadLetterStore) bulk(w http.ResponseWriter, body io.Reader) {
+	var items []string
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		var line map[string]struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			continue
+		}
+		for action, meta := range line {
+			status, errType := http.StatusCreated, ""
+			switch {
+			case action == "delete":
+				status = http.StatusOK
+				d.deleted = append(d.deleted, meta.ID)
+			case meta.Index == "broken":
+				status, errType = http.StatusBadRequest, "mapper_parsing_exception"
+			case action == "create" && meta.ID == "dup":
+				status, errType = http.StatusConflict, "version_conflict_engine_exception"
+			default:
+				d.written = append(d.written, meta.Index+"/"+meta.ID)
+			}
+			item := fmt.Sprintf(`"_index":%q,"_id":%q,"status":%d`, meta.Index, meta.ID, status)
+			if errType != "" {
+				item += fmt.Sprintf(`,"error":{"type":%q,"reason":"because"}`, errType)
+			}
+			items = append(items, fmt.Sprintf(`{%q:{%s}}`, action, item))
+		}
+		// Skip the document line
+		if _, ok := line["delete"]; !ok {
+			scanner.Scan()
+		}
+	}
+	fmt.Fprintf(w, `{"took":1,"errors":true,"items":[%s]}`, strings.Join(items, ","))
+}
+
+// Function to answer with the nth page of dead letters, each stored under
+// the id of its ingestion
+func (d *deadLetterStore) page(w http.ResponseWriter, n int) {
+	var hits []string
+	if n < len(d.pages) {
+		for _, id := range d.pages[n] {
+			dl, _ := json.Marshal(testDeadLetter(id))
+			hits = append(hits, fmt.Sprintf(`{"_id":%q,"_source":%s}`, id, dl))
+		}
+	}
+	fmt.Fprintf(w, `{"_scroll_id":"scroll-%d","hits":{"hits":[%s]}}`, n, strings.Join(hits, ","))
+}
+
+// Function to make a dead letter for the events index, or for the
+// broken one if the id says so
+func testDeadLetter(id string) deadLetter {
+	index := "events"
+	if strings.HasPrefix(id, "broken") {
+		index = "broken"
+	}
+	return deadLetter{
+		IngestionID: id,
+		Kind:        "event",
+		Index:       index,
+		ErrorType:   "transport",
+		Document:    json.RawMessage(`{"kind":"Event"}`),
+	}
+}
+
+// Function to create a replayer writing to a stand-in OpenSearch
+func newTestReplayer(t *testing.T, store *deadLetterStore) (*deadLetterReplayer, *opensearch.Client) {
+	t.Helper()
+	server := httptest.NewServer(store)
+	t.Cleanup(server.Close)
+
+	client, err := opensearch.NewClient(opensearch.Config{Addresses: []string{server.URL}, DisableRetry: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := defaultConfig()
+	config.Retry.MaxAttempts = 1
+	config.Bulk.FlushInterval = 10 * time.Millisecond
+	retry, err := newRetryPolicy(config.Retry, noop.NewMeterProvider().Meter("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bulk := newBulkIndexer(client, config.Bulk, retry)
+	bulk.start()
+	t.Cleanup(func() { bulk.close(context.Background()) })
+	return &deadLetterReplayer{bulk: bulk, out: io.Discard}, client
+}
+
+func TestReplayFile(t *testing.T) {
+	lines := func(dls ...deadLetter) string {
+		var buf bytes.Buffer
+		for _, dl := range dls {
+			line, _ := json.Marshal(dl)
+			buf.Write(line)
+			buf.WriteByte('\n')
+		}
+		return buf.String()
+	}
+	mapping := testDeadLetter("mapping")
+	mapping.ErrorType = "mapper_parsing_exception"
+	dup := testDeadLetter("dup")
+	dup.DocumentID = "dup"
+
+	tests := []struct {
+		name       string
+		file       string
+		errorTypes []string
+		dryRun     bool
+		want       replayStats
+		written    []string
+		kept       []string
+	}{
+		{
+			name:    "every dead letter written",
+			file:    lines(testDeadLetter("a"), testDeadLetter("b")),
+			want:    replayStats{matched: 2, replayed: 2},
+			written: []string{"events/", "events/"},
+		},
+		{
+			name:    "a create that finds its document counts as replayed",
+			file:    lines(dup),
+			want:    replayStats{matched: 1, replayed: 1},
+			written: nil,
+		},
+		{
+			name:    "failures, unreadable lines and blank lines",
+			file:    lines(testDeadLetter("a"), testDeadLetter("broken")) + "not json\n\n",
+			want:    replayStats{matched: 2, replayed: 1, failed: 1},
+			written: []string{"events/"},
+			kept:    []string{"not json", "broken mapper_parsing_exception"},
+		},
+		{
+			name:       "filtered by error type",
+			file:       lines(testDeadLetter("a"), mapping),
+			errorTypes: []string{"mapper_parsing_exception"},
+			want:       replayStats{matched: 1, replayed: 1},
+			written:    []string{"events/"},
+			kept:       []string{"a transport"},
+		},
+		{
+			name:   "dry run",
+			file:   lines(testDeadLetter("a"), testDeadLetter("b")),
+			dryRun: true,
+			want:   replayStats{matched: 2},
+			kept:   []string{"a transport", "b transport"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &deadLetterStore{}
+			r, _ := newTestReplayer(t, store)
+			r.errorTypes, r.dryRun = tt.errorTypes, tt.dryRun
+			path := filepath.Join(t.TempDir(), "dead-letters.ndjson")
+			if err := os.WriteFile(path, []byte(tt.file), 0o640); err != nil {
+				t.Fatal(err)
+			}
+
+			stats, err := r.replayFile(context.Background(), path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats != tt.want {
+				t.Errorf("stats %+v, want %+v", stats, tt.want)
+			}
+			if !reflect.DeepEqual(store.written, tt.written) {
+				t.Errorf("written %v, want %v", store.written, tt.written)
+			}
+			if _, err := os.Stat(path + ".replaying"); !os.IsNotExist(err) {
+				t.Errorf("claimed file left behind: %v", err)
+			}
+
+			// What is left is described by ingestion ID and error type
+			data, err := os.ReadFile(path)
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			var kept []string
+			for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+				var dl deadLetter
+				switch {
+				case line == "":
+				case json.Unmarshal([]byte(line), &dl) != nil:
+					kept = append(kept, line)
+				default:
+					kept = append(kept, dl.IngestionID+" "+dl.ErrorType)
+				}
+			}
+			sort.Strings(kept)
+			sort.Strings(tt.kept)
+			if !reflect.DeepEqual(kept, tt.kept) {
+				t.Errorf("kept %q, want %q", kept, tt.kept)
+			}
+		})
+	}
+}
+
+func TestReplayFileResumesInterruptedReplay(t *testing.T) {
+	store := &deadLetterStore{}
+	r, _ := newTestReplayer(t, store)
+	path := filepath.Join(t.TempDir(), "dead-letters.ndjson")
+	claimed, _ := json.Marshal(testDeadLetter("claimed"))
+	fresh, _ := json.Marshal(testDeadLetter("fresh"))
+	if err := os.WriteFile(path+".replaying", append(claimed, '\n'), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(fresh, '\n'), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := r.replayFile(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (replayStats{matched: 1, replayed: 1}) {
+		t.Errorf("stats %+v, want the claimed dead letter replayed", stats)
+	}
+	data, _ := os.ReadFile(path)
+	if !bytes.Equal(bytes.TrimSpace(data), fresh) {
+		t.Errorf("file holds %q, want the fresh dead letter untouched", data)
+	}
+}
+
+func TestReplayIndex(t *testing.T) {
+	store := &deadLetterStore{pages: [][]string{{"a", "broken"}, {"b"}}}
+	r, client := newTestReplayer(t, store)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stats, err := r.replayIndex(ctx, client, "dead-letters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (replayStats{matched: 3, replayed: 2, failed: 1}) {
+		t.Errorf("stats %+v, want 3 matched, 2 replayed, 1 failed", stats)
+	}
+	sort.Strings(store.deleted)
+	if !reflect.DeepEqual(store.deleted, []string{"a", "b"}) {
+		t.Errorf("deleted %v, want the replayed dead letters", store.deleted)
+	}
+	// The empty third page ends the replay; its scroll is the one to clear
+	if !reflect.DeepEqual(store.cleared, []string{"scroll-2"}) {
+		t.Errorf("cleared scrolls %v, want [scroll-2]", store.cleared)
+	}
+}
@@ -0,0 +1,720 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        (unknown)
+// source: eventpb/event.proto
+
+// The protobuf equivalent of the JSON Event accepted by /event, for
+// emitters that publish over gRPC. Fields mirror the JSON ones; times are
+// RFC 3339 strings, as in JSON.
+
+package eventpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type PublishResponse_Result int32
+
+const (
+	PublishResponse_RESULT_UNSPECIFIED PublishResponse_Result = 0
+	// Queued for writing; only in async mode
+	PublishResponse_RESULT_ACCEPTED  PublishResponse_Result = 1
+	PublishResponse_RESULT_INDEXED   PublishResponse_Result = 2
+	PublishResponse_RESULT_DUPLICATE PublishResponse_Result = 3
+	// Not stored; retrying may succeed
+	PublishResponse_RESULT_FAILED PublishResponse_Result = 4
+	// Refused without being queued, see retry_after_ms
+	PublishResponse_RESULT_REJECTED PublishResponse_Result = 5
+)
+
+// Enum value maps for PublishResponse_Result.
+var (
+	PublishResponse_Result_name = map[int32]string{
+		0: "RESULT_UNSPECIFIED",
+		1: "RESULT_ACCEPTED",
+		2: "RESULT_INDEXED",
+		3: "RESULT_DUPLICATE",
+		4: "RESULT_FAILED",
+		5: "RESULT_REJECTED",
+	}
+	PublishResponse_Result_value = map[string]int32{
+		"RESULT_UNSPECIFIED": 0,
+		"RESULT_ACCEPTED":    1,
+		"RESULT_INDEXED":     2,
+		"RESULT_DUPLICATE":   3,
+		"RESULT_FAILED":      4,
+		"RESULT_REJECTED":    5,
+	}
+)
+
+func (x PublishResponse_Result) Enum() *PublishResponse_Result {
+	p := new(PublishResponse_Result)
+	*p = x
+	return p
+}
+
+func (x PublishResponse_Result) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (PublishResponse_Result) Descriptor() protoreflect.EnumDescriptor {
+	return file_eventpb_event_proto_enumTypes[0].Descriptor()
+}
+
+func (PublishResponse_Result) Type() protoreflect.EnumType {
+	return &file_eventpb_event_proto_enumTypes[0]
+}
+
+func (x PublishResponse_Result) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use PublishResponse_Result.Descriptor instead.
+func (PublishResponse_Result) EnumDescriptor() ([]byte, []int) {
+	return file_eventpb_event_proto_rawDescGZIP(), []int{5, 0}
+}
+
+type Event struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	ApiVersion     string                 `protobuf:"bytes,1,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`
+	Kind           string                 `protobuf:"bytes,2,opt,name=kind,proto3" json:"kind,omitempty"`
+	Metadata       *Metadata              `protobuf:"bytes,3,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	InvolvedObject *ObjectReference       `protobuf:"bytes,4,opt,name=involved_object,json=involvedObject,proto3" json:"involved_object,omitempty"`
+	Action         string                 `protobuf:"bytes,5,opt,name=action,proto3" json:"action,omitempty"`
+	EventTime      string                 `protobuf:"bytes,6,opt,name=event_time,json=eventTime,proto3" json:"event_time,omitempty"`
+	Count          int32                  `protobuf:"varint,7,opt,name=count,proto3" json:"count,omitempty"`
+	Series         *EventSeries           `protobuf:"bytes,8,opt,name=series,proto3" json:"series,omitempty"`
+	Type           string                 `protobuf:"bytes,9,opt,name=type,proto3" json:"type,omitempty"`
+	CurrentStatus  string                 `protobuf:"bytes,10,opt,name=current_status,json=currentStatus,proto3" json:"current_status,omitempty"`
+	CorrelationId  string                 `protobuf:"bytes,11,opt,name=correlation_id,json=correlationId,proto3" json:"correlation_id,omitempty"`
+	UserId         string                 `protobuf:"bytes,12,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	OrgUuid        string                 `protobuf:"bytes,13,opt,name=org_uuid,json=orgUuid,proto3" json:"org_uuid,omitempty"`
+	Attributes     map[string]string      `protobuf:"bytes,14,rep,name=attributes,proto3" json:"attributes,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_eventpb_event_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_eventpb_event_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_eventpb_event_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Event) GetApiVersion() string {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return ""
+}
+
+func (x *Event) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *Event) GetMetadata() *Metadata {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *Event) GetInvolvedObject() *ObjectReference {
+	if x != nil {
+		return x.InvolvedObject
+	}
+	return nil
+}
+
+func (x *Event) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *Event) GetEventTime() string {
+	if x != nil {
+		return x.EventTime
+	}
+	return ""
+}
+
+func (x *Event) GetCount() int32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *Event) GetSeries() *EventSeries {
+	if x != nil {
+		return x.Series
+	}
+	return nil
+}
+
+func (x *Event) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Event) GetCurrentStatus() string {
+	if x != nil {
+		return x.CurrentStatus
+	}
+	return ""
+}
+
+func (x *Event) GetCorrelationId() string {
+	if x != nil {
+		return x.CorrelationId
+	}
+	return ""
+}
+
+func (x *Event) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *Event) GetOrgUuid() string {
+	if x != nil {
+		return x.OrgUuid
+	}
+	return ""
+}
+
+func (x *Event) GetAttributes() map[string]string {
+	if x != nil {
+		return x.Attributes
+	}
+	return nil
+}
+
+type Metadata struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Name              string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Labels            map[string]string      `protobuf:"bytes,2,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	DeletionTimestamp string                 `protobuf:"bytes,3,opt,name=deletion_timestamp,json=deletionTimestamp,proto3" json:"deletion_timestamp,omitempty"`
+	Reason            string                 `protobuf:"bytes,4,opt,name=reason,proto3" json:"reason,omitempty"`
+	Message           string                 `protobuf:"bytes,5,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *Metadata) Reset() {
+	*x = Metadata{}
+	mi := &file_eventpb_event_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Metadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Metadata) ProtoMessage() {}
+
+func (x *Metadata) ProtoReflect() protoreflect.Message {
+	mi := &file_eventpb_event_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Metadata.ProtoReflect.Descriptor instead.
+func (*Metadata) Descriptor() ([]byte, []int) {
+	return file_eventpb_event_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Metadata) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Metadata) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Metadata) GetDeletionTimestamp() string {
+	if x != nil {
+		return x.DeletionTimestamp
+	}
+	return ""
+}
+
+func (x *Metadata) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *Metadata) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type ObjectReference struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Kind          string                 `protobuf:"bytes,1,opt,name=kind,proto3" json:"kind,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Uuid          string                 `protobuf:"bytes,3,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ObjectReference) Reset() {
+	*x = ObjectReference{}
+	mi := &file_eventpb_event_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ObjectReference) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ObjectReference) ProtoMessage() {}
+
+func (x *ObjectReference) ProtoReflect() protoreflect.Message {
+	mi := &file_eventpb_event_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ObjectReference.ProtoReflect.Descriptor instead.
+func (*ObjectReference) Descriptor() ([]byte, []int) {
+	return file_eventpb_event_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ObjectReference) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *ObjectReference) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ObjectReference) GetUuid() string {
+	if x != nil {
+		return x.Uuid
+	}
+	return ""
+}
+
+type EventSeries struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Count            int32                  `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
+	LastObservedTime string                 `protobuf:"bytes,2,opt,name=last_observed_time,json=lastObservedTime,proto3" json:"last_observed_time,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *EventSeries) Reset() {
+	*x = EventSeries{}
+	mi := &file_eventpb_event_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EventSeries) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EventSeries) ProtoMessage() {}
+
+func (x *EventSeries) ProtoReflect() protoreflect.Message {
+	mi := &file_eventpb_event_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EventSeries.ProtoReflect.Descriptor instead.
+func (*EventSeries) Descriptor() ([]byte, []int) {
+	return file_eventpb_event_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *EventSeries) GetCount() int32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *EventSeries) GetLastObservedTime() string {
+	if x != nil {
+		return x.LastObservedTime
+	}
+	return ""
+}
+
+type PublishRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Event *Event                 `protobuf:"bytes,1,opt,name=event,proto3" json:"event,omitempty"`
+	// Producer idempotency key, like the Idempotency-Key header
+	IdempotencyKey string `protobuf:"bytes,2,opt,name=idempotency_key,json=idempotencyKey,proto3" json:"idempotency_key,omitempty"`
+	// Wait for the event to be written before acking, like ?sync=true.
+	// Unset uses the configured ingest mode.
+	Sync *bool `protobuf:"varint,3,opt,name=sync,proto3,oneof" json:"sync,omitempty"`
+	// Echoed in the ack, so that stream acks can be matched to requests
+	Sequence      uint64 `protobuf:"varint,4,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PublishRequest) Reset() {
+	*x = PublishRequest{}
+	mi := &file_eventpb_event_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PublishRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PublishRequest) ProtoMessage() {}
+
+func (x *PublishRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_eventpb_event_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PublishRequest.ProtoReflect.Descriptor instead.
+func (*PublishRequest) Descriptor() ([]byte, []int) {
+	return file_eventpb_event_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *PublishRequest) GetEvent() *Event {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *PublishRequest) GetIdempotencyKey() string {
+	if x != nil {
+		return x.IdempotencyKey
+	}
+	return ""
+}
+
+func (x *PublishRequest) GetSync() bool {
+	if x != nil && x.Sync != nil {
+		return *x.Sync
+	}
+	return false
+}
+
+func (x *PublishRequest) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+type PublishResponse struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Sequence uint64                 `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Result   PublishResponse_Result `protobuf:"varint,2,opt,name=result,proto3,enum=opensearchlogging.events.v1.PublishResponse_Result" json:"result,omitempty"`
+	// Ingestion ID for /event/status, and the ID of the stored document
+	Id         string `protobuf:"bytes,3,opt,name=id,proto3" json:"id,omitempty"`
+	DocumentId string `protobuf:"bytes,4,opt,name=document_id,json=documentId,proto3" json:"document_id,omitempty"`
+	Error      string `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	// How long to back off before resending a rejected event
+	RetryAfterMs  int64 `protobuf:"varint,6,opt,name=retry_after_ms,json=retryAfterMs,proto3" json:"retry_after_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PublishResponse) Reset() {
+	*x = PublishResponse{}
+	mi := &file_eventpb_event_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PublishResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PublishResponse) ProtoMessage() {}
+
+func (x *PublishResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_eventpb_event_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PublishResponse.ProtoReflect.Descriptor instead.
+func (*PublishResponse) Descriptor() ([]byte, []int) {
+	return file_eventpb_event_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *PublishResponse) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *PublishResponse) GetResult() PublishResponse_Result {
+	if x != nil {
+		return x.Result
+	}
+	return PublishResponse_RESULT_UNSPECIFIED
+}
+
+func (x *PublishResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *PublishResponse) GetDocumentId() string {
+	if x != nil {
+		return x.DocumentId
+	}
+	return ""
+}
+
+func (x *PublishResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *PublishResponse) GetRetryAfterMs() int64 {
+	if x != nil {
+		return x.RetryAfterMs
+	}
+	return 0
+}
+
+var File_eventpb_event_proto protoreflect.FileDescriptor
+
+const file_eventpb_event_proto_rawDesc = "" +
+	"\n" +
+	"\x13eventpb/event.proto\x12\x1bopensearchlogging.events.v1\"\x8e\x05\n" +
+	"\x05Event\x12\x1f\n" +
+	"\vapi_version\x18\x01 \x01(\tR\n" +
+	"apiVersion\x12\x12\n" +
+	"\x04kind\x18\x02 \x01(\tR\x04kind\x12A\n" +
+	"\bmetadata\x18\x03 \x01(\v2%.opensearchlogging.events.v1.MetadataR\bmetadata\x12U\n" +
+	"\x0finvolved_object\x18\x04 \x01(\v2,.opensearchlogging.events.v1.ObjectReferenceR\x0einvolvedObject\x12\x16\n" +
+	"\x06action\x18\x05 \x01(\tR\x06action\x12\x1d\n" +
+	"\n" +
+	"event_time\x18\x06 \x01(\tR\teventTime\x12\x14\n" +
+	"\x05count\x18\a \x01(\x05R\x05count\x12@\n" +
+	"\x06series\x18\b \x01(\v2(.opensearchlogging.events.v1.EventSeriesR\x06series\x12\x12\n" +
+	"\x04type\x18\t \x01(\tR\x04type\x12%\n" +
+	"\x0ecurrent_status\x18\n" +
+	" \x01(\tR\rcurrentStatus\x12%\n" +
+	"\x0ecorrelation_id\x18\v \x01(\tR\rcorrelationId\x12\x17\n" +
+	"\auser_id\x18\f \x01(\tR\x06userId\x12\x19\n" +
+	"\borg_uuid\x18\r \x01(\tR\aorgUuid\x12R\n" +
+	"\n" +
+	"attributes\x18\x0e \x03(\v22.opensearchlogging.events.v1.Event.AttributesEntryR\n" +
+	"attributes\x1a=\n" +
+	"\x0fAttributesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x85\x02\n" +
+	"\bMetadata\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12I\n" +
+	"\x06labels\x18\x02 \x03(\v21.opensearchlogging.events.v1.Metadata.LabelsEntryR\x06labels\x12-\n" +
+	"\x12deletion_timestamp\x18\x03 \x01(\tR\x11deletionTimestamp\x12\x16\n" +
+	"\x06reason\x18\x04 \x01(\tR\x06reason\x12\x18\n" +
+	"\amessage\x18\x05 \x01(\tR\amessage\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"M\n" +
+	"\x0fObjectReference\x12\x12\n" +
+	"\x04kind\x18\x01 \x01(\tR\x04kind\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x12\n" +
+	"\x04uuid\x18\x03 \x01(\tR\x04uuid\"Q\n" +
+	"\vEventSeries\x12\x14\n" +
+	"\x05count\x18\x01 \x01(\x05R\x05count\x12,\n" +
+	"\x12last_observed_time\x18\x02 \x01(\tR\x10lastObservedTime\"\xb1\x01\n" +
+	"\x0ePublishRequest\x128\n" +
+	"\x05event\x18\x01 \x01(\v2\".opensearchlogging.events.v1.EventR\x05event\x12'\n" +
+	"\x0fidempotency_key\x18\x02 \x01(\tR\x0eidempotencyKey\x12\x17\n" +
+	"\x04sync\x18\x03 \x01(\bH\x00R\x04sync\x88\x01\x01\x12\x1a\n" +
+	"\bsequence\x18\x04 \x01(\x04R\bsequenceB\a\n" +
+	"\x05_sync\"\xf1\x02\n" +
+	"\x0fPublishResponse\x12\x1a\n" +
+	"\bsequence\x18\x01 \x01(\x04R\bsequence\x12K\n" +
+	"\x06result\x18\x02 \x01(\x0e23.opensearchlogging.events.v1.PublishResponse.ResultR\x06result\x12\x0e\n" +
+	"\x02id\x18\x03 \x01(\tR\x02id\x12\x1f\n" +
+	"\vdocument_id\x18\x04 \x01(\tR\n" +
+	"documentId\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\x12$\n" +
+	"\x0eretry_after_ms\x18\x06 \x01(\x03R\fretryAfterMs\"\x87\x01\n" +
+	"\x06Result\x12\x16\n" +
+	"\x12RESULT_UNSPECIFIED\x10\x00\x12\x13\n" +
+	"\x0fRESULT_ACCEPTED\x10\x01\x12\x12\n" +
+	"\x0eRESULT_INDEXED\x10\x02\x12\x14\n" +
+	"\x10RESULT_DUPLICATE\x10\x03\x12\x11\n" +
+	"\rRESULT_FAILED\x10\x04\x12\x13\n" +
+	"\x0fRESULT_REJECTED\x10\x052\xe4\x01\n" +
+	"\fEventService\x12d\n" +
+	"\aPublish\x12+.opensearchlogging.events.v1.PublishRequest\x1a,.opensearchlogging.events.v1.PublishResponse\x12n\n" +
+	"\rPublishStream\x12+.opensearchlogging.events.v1.PublishRequest\x1a,.opensearchlogging.events.v1.PublishResponse(\x010\x01B\x1fZ\x1dgo-opensearch-logging/eventpbb\x06proto3"
+
+var (
+	file_eventpb_event_proto_rawDescOnce sync.Once
+	file_eventpb_event_proto_rawDescData []byte
+)
+
+func file_eventpb_event_proto_rawDescGZIP() []byte {
+	file_eventpb_event_proto_rawDescOnce.Do(func() {
+		file_eventpb_event_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_eventpb_event_proto_rawDesc), len(file_eventpb_event_proto_rawDesc)))
+	})
+	return file_eventpb_event_proto_rawDescData
+}
+
+var file_eventpb_event_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_eventpb_event_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_eventpb_event_proto_goTypes = []any{
+	(PublishResponse_Result)(0), // 0: opensearchlogging.events.v1.PublishResponse.Result
+	(*Event)(nil),               // 1: opensearchlogging.events.v1.Event
+	(*Metadata)(nil),            // 2: opensearchlogging.events.v1.Metadata
+	(*ObjectReference)(nil),     // 3: opensearchlogging.events.v1.ObjectReference
+	(*EventSeries)(nil),         // 4: opensearchlogging.events.v1.EventSeries
+	(*PublishRequest)(nil),      // 5: opensearchlogging.events.v1.PublishRequest
+	(*PublishResponse)(nil),     // 6: opensearchlogging.events.v1.PublishResponse
+	nil,                         // 7: opensearchlogging.events.v1.Event.AttributesEntry
+	nil,                         // 8: opensearchlogging.events.v1.Metadata.LabelsEntry
+}
+var file_eventpb_event_proto_depIdxs = []int32{
+	2, // 0: opensearchlogging.events.v1.Event.metadata:type_name -> opensearchlogging.events.v1.Metadata
+	3, // 1: opensearchlogging.events.v1.Event.involved_object:type_name -> opensearchlogging.events.v1.ObjectReference
+	4, // 2: opensearchlogging.events.v1.Event.series:type_name -> opensearchlogging.events.v1.EventSeries
+	7, // 3: opensearchlogging.events.v1.Event.attributes:type_name -> opensearchlogging.events.v1.Event.AttributesEntry
+	8, // 4: opensearchlogging.events.v1.Metadata.labels:type_name -> opensearchlogging.events.v1.Metadata.LabelsEntry
+	1, // 5: opensearchlogging.events.v1.PublishRequest.event:type_name -> opensearchlogging.events.v1.Event
+	0, // 6: opensearchlogging.events.v1.PublishResponse.result:type_name -> opensearchlogging.events.v1.PublishResponse.Result
+	5, // 7: opensearchlogging.events.v1.EventService.Publish:input_type -> opensearchlogging.events.v1.PublishRequest
+	5, // 8: opensearchlogging.events.v1.EventService.PublishStream:input_type -> opensearchlogging.events.v1.PublishRequest
+	6, // 9: opensearchlogging.events.v1.EventService.Publish:output_type -> opensearchlogging.events.v1.PublishResponse
+	6, // 10: opensearchlogging.events.v1.EventService.PublishStream:output_type -> opensearchlogging.events.v1.PublishResponse
+	9, // [9:11] is the sub-list for method output_type
+	7, // [7:9] is the sub-list for method input_type
+	7, // [7:7] is the sub-list for extension type_name
+	7, // [7:7] is the sub-list for extension extendee
+	0, // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_eventpb_event_proto_init() }
+func file_eventpb_event_proto_init() {
+	if File_eventpb_event_proto != nil {
+		return
+	}
+	file_eventpb_event_proto_msgTypes[4].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_eventpb_event_proto_rawDesc), len(file_eventpb_event_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_eventpb_event_proto_goTypes,
+		DependencyIndexes: file_eventpb_event_proto_depIdxs,
+		EnumInfos:         file_eventpb_event_proto_enumTypes,
+		MessageInfos:      file_eventpb_event_proto_msgTypes,
+	}.Build()
+	File_eventpb_event_proto = out.File
+	file_eventpb_event_proto_goTypes = nil
+	file_eventpb_event_proto_depIdxs = nil
+}
@@ -0,0 +1,273 @@
+package main
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative eventpb/event.proto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	"go-opensearch-logging/eventpb"
+)
+
+// GRPCConfig controls the gRPC ingestion service, which is off unless a
+// listen address is set. Messages are limited to body.maxEventBytes.
+type GRPCConfig struct {
+	ListenAddress string `yaml:"listenAddress" json:"listenAddress" env:"GRPC_LISTEN_ADDRESS"`
+	Reflection    bool   `yaml:"reflection" json:"reflection" env:"GRPC_REFLECTION"`
+}
+
+// Function to validate the gRPC settings
+func (c GRPCConfig) validate() error {
+	if c.ListenAddress == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(c.ListenAddress); err != nil {
+		return fmt.Errorf("grpc.listenAddress: %q is not a valid host:port: %v", c.ListenAddress, err)
+	}
+	return nil
+}
+
+// How often the gRPC health status is refreshed from the readiness
+// checks; more often while not serving, to pick up the end of bootstrap
+const (
+	grpcHealthInterval        = 5 * time.Second
+	grpcNotServingHealthCheck = time.Second
+)
+
+// grpcService is the gRPC server and its health status
+type grpcService struct {
+	server *grpc.Server
+	health *health.Server
+}
+
+// Function to create the gRPC server with the event service, health
+// checking and, if enabled, reflection
+func newGRPCService(s *Server) *grpcService {
+	g := &grpcService{
+		server: grpc.NewServer(grpc.MaxRecvMsgSize(int(s.config.Body.MaxEventBytes))),
+		health: health.NewServer(),
+	}
+	eventpb.RegisterEventServiceServer(g.server, &eventService{s: s})
+	healthpb.RegisterHealthServer(g.server, g.health)
+	if s.config.GRPC.Reflection {
+		reflection.Register(g.server)
+	}
+	g.setServing(false)
+	return g
+}
+
+// Function to report the server and the event service as serving or not
+func (g *grpcService) setServing(serving bool) {
+	state := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		state = healthpb.HealthCheckResponse_SERVING
+	}
+	g.health.SetServingStatus("", state)
+	g.health.SetServingStatus(eventpb.EventService_ServiceDesc.ServiceName, state)
+}
+
+// Function to keep the health status in line with /readyz until ctx is
+// cancelled
+func (g *grpcService) watchHealth(ctx context.Context, s *Server) {
+	for {
+		serving := true
+		for _, res := range s.runChecks(ctx, s.readinessChecks()) {
+			serving = serving && res.Status == "ok"
+		}
+		g.setServing(serving)
+
+		interval := grpcHealthInterval
+		if !serving {
+			interval = grpcNotServingHealthCheck
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Function to stop the gRPC server, letting in-flight calls finish until
+// ctx is done
+func (g *grpcService) stop(ctx context.Context) error {
+	g.health.Shutdown()
+	done := make(chan struct{})
+	go func() {
+		g.server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		g.server.Stop()
+		return ctx.Err()
+	}
+}
+
+// eventService implements the EventService gRPC API on top of the same
+// admission, trackers and indexing as /event
+type eventService struct {
+	eventpb.UnimplementedEventServiceServer
+	s *Server
+}
+
+// Function to publish a single event. Events that are refused without
+// being queued fail with Unavailable or InvalidArgument, so that client
+// retry policies apply.
+func (e *eventService) Publish(ctx context.Context, req *eventpb.PublishRequest) (*eventpb.PublishResponse, error) {
+	resp, code := e.s.publish(ctx, grpcMeta(ctx), req)
+	if code != codes.OK {
+		return nil, status.Error(code, resp.Error)
+	}
+	return resp, nil
+}
+
+// Function to publish a stream of events, acking each one in order. An
+// event that is refused is acked as rejected; the stream carries on.
+func (e *eventService) PublishStream(stream eventpb.EventService_PublishStreamServer) error {
+	meta := grpcMeta(stream.Context())
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		resp, _ := e.s.publish(stream.Context(), meta, req)
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// Function to ingest an event received over gRPC, going through the same
+// steps as handleEvent. Alongside the ack it returns the status code for a
+// refused event, codes.OK otherwise.
+func (s *Server) publish(clientCtx context.Context, meta requestMeta, req *eventpb.PublishRequest) (*eventpb.PublishResponse, codes.Code) {
+	resp := &eventpb.PublishResponse{Sequence: req.GetSequence()}
+	reject := func(code codes.Code, retryAfter time.Duration, format string, args ...any) (*eventpb.PublishResponse, codes.Code) {
+		resp.Result, resp.Error = eventpb.PublishResponse_RESULT_REJECTED, fmt.Sprintf(format, args...)
+		resp.RetryAfterMs = retryAfter.Milliseconds()
+		return resp, code
+	}
+
+	if req.GetEvent() == nil {
+		return reject(codes.InvalidArgument, 0, "event is required")
+	}
+	event := eventFromProto(req.GetEvent())
+	if err := validateEvent(event); err != nil {
+		return reject(codes.InvalidArgument, 0, "invalid event: %v", err)
+	}
+	wait := s.config.Ingest.Mode == "sync"
+	if req.Sync != nil {
+		wait = req.GetSync()
+	}
+
+	warning := event.Type == "Warning"
+	ctx, done, refused := s.accept(clientCtx, warning)
+	if refused != nil {
+		if refused.reason == refusedStarting {
+			return reject(codes.Unavailable, refused.retryAfter, "service is starting")
+		}
+		return reject(codes.Unavailable, refused.retryAfter, "service saturated (%s), retry later", refused.reason)
+	}
+	defer done()
+
+	ing, err := s.newIngestion(ctx, meta, req.GetIdempotencyKey(), event)
+	if err != nil {
+		log.Printf("Failed to prepare event: %v", err)
+		return reject(codes.Internal, 0, "internal server error")
+	}
+	resp.Id = ing.id
+
+	if err := s.submit(ctx, clientCtx, []*ingestion{ing}, wait)[0]; err != nil {
+		if reason, ok := s.shedReason(err); ok {
+			s.admission.countShed(clientCtx, reason, warning)
+			return reject(codes.Unavailable, s.admission.config.RetryAfter, "service saturated (%s), retry later", reason)
+		}
+		log.Printf("Failed to spool event: %v", err)
+		return reject(codes.Unavailable, 5*time.Second, "failed to spool event")
+	}
+
+	if !wait {
+		tracked, _ := s.statuses.get(ing.id)
+		resp.Result, resp.DocumentId = eventpb.PublishResponse_RESULT_ACCEPTED, tracked.documentID()
+		return resp, codes.OK
+	}
+	var item batchItem
+	tracked, ok := s.statuses.wait(clientCtx, ing.id)
+	item.setStatus(tracked, ok)
+	resp.DocumentId, resp.Error = item.DocumentID, item.Error
+	switch item.Result {
+	case stateIndexed:
+		resp.Result = eventpb.PublishResponse_RESULT_INDEXED
+	case stateDuplicate:
+		resp.Result = eventpb.PublishResponse_RESULT_DUPLICATE
+	default:
+		resp.Result = eventpb.PublishResponse_RESULT_FAILED
+	}
+	return resp, codes.OK
+}
+
+// Function to capture the metadata of a gRPC call
+func grpcMeta(ctx context.Context) requestMeta {
+	meta := requestMeta{ReceivedAt: time.Now().UTC()}
+	meta.Path, _ = grpc.Method(ctx)
+	if p, ok := peer.FromContext(ctx); ok {
+		meta.RemoteAddr = p.Addr.String()
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("user-agent"); len(values) > 0 {
+			meta.UserAgent = values[0]
+		}
+		if values := md.Get("x-request-id"); len(values) > 0 {
+			meta.RequestID = values[0]
+		}
+	}
+	return meta
+}
+
+// Function to convert a protobuf event into an Event
+func eventFromProto(pb *eventpb.Event) Event {
+	var event Event
+	event.ApiVersion, event.Kind = pb.GetApiVersion(), pb.GetKind()
+	event.Metadata.Name = pb.GetMetadata().GetName()
+	event.Metadata.Labels = pb.GetMetadata().GetLabels()
+	event.Metadata.DeletionTimestamp = pb.GetMetadata().GetDeletionTimestamp()
+	event.Metadata.Reason = pb.GetMetadata().GetReason()
+	event.Metadata.Message = pb.GetMetadata().GetMessage()
+	event.InvolvedObject.Kind = pb.GetInvolvedObject().GetKind()
+	event.InvolvedObject.Name = pb.GetInvolvedObject().GetName()
+	event.InvolvedObject.UUID = pb.GetInvolvedObject().GetUuid()
+	event.Action = pb.GetAction()
+	event.EventTime = pb.GetEventTime()
+	event.Count = int(pb.GetCount())
+	if series := pb.GetSeries(); series != nil {
+		event.Series = &EventSeries{Count: int(series.GetCount()), LastObservedTime: series.GetLastObservedTime()}
+	}
+	event.Type = pb.GetType()
+	event.CurrentStatus = pb.GetCurrentStatus()
+	event.CorrelationID = pb.GetCorrelationId()
+	event.UserID = pb.GetUserId()
+	event.OrgUUID = pb.GetOrgUuid()
+	event.Attributes = pb.GetAttributes()
+	return event
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+// HealthConfig controls the readiness checks
+type HealthConfig struct {
+	CheckTimeout time.Duration `yaml:"checkTimeout" json:"checkTimeout" env:"HEALTH_CHECK_TIMEOUT"`
+}
+
+// healthCheck is a named dependency check run by the readiness probe. An
+// advisory check is reported but does not decide readiness.
+type healthCheck struct {
+	name     string
+	check    func(ctx context.Context) error
+	advisory bool
+}
+
+// bufferGauge exposes the fill level of an internal buffer so readiness can
+// fail before the buffer overflows. The unit defaults to items.
+type bufferGauge struct {
+	name      string
+	depth     func() int
+	highWater int
+	unit      string
+}
+
+// checkResult is the outcome of one check as reported by /healthz?verbose
+type checkResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Advisory  bool    `json:"advisory,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Function to register an internal buffer with the readiness probe
+func (s *Server) registerBuffer(gauge bufferGauge) {
+	s.buffersMu.Lock()
+	defer s.buffersMu.Unlock()
+	s.buffers = append(s.buffers, gauge)
+}
+
+// Function to list the checks that decide readiness. With the spool
+// enabled events are accepted while OpenSearch is away, so readiness
+// rests on the spool having room rather than on OpenSearch.
+func (s *Server) readinessChecks() []healthCheck {
+	checks := []healthCheck{
+		{name: "bootstrap", check: func(context.Context) error {
+			if !s.ready.Load() {
+				return errors.New("bootstrap has not completed")
+			}
+			return nil
+		}},
+	}
+	if s.spool == nil {
+		checks = append(checks, s.dependencyChecks()...)
+	}
+
+	s.buffersMu.Lock()
+	defer s.buffersMu.Unlock()
+	for _, gauge := range s.buffers {
+		unit := gauge.unit
+		if unit == "" {
+			unit = "items"
+		}
+		checks = append(checks, healthCheck{name: "buffer:" + gauge.name, check: func(context.Context) error {
+			if depth := gauge.depth(); depth >= gauge.highWater {
+				return fmt.Errorf("%d %s buffered, high-water mark is %d", depth, unit, gauge.highWater)
+			}
+			return nil
+		}})
+	}
+	return checks
+}
+
+// Function to list the checks against OpenSearch itself
+func (s *Server) dependencyChecks() []healthCheck {
+	checks := []healthCheck{
+		{name: "opensearch", check: func(ctx context.Context) error {
+			return pingOpenSearch(ctx, s.client)
+		}},
+	}
+	for _, m := range s.managed {
+		checks = append(checks, healthCheck{name: "index:" + m.name, check: func(ctx context.Context) error {
+			return checkIndexMapping(ctx, s.client, m.name, m.index, m.mapping.body)
+		}})
+	}
+	return checks
+}
+
+// Function to run checks concurrently, each under the configured timeout
+func (s *Server) runChecks(ctx context.Context, checks []healthCheck) []checkResult {
+	results := make([]checkResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, s.config.Health.CheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := c.check(checkCtx)
+			results[i] = checkResult{
+				Name:      c.name,
+				Status:    "ok",
+				Advisory:  c.advisory,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				results[i].Status = "failed"
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// Liveness handler: the process is up and serving HTTP
+func (s *Server) handleLivez(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeHealth(w, r, nil)
+}
+
+// Readiness handler: the service can accept and persist events. The
+// verbose form also reports on OpenSearch when the spool stands in for it.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	checks := s.readinessChecks()
+	if _, verbose := r.URL.Query()["verbose"]; verbose && s.spool != nil {
+		for _, c := range s.dependencyChecks() {
+			c.advisory = true
+			checks = append(checks, c)
+		}
+	}
+	writeHealth(w, r, s.runChecks(r.Context(), checks))
+}
+
+// Function to write check results; ?verbose returns the per-check
+// breakdown as JSON, otherwise a one-word plain-text status
+func writeHealth(w http.ResponseWriter, r *http.Request, results []checkResult) {
+	status := http.StatusOK
+	for _, res := range results {
+		if res.Status != "ok" && !res.Advisory {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	if _, verbose := r.URL.Query()["verbose"]; verbose {
+		sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+		overall := "ok"
+		if status != http.StatusOK {
+			overall = "failed"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]any{
+			"status": overall,
+			"checks": results,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	if status == http.StatusOK {
+		io.WriteString(w, "ok\n")
+		return
+	}
+	io.WriteString(w, "not ready\n")
+}
+
+// Function to check that OpenSearch answers a ping
+func pingOpenSearch(ctx context.Context, client *opensearch.Client) error {
+	res, err := opensearchapi.PingRequest{}.Do(ctx, client)
+	if err != nil {
+		return fmt.Errorf("error pinging OpenSearch: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error pinging OpenSearch: Status: %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Function to check that the live mapping (or, for templated names, the
+// index template) contains every field of the expected mapping with the
+// expected type
+func checkIndexMapping(ctx context.Context, client *opensearch.Client, name string, index *indexTemplate, mapping string) error {
+	var expected struct {
+		Mappings json.RawMessage `json:"mappings"`
+	}
+	if err := json.Unmarshal([]byte(mapping), &expected); err != nil {
+		return fmt.Errorf("error parsing expected %s mapping: %w", name, err)
+	}
+
+	live, err := fetchMapping(ctx, client, name, index)
+	if err != nil {
+		return err
+	}
+
+	want := mappingFields(expected.Mappings)
+	got := mappingFields(live)
+	var problems []string
+	for path, typ := range want {
+		switch liveType, ok := got[path]; {
+		case !ok:
+			problems = append(problems, path+" missing")
+		case liveType != typ:
+			problems = append(problems, fmt.Sprintf("%s is %s, expected %s", path, liveType, typ))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%s mapping differs: %s", name, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Function to fetch the live mappings of an index or its index template
+func fetchMapping(ctx context.Context, client *opensearch.Client, name string, index *indexTemplate) (json.RawMessage, error) {
+	if !index.static() {
+		templateName := index.templateName(name)
+		res, err := opensearchapi.IndicesGetIndexTemplateRequest{Name: []string{templateName}}.Do(ctx, client)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching index template %s: %w", templateName, err)
+		}
+		defer res.Body.Close()
+		respBody, _ := io.ReadAll(res.Body)
+		if res.IsError() {
+			return nil, fmt.Errorf("error fetching index template %s: Status: %d, Response: %s", templateName, res.StatusCode, string(respBody))
+		}
+
+		var out struct {
+			IndexTemplates []struct {
+				IndexTemplate struct {
+					Template struct {
+						Mappings json.RawMessage `json:"mappings"`
+					} `json:"template"`
+				} `json:"index_template"`
+			} `json:"index_templates"`
+		}
+		if err := json.Unmarshal(respBody, &out); err != nil || len(out.IndexTemplates) == 0 {
+			return nil, fmt.Errorf("index template %s not found", templateName)
+		}
+		return out.IndexTemplates[0].IndexTemplate.Template.Mappings, nil
+	}
+
+	indexName := index.render(Event{}, time.Time{})
+	res, err := opensearchapi.IndicesGetMappingRequest{Index: []string{indexName}}.Do(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s mapping: %w", indexName, err)
+	}
+	defer res.Body.Close()
+	respBody, _ := io.ReadAll(res.Body)
+	if res.IsError() {
+		return nil, fmt.Errorf("error fetching %s mapping: Status: %d, Response: %s", indexName, res.StatusCode, string(respBody))
+	}
+
+	var out map[string]struct {
+		Mappings json.RawMessage `json:"mappings"`
+	}
+	if err := json.Unmarshal(respBody, &out); err != nil {
+		return nil, fmt.Errorf("error parsing %s mapping: %w", indexName, err)
+	}
+	for _, m := range out {
+		return m.Mappings, nil
+	}
+	return nil, fmt.Errorf("index %s not found", indexName)
+}
+
+// Function to flatten a mapping into dotted field paths and their types
+func mappingFields(mappings json.RawMessage) map[string]string {
+	type field struct {
+		Type       string                     `json:"type"`
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+
+	fields := make(map[string]string)
+	var walk func(prefix string, props map[string]json.RawMessage)
+	walk = func(prefix string, props map[string]json.RawMessage) {
+		for name, raw := range props {
+			var f field
+			if err := json.Unmarshal(raw, &f); err != nil {
+				continue
+			}
+			path := prefix + name
+			typ := f.Type
+			if typ == "" {
+				typ = "object"
+			}
+			fields[path] = typ
+			walk(path+".", f.Properties)
+		}
+	}
+
+	var root field
+	if err := json.Unmarshal(mappings, &root); err == nil {
+		walk("", root.Properties)
+	}
+	return fields
+}
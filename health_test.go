@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+)
+
+func TestReadinessWithSpool(t *testing.T) {
+	// OpenSearch is down throughout
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	client, err := opensearch.NewClient(opensearch.Config{Addresses: []string{down.URL}, DisableRetry: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		spool    bool
+		spooled  int
+		status   int
+		failed   []string
+		advisory []string
+	}{
+		{
+			name:   "without the spool OpenSearch decides",
+			status: http.StatusServiceUnavailable,
+			failed: []string{"opensearch"},
+		},
+		{
+			name:     "the spool stands in for OpenSearch",
+			spool:    true,
+			status:   http.StatusOK,
+			advisory: []string{"opensearch"},
+		},
+		{
+			name:     "a full spool is not ready",
+			spool:    true,
+			spooled:  100,
+			status:   http.StatusServiceUnavailable,
+			failed:   []string{"buffer:spool"},
+			advisory: []string{"opensearch"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{config: defaultConfig(), client: client}
+			s.config.Health.CheckTimeout = time.Second
+			s.ready.Store(true)
+			if tt.spool {
+				sp, err := openSpool(SpoolConfig{Enabled: true, Dir: t.TempDir(), SegmentBytes: 1 << 20, MaxBytes: 1 << 20, MaxAge: time.Hour, BatchRecords: 100})
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { sp.close(context.Background()) })
+				s.spool = sp
+				s.registerBuffer(bufferGauge{name: "spool", depth: func() int { return tt.spooled }, highWater: 90, unit: "bytes"})
+			}
+
+			for _, query := range []string{"", "?verbose"} {
+				w := httptest.NewRecorder()
+				s.handleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz"+query, nil))
+				if w.Code != tt.status {
+					t.Errorf("%q: status %d, want %d: %s", query, w.Code, tt.status, w.Body)
+				}
+				if query == "" {
+					continue
+				}
+
+				var res struct {
+					Checks []checkResult `json:"checks"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+					t.Fatal(err)
+				}
+				var failed, advisory []string
+				for _, c := range res.Checks {
+					switch {
+					case c.Status == "ok":
+					case c.Advisory:
+						advisory = append(advisory, c.Name)
+					default:
+						failed = append(failed, c.Name)
+					}
+				}
+				if !slices.Equal(failed, tt.failed) || !slices.Equal(advisory, tt.advisory) {
+					t.Errorf("failed %v and advisory %v, want %v and %v", failed, advisory, tt.failed, tt.advisory)
+				}
+			}
+		})
+	}
+}
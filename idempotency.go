@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// IdempotencyConfig controls how document IDs are derived so that an event
+// retried by its producer is not indexed twice. The Header, when the
+// request carries it, takes precedence over the Key fields (dotted JSON
+// paths into the event). With neither, OpenSearch generates the IDs.
+type IdempotencyConfig struct {
+	Header string   `yaml:"header" json:"header" env:"IDEMPOTENCY_HEADER"`
+	Key    []string `yaml:"key" json:"key" env:"IDEMPOTENCY_KEY"`
+}
+
+// Function to validate the idempotency settings
+func (c IdempotencyConfig) validate() error {
+	var errs []error
+	for _, path := range c.Key {
+		if _, err := eventField(path); err != nil {
+			errs = append(errs, fmt.Errorf("idempotency.key: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Function to resolve a dotted JSON path to a string or integer field of
+// Event, returning its field index
+func eventField(path string) ([]int, error) {
+	t := reflect.TypeOf(Event{})
+	var index []int
+	for _, name := range strings.Split(path, ".") {
+		field, ok := jsonField(t, name)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a field of the event", path)
+		}
+		index = append(index, field.Index...)
+		t = field.Type
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Int:
+		return index, nil
+	}
+	return nil, fmt.Errorf("%q is not a string or integer field", path)
+}
+
+// Function to find the field of a struct with the given JSON name
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	if t.Kind() != reflect.Struct {
+		return reflect.StructField{}, false
+	}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if tag == "" {
+			tag = field.Name
+		}
+		if field.IsExported() && tag != "-" && tag == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// Function to read the producer's idempotency key from a request
+func (s *Server) idempotencyHeader(r *http.Request) string {
+	if s.config.Idempotency.Header == "" {
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get(s.config.Idempotency.Header))
+}
+
+// Function to derive the idempotency key of an event: the producer's key
+// if it sent one, otherwise the configured fields. It is empty when any of
+// the fields is missing, since events that only differ in a missing field
+// would otherwise collapse into one.
+func (s *Server) idempotencyKey(header string, event Event) string {
+	if header != "" {
+		return "header\x00" + header
+	}
+	if len(s.config.Idempotency.Key) == 0 {
+		return ""
+	}
+
+	parts := []string{"event"}
+	v := reflect.ValueOf(event)
+	for _, path := range s.config.Idempotency.Key {
+		// Paths are checked when the configuration is loaded
+		index, _ := eventField(path)
+		field := v.FieldByIndex(index)
+		if field.IsZero() {
+			return ""
+		}
+		parts = append(parts, fmt.Sprint(field.Interface()))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// Function to derive the _id of one of an event's documents, so that the
+// event and each of its metrics get a distinct, stable ID
+func documentID(key, kind string) string {
+	sum := sha256.Sum256([]byte(key + "\x00" + kind))
+	return hex.EncodeToString(sum[:])
+}
+
+// Function to build the bulk document for an outgoing document. Documents
+// with a derived ID are created rather than indexed, so a replay is
+// reported as a duplicate instead of overwriting the original.
+func (o outgoingDoc) bulkDoc(body []byte) bulkDoc {
+	doc := bulkDoc{index: o.index, id: o.id, body: body}
+	if o.id != "" {
+		doc.action = "create"
+	}
+	return doc
+}
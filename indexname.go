@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// indexTemplate renders an index name from a template such as
+// "{prefix}-events-{org}-{yyyy.MM.dd}". Supported placeholders:
+//
+//	{prefix}  the configured environment prefix
+//	{org}     the event's OrgUUID
+//	{kind}    the event's InvolvedObject.Kind
+//	{yyyy}, {MM}, {dd}, {HH} and combinations such as {yyyy.MM.dd}
+//	          the event time (UTC), falling back to when the event was
+//	          received if it has none or is more than maxIndexTimeSkew
+//	          away from it
+type indexTemplate struct {
+	raw    string
+	prefix string
+	parts  []templatePart
+}
+
+// templatePart is either literal text or a placeholder
+type templatePart struct {
+	literal     string
+	placeholder string
+	dateLayout  string
+}
+
+// Placeholder values used when the event does not carry the field
+const (
+	defaultOrgSegment  = "default"
+	defaultKindSegment = "unknown"
+)
+
+// maxIndexTimeSkew bounds how far an event's own time may be from its
+// receipt and still date its index, so that a wrong or hostile clock
+// cannot create indices far in the past or future
+const maxIndexTimeSkew = 24 * time.Hour
+
+// maxIndexSegmentBytes caps a field value embedded in an index name,
+// keeping the name well under OpenSearch's 255 byte limit
+const maxIndexSegmentBytes = 64
+
+// Function to parse an index name template
+func parseIndexTemplate(raw, prefix string) (*indexTemplate, error) {
+	t := &indexTemplate{raw: raw, prefix: prefix}
+	rest := raw
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		if open > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated placeholder in %q", raw)
+		}
+		name := rest[open+1 : open+end]
+		part := templatePart{placeholder: name}
+		switch name {
+		case "prefix", "org", "kind":
+		default:
+			layout, ok := dateLayout(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown placeholder {%s} in %q", name, raw)
+			}
+			part.dateLayout = layout
+		}
+		t.parts = append(t.parts, part)
+		rest = rest[open+end+1:]
+	}
+
+	if err := validateIndexName(strings.ReplaceAll(t.pattern(), "*", "x")); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Function to convert a yyyy/MM/dd/HH date placeholder to a Go layout
+func dateLayout(name string) (string, bool) {
+	replacer := strings.NewReplacer("yyyy", "2006", "MM", "01", "dd", "02", "HH", "15")
+	layout := replacer.Replace(name)
+	if layout == name {
+		return "", false
+	}
+	for _, c := range layout {
+		if !strings.ContainsRune("0123456789.-_", c) {
+			return "", false
+		}
+	}
+	return layout, true
+}
+
+// Function to report whether the template renders the same name for
+// every event
+func (t *indexTemplate) static() bool {
+	for _, p := range t.parts {
+		if p.placeholder != "" && p.placeholder != "prefix" {
+			return false
+		}
+	}
+	return true
+}
+
+// Function to render the index name for an event received at the given
+// time, the current time if zero
+func (t *indexTemplate) render(event Event, received time.Time) string {
+	eventTime := indexTime(event, received)
+
+	var b strings.Builder
+	for _, p := range t.parts {
+		switch {
+		case p.placeholder == "":
+			b.WriteString(p.literal)
+		case p.placeholder == "prefix":
+			b.WriteString(sanitizeIndexSegment(t.prefix, ""))
+		case p.placeholder == "org":
+			b.WriteString(sanitizeIndexSegment(event.OrgUUID, defaultOrgSegment))
+		case p.placeholder == "kind":
+			b.WriteString(sanitizeIndexSegment(event.InvolvedObject.Kind, defaultKindSegment))
+		default:
+			b.WriteString(eventTime.Format(p.dateLayout))
+		}
+	}
+	return b.String()
+}
+
+// Function to choose the time that dates an event's index
+func indexTime(event Event, received time.Time) time.Time {
+	if received.IsZero() {
+		received = time.Now()
+	}
+	received = received.UTC()
+	eventTime, err := time.Parse(time.RFC3339, event.EventTime)
+	if err != nil {
+		return received
+	}
+	if skew := eventTime.Sub(received); skew > maxIndexTimeSkew || skew < -maxIndexTimeSkew {
+		return received
+	}
+	return eventTime.UTC()
+}
+
+// Function to build the wildcard pattern matching every rendered name,
+// used for index templates
+func (t *indexTemplate) pattern() string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		switch p.placeholder {
+		case "":
+			b.WriteString(p.literal)
+		case "prefix":
+			b.WriteString(sanitizeIndexSegment(t.prefix, ""))
+		default:
+			b.WriteString("*")
+		}
+	}
+	return b.String()
+}
+
+// Function to name the index template registered for this index
+func (t *indexTemplate) templateName(name string) string {
+	templateName := "go-opensearch-logging-" + name
+	if prefix := sanitizeIndexSegment(t.prefix, ""); prefix != "" {
+		templateName = prefix + "-" + templateName
+	}
+	return templateName
+}
+
+func (t *indexTemplate) String() string { return t.raw }
+
+// Function to make a field value safe to embed in an index name. Only
+// lower-case letters, digits, '.', '_' and '-' are kept, others become
+// '-'; the value may not start with '.', '_', '-' or '+', which would be
+// a hidden, internal or invalid index at the start of a name, and is
+// capped at maxIndexSegmentBytes.
+func sanitizeIndexSegment(value, fallback string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	var b strings.Builder
+	for _, c := range value {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+			b.WriteRune(c)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	segment := strings.TrimLeft(b.String(), "._-")
+	if len(segment) > maxIndexSegmentBytes {
+		segment = segment[:maxIndexSegmentBytes]
+	}
+	if segment == "" {
+		return fallback
+	}
+	return segment
+}
+
+// indexNames holds the parsed index templates
+type indexNames struct {
+	events      *indexTemplate
+	metrics     *indexTemplate
+	deadLetters *indexTemplate
+}
+
+// Function to parse the configured index templates
+func newIndexNames(c IndicesConfig) (indexNames, error) {
+	events, err := parseIndexTemplate(c.Events, c.Prefix)
+	if err != nil {
+		return indexNames{}, fmt.Errorf("indices.events: %w", err)
+	}
+	metrics, err := parseIndexTemplate(c.Metrics, c.Prefix)
+	if err != nil {
+		return indexNames{}, fmt.Errorf("indices.metrics: %w", err)
+	}
+	deadLetters, err := parseIndexTemplate(c.DeadLetters, c.Prefix)
+	if err != nil {
+		return indexNames{}, fmt.Errorf("indices.deadLetters: %w", err)
+	}
+	if !deadLetters.static() {
+		return indexNames{}, fmt.Errorf("indices.deadLetters: %q may only use the {prefix} placeholder", c.DeadLetters)
+	}
+	return indexNames{events: events, metrics: metrics, deadLetters: deadLetters}, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIndexTemplateRender(t *testing.T) {
+	received := time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+	event := func(org, kind, eventTime string) Event {
+		var e Event
+		e.OrgUUID, e.InvolvedObject.Kind, e.EventTime = org, kind, eventTime
+		return e
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		event    Event
+		want     string
+	}{
+		{
+			name:     "event time dates the index",
+			template: "{prefix}-events-{org}-{yyyy.MM.dd}",
+			event:    event("8f14e45f-ceea-467f-a0e6-3f1c8b1f2a11", "Pod", "2025-06-14T23:30:00-02:00"),
+			want:     "prod-events-8f14e45f-ceea-467f-a0e6-3f1c8b1f2a11-2025.06.15",
+		},
+		{
+			name:     "missing fields fall back",
+			template: "{kind}-{org}-{yyyy.MM.dd}",
+			event:    event("", " ", ""),
+			want:     "unknown-default-2025.06.15",
+		},
+		{
+			name:     "a day early is kept",
+			template: "events-{yyyy.MM.dd.HH}",
+			event:    event("", "", "2025-06-14T12:00:00Z"),
+			want:     "events-2025.06.14.12",
+		},
+		{
+			name:     "more than a day in the past takes the receipt",
+			template: "events-{yyyy.MM.dd}",
+			event:    event("", "", "1999-01-01T00:00:00Z"),
+			want:     "events-2025.06.15",
+		},
+		{
+			name:     "more than a day in the future takes the receipt",
+			template: "events-{yyyy.MM.dd}",
+			event:    event("", "", "2025-06-16T12:00:01Z"),
+			want:     "events-2025.06.15",
+		},
+		{
+			name:     "unparsable time takes the receipt",
+			template: "events-{yyyy.MM}",
+			event:    event("", "", "yesterday"),
+			want:     "events-2025.06",
+		},
+		{
+			name:     "characters not allowed in index names",
+			template: "events-{org}",
+			event:    event(`Acme Corp/EU:"West"*?#`, "", ""),
+			want:     "events-acme-corp-eu--west----",
+		},
+		{
+			name:     "no hidden or system index from a leading field",
+			template: "{org}-events",
+			event:    event("._-+acme", "", ""),
+			want:     "acme-events",
+		},
+		{
+			name:     "only disallowed characters",
+			template: "{org}-{kind}-events",
+			event:    event("..", "__", ""),
+			want:     "default-unknown-events",
+		},
+		{
+			name:     "non-ASCII is replaced",
+			template: "events-{kind}",
+			event:    event("", "Ünïcode", ""),
+			want:     "events-n-code",
+		},
+		{
+			name:     "long values are capped",
+			template: "events-{org}",
+			event:    event(strings.Repeat("a", 300), "", ""),
+			want:     "events-" + strings.Repeat("a", maxIndexSegmentBytes),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parseIndexTemplate(tt.template, "prod")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := tmpl.render(tt.event, received)
+			if got != tt.want {
+				t.Errorf("render = %q, want %q", got, tt.want)
+			}
+			if err := validateIndexName(got); err != nil {
+				t.Errorf("rendered an invalid index name: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseIndexTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		pattern  string
+		err      string
+	}{
+		{template: "{prefix}-events-{org}-{yyyy.MM.dd}", pattern: "prod-events-*-*"},
+		{template: "metrics2", pattern: "metrics2"},
+		{template: "events-{yyyy}", pattern: "events-*"},
+		{template: "events-{org", err: "unterminated placeholder"},
+		{template: "events-{region}", err: "unknown placeholder {region}"},
+		{template: "events-{yyyy/MM}", err: "unknown placeholder"},
+		{template: "Events", err: "must be lowercase"},
+		{template: "_events", err: "must not start with"},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseIndexTemplate(tt.template, "prod")
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.template, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.template, err)
+			continue
+		}
+		if got := tmpl.pattern(); got != tt.pattern {
+			t.Errorf("%s: pattern %q, want %q", tt.template, got, tt.pattern)
+		}
+	}
+}
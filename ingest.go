@@ -0,0 +1,582 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IngestConfig controls how /event acknowledges producers. In async mode
+// the event is queued and 202 Accepted is returned straight away; in sync
+// mode the response waits until every document has been written. Either
+// way the outcome can be looked up at /event/status/{id} until it expires.
+type IngestConfig struct {
+	Mode          string        `yaml:"mode" json:"mode" env:"INGEST_MODE"`
+	StatusTTL     time.Duration `yaml:"statusTTL" json:"statusTTL" env:"INGEST_STATUS_TTL"`
+	StatusEntries int           `yaml:"statusEntries" json:"statusEntries" env:"INGEST_STATUS_ENTRIES"`
+}
+
+// Function to validate the ingest settings
+func (c IngestConfig) validate() error {
+	var errs []error
+	if c.Mode != "async" && c.Mode != "sync" {
+		errs = append(errs, fmt.Errorf("ingest.mode: %q is not one of async, sync", c.Mode))
+	}
+	if c.StatusTTL <= 0 {
+		errs = append(errs, errors.New("ingest.statusTTL: must be positive"))
+	}
+	if c.StatusEntries <= 0 {
+		errs = append(errs, errors.New("ingest.statusEntries: must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// Delivery states reported by /event/status/{id}
+const (
+	stateQueued       = "queued"
+	stateRetrying     = "retrying"
+	stateIndexed      = "indexed"
+	stateDuplicate    = "duplicate"
+	stateFailed       = "failed"
+	stateDeadLettered = "dead_lettered"
+)
+
+// ingestStatus is the delivery state of one accepted event and the
+// documents written for it
+type ingestStatus struct {
+	ID        string           `json:"id"`
+	State     string           `json:"state"`
+	Received  time.Time        `json:"received"`
+	Updated   time.Time        `json:"updated"`
+	Documents []documentStatus `json:"documents"`
+
+	// done is closed once every document has a result
+	done chan struct{}
+}
+
+// Function to find the _id of the event document itself, once known
+func (status ingestStatus) documentID() string {
+	for _, d := range status.Documents {
+		if d.Kind == "event" {
+			return d.ID
+		}
+	}
+	return ""
+}
+
+// documentStatus is the delivery state of a single document. Persisted
+// tells whether it is in its index now, written by this event or before.
+type documentStatus struct {
+	Kind      string `json:"kind"`
+	Index     string `json:"index"`
+	ID        string `json:"id,omitempty"`
+	Required  bool   `json:"required"`
+	State     string `json:"state"`
+	Persisted bool   `json:"persisted"`
+	Error     string `json:"error,omitempty"`
+}
+
+// statusStore keeps the delivery state of recent events in memory, and
+// when each recent idempotency key was first received. Entries expire
+// after the TTL, and the oldest are evicted once the store is full.
+type statusStore struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	max      int
+	entries  map[string]*ingestStatus
+	order    []string
+	keys     map[string]time.Time
+	keyOrder []string
+}
+
+// Function to create a status store
+func newStatusStore(c IngestConfig) *statusStore {
+	return &statusStore{
+		ttl:     c.StatusTTL,
+		max:     c.StatusEntries,
+		entries: make(map[string]*ingestStatus),
+		keys:    make(map[string]time.Time),
+	}
+}
+
+// Function to return when the event with an idempotency key was first
+// received, recording received if the key is new
+func (st *statusStore) firstReceived(key string, received time.Time) time.Time {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for len(st.keyOrder) > 0 {
+		oldest := st.keys[st.keyOrder[0]]
+		if len(st.keys) <= st.max && received.Sub(oldest) < st.ttl {
+			break
+		}
+		delete(st.keys, st.keyOrder[0])
+		st.keyOrder = st.keyOrder[1:]
+	}
+
+	if first, ok := st.keys[key]; ok {
+		return first
+	}
+	st.keys[key] = received
+	st.keyOrder = append(st.keyOrder, key)
+	return received
+}
+
+// Function to start tracking an event with every document queued
+func (st *statusStore) track(id string, out []outgoingDoc) {
+	now := time.Now()
+	status := &ingestStatus{ID: id, State: stateQueued, Received: now, Updated: now, done: make(chan struct{})}
+	for _, o := range out {
+		status.Documents = append(status.Documents, documentStatus{
+			Kind:     o.kind,
+			Index:    o.index,
+			ID:       o.id,
+			Required: o.required,
+			State:    stateQueued,
+		})
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.entries[id] = status
+	st.order = append(st.order, id)
+	st.evict(now)
+}
+
+// Function to record the result of document i of an event. The event is
+// indexed once every required document is, and failed as soon as one of
+// them fails; optional documents only show up in the breakdown.
+func (st *statusStore) update(id string, i int, res bulkResult) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	status, ok := st.entries[id]
+	if !ok {
+		return
+	}
+
+	doc := &status.Documents[i]
+	if res.Index != "" {
+		doc.Index = res.Index
+	}
+	if res.ID != "" {
+		doc.ID = res.ID
+	}
+	doc.State, doc.Persisted = stateIndexed, res.Err == nil
+	if res.Duplicate {
+		doc.State = stateDuplicate
+	}
+	if res.Err != nil {
+		doc.State = stateFailed
+		doc.Error = res.Err.Error()
+	}
+	status.Updated = time.Now()
+
+	st.settle(status)
+}
+
+// Function to move document i of an event to another state, e.g. when it
+// is waiting to be retried or has been dead-lettered. A nil err keeps the
+// last error.
+func (st *statusStore) mark(id string, i int, state string, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	status, ok := st.entries[id]
+	if !ok {
+		return
+	}
+
+	doc := &status.Documents[i]
+	doc.State = state
+	if err != nil {
+		doc.Error = err.Error()
+	}
+	status.Updated = time.Now()
+	st.settle(status)
+}
+
+// Function to derive an event's state from its documents, and wake up
+// waiters once every document has a final result. An event is a duplicate
+// when every required document already existed. Callers must hold st.mu.
+func (st *statusStore) settle(status *ingestStatus) {
+	state := stateIndexed
+	complete, duplicate := true, true
+	for _, d := range status.Documents {
+		if d.State == stateQueued || d.State == stateRetrying {
+			complete = false
+		}
+		if d.Required && d.State != stateDuplicate {
+			duplicate = false
+		}
+		// failed beats dead-lettered beats retrying beats queued beats
+		// indexed
+		switch {
+		case d.Required && d.State == stateFailed:
+			state = stateFailed
+		case state == stateFailed:
+		case d.Required && d.State == stateDeadLettered:
+			state = stateDeadLettered
+		case state == stateDeadLettered:
+		case d.State == stateRetrying:
+			state = stateRetrying
+		case d.State == stateQueued && state == stateIndexed:
+			state = stateQueued
+		}
+	}
+	if state == stateIndexed && duplicate {
+		state = stateDuplicate
+	}
+	status.State = state
+	if complete && status.done != nil {
+		close(status.done)
+		status.done = nil
+	}
+}
+
+// Function to block until every document of an event has a result or ctx
+// ends, then return its status
+func (st *statusStore) wait(ctx context.Context, id string) (ingestStatus, bool) {
+	st.mu.Lock()
+	status, ok := st.entries[id]
+	var done chan struct{}
+	if ok {
+		done = status.done
+	}
+	st.mu.Unlock()
+	if !ok {
+		return ingestStatus{}, false
+	}
+
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	}
+	return st.get(id)
+}
+
+// Function to look up the status of an event
+func (st *statusStore) get(id string) (ingestStatus, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.evict(time.Now())
+	status, ok := st.entries[id]
+	if !ok {
+		return ingestStatus{}, false
+	}
+	out := *status
+	out.Documents = append([]documentStatus(nil), status.Documents...)
+	return out, true
+}
+
+// Function to drop expired entries and the oldest ones over capacity.
+// Callers must hold st.mu.
+func (st *statusStore) evict(now time.Time) {
+	for len(st.order) > 0 {
+		oldest := st.entries[st.order[0]]
+		if len(st.entries) <= st.max && now.Sub(oldest.Received) < st.ttl {
+			return
+		}
+		delete(st.entries, st.order[0])
+		st.order = st.order[1:]
+	}
+}
+
+// Function to generate a random ingestion ID
+func newIngestionID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Function to decide whether a request waits for its writes. The
+// configured mode applies unless the producer asks otherwise with
+// ?sync=true or ?sync=false.
+func (s *Server) syncRequested(r *http.Request) (bool, error) {
+	raw := r.URL.Query().Get("sync")
+	if raw == "" {
+		return s.config.Ingest.Mode == "sync", nil
+	}
+	sync, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid sync parameter %q", raw)
+	}
+	return sync, nil
+}
+
+// Function to record a document's result and log failures
+func (s *Server) recordResult(id string, i int, o outgoingDoc, res bulkResult) {
+	if res.Err != nil {
+		log.Printf("Failed to index %s into %s (ingestion %s): %v", o.kind, o.index, id, res.Err)
+	}
+	s.statuses.update(id, i, res)
+}
+
+// Handler for looking up the delivery status of an event
+func (s *Server) handleEventStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/event/status/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	status, ok := s.statuses.get(id)
+	if !ok {
+		http.Error(w, "Unknown or expired ingestion ID", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// ingestion is an accepted event and the documents to write for it
+type ingestion struct {
+	id      string
+	meta    requestMeta
+	warning bool
+	out     []outgoingDoc
+	docs    []bulkDoc
+}
+
+// Function to check an event a producer sent before accepting it, so that
+// it is refused up front rather than failing once it is written. Times
+// must be RFC 3339, as OpenSearch maps them as dates; an event without an
+// event time is dated when it is received.
+func validateEvent(event Event) error {
+	var errs []error
+	if event.Type != "Normal" && event.Type != "Warning" {
+		errs = append(errs, fmt.Errorf("type: %q is not one of Normal, Warning", event.Type))
+	}
+	if event.InvolvedObject.Kind == "" {
+		errs = append(errs, errors.New("involvedObject.kind: is required"))
+	}
+	if event.InvolvedObject.Name == "" {
+		errs = append(errs, errors.New("involvedObject.name: is required"))
+	}
+	times := [][2]string{{"eventTime", event.EventTime}, {"metadata.deletionTimestamp", event.Metadata.DeletionTimestamp}}
+	if event.Series != nil {
+		times = append(times, [2]string{"series.lastObservedTime", event.Series.LastObservedTime})
+	}
+	for _, t := range times {
+		if t[1] == "" {
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, t[1]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %q is not an RFC 3339 time", t[0], t[1]))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Function to build the documents for an event and start tracking its
+// delivery. header is the producer's idempotency key, if any.
+func (s *Server) newIngestion(ctx context.Context, meta requestMeta, header string, event Event) (*ingestion, error) {
+	// The key is taken before defaults are filled in, which would differ
+	// between retries
+	key := s.idempotencyKey(header, event)
+
+	// The receipt time dates the index when the event has no time of its
+	// own, or one too far from it, so a retry of a keyed event takes the
+	// time of the first attempt, to land in the same index even after
+	// midnight and be found to be a duplicate
+	received := meta.ReceivedAt
+	if received.IsZero() {
+		received = time.Now().UTC()
+	}
+	if key != "" {
+		received = s.statuses.firstReceived(key, received)
+	}
+	if event.EventTime == "" {
+		event.EventTime = received.Format(time.RFC3339)
+	}
+
+	out := s.eventDocuments(ctx, event, received)
+	docs := make([]bulkDoc, len(out))
+	for i, o := range out {
+		body, err := json.Marshal(o.doc)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding %s: %w", o.kind, err)
+		}
+		if key != "" {
+			out[i].id = documentID(key, o.kind)
+		}
+		docs[i] = out[i].bulkDoc(body)
+	}
+
+	ing := &ingestion{id: newIngestionID(), meta: meta, warning: event.Type == "Warning", out: out, docs: docs}
+	s.statuses.track(ing.id, out)
+	return ing, nil
+}
+
+// Function to hand events over for writing: to the spool if it is
+// enabled, otherwise to the bulk indexer, waiting for the writes in sync
+// mode. It returns the error of each event that could not be handed over;
+// their documents are failed but not dead-lettered, since the producer is
+// told to retry. clientCtx is the producer's request, which bounds how
+// long events wait for queue space.
+func (s *Server) submit(ctx, clientCtx context.Context, ings []*ingestion, wait bool) []error {
+	errs := make([]error, len(ings))
+	switch {
+	case s.spool != nil:
+		for i, ing := range ings {
+			errs[i] = s.spoolIngestion(ing)
+		}
+	case !wait:
+		queueCtx, cancel := s.queueContext(clientCtx)
+		defer cancel()
+		for i, ing := range ings {
+			errs[i] = s.queueIngestion(queueCtx, ing)
+		}
+	default:
+		s.indexIngestions(ctx, ings)
+	}
+	return errs
+}
+
+// Function to ingest an event from one of the built-in sources. Unlike
+// HTTP producers these cannot be told to retry, so it waits for room in
+// the bulk queue or the spool until ctx is done instead of shedding.
+func (s *Server) ingestEvent(ctx context.Context, meta requestMeta, event Event) error {
+	ing, err := s.newIngestion(ctx, meta, "", event)
+	if err != nil {
+		return err
+	}
+	if s.spool == nil {
+		return s.queueIngestion(ctx, ing)
+	}
+	for attempt := 1; ; attempt++ {
+		err := s.spoolIngestion(ing)
+		if !errors.Is(err, errSpoolFull) {
+			return err
+		}
+		if err := s.retry.wait(ctx, attempt, 0); err != nil {
+			return err
+		}
+	}
+}
+
+// Function to persist an event to the spool, from where it is shipped
+func (s *Server) spoolIngestion(ing *ingestion) error {
+	rec := spoolRecord{ID: ing.id, Accepted: time.Now(), Request: ing.meta}
+	for i, o := range ing.out {
+		rec.Docs = append(rec.Docs, spoolDoc{Kind: o.kind, Index: o.index, Required: o.required, ID: o.id, Body: ing.docs[i].body})
+	}
+
+	if err := s.spool.append(rec); err != nil {
+		for i, o := range ing.out {
+			s.recordResult(ing.id, i, o, bulkResult{Index: o.index, Err: err})
+		}
+		return err
+	}
+	return nil
+}
+
+// Function to queue an event's documents without waiting for them
+func (s *Server) queueIngestion(ctx context.Context, ing *ingestion) error {
+	docs := slices.Clone(ing.docs)
+	collectResults(docs, func(results []bulkResult) { s.deliver(ing, results) })
+	if err := s.bulk.add(ctx, docs...); err != nil {
+		for i, o := range ing.out {
+			s.recordResult(ing.id, i, o, bulkResult{Index: o.index, Err: err})
+		}
+		return err
+	}
+	return nil
+}
+
+// Function to write the documents of events and wait for them
+func (s *Server) indexIngestions(ctx context.Context, ings []*ingestion) {
+	units := make([][]bulkDoc, len(ings))
+	for i, ing := range ings {
+		units[i] = ing.docs
+	}
+	for i, results := range s.bulk.indexUnits(ctx, units) {
+		s.deliver(ings[i], results)
+	}
+}
+
+// Function to record the outcome of an event's documents, which were
+// written as a unit. The required document, the event itself, decides:
+// if it was stored, metrics that failed are reported on their own. If it
+// was not, metrics stored alongside it are deleted again, so that none
+// exist for an event that was never stored, and the whole unit fails and
+// is dead-lettered together for a later replay.
+func (s *Server) deliver(ing *ingestion, results []bulkResult) {
+	for i, o := range ing.out {
+		if o.required && results[i].Err != nil {
+			s.rollback(ing, results, results[i].Err)
+			break
+		}
+	}
+	for i, o := range ing.out {
+		s.recordDelivery(ing.id, i, o, ing.docs[i].body, ing.meta, results[i])
+	}
+}
+
+// Function to delete the optional documents of an event that this attempt
+// stored although the event was not, failing them with the event's error.
+// Duplicates were stored by an earlier attempt and are left alone. A
+// document that cannot be deleted stays reported as stored.
+func (s *Server) rollback(ing *ingestion, results []bulkResult, cause error) {
+	var deletes []bulkDoc
+	var rolled []int
+	for i, o := range ing.out {
+		if o.required || results[i].Duplicate {
+			continue
+		}
+		if results[i].Err != nil {
+			results[i].Err = fmt.Errorf("%w (and the event was not stored)", results[i].Err)
+			continue
+		}
+		deletes = append(deletes, bulkDoc{action: "delete", index: results[i].Index, id: results[i].ID})
+		rolled = append(rolled, i)
+	}
+	if len(deletes) == 0 {
+		return
+	}
+
+	// Written directly rather than queued, since this may run on a bulk
+	// worker that the queue is waiting for
+	for n, res := range s.bulk.write(context.Background(), deletes) {
+		i := rolled[n]
+		if res.Err != nil && res.Status != http.StatusNotFound {
+			log.Printf("Warning: failed to roll back %s %s/%s of unstored event (ingestion %s): %v", ing.out[i].kind, results[i].Index, results[i].ID, ing.id, res.Err)
+			continue
+		}
+		results[i] = bulkResult{Index: results[i].Index, Err: fmt.Errorf("rolled back, the event was not stored: %w", cause)}
+	}
+}
+
+// Function to tell whether a submit error means the service is saturated,
+// and which buffer is full
+func (s *Server) shedReason(err error) (string, bool) {
+	if errors.Is(err, errSpoolFull) {
+		return "spool", true
+	}
+	if s.spool == nil {
+		return "bulk", true
+	}
+	return "", false
+}
+
+// Function to make a label or attribute key safe as one path segment of an
+// object field. OpenSearch expands dots into nested objects, so the keys
+// a.b and a.b.c would need a.b to be both a value and an object.
+func flatKey(s string) string {
+	return strings.ReplaceAll(s, ".", "_")
+}
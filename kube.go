@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// KubernetesConfig controls the built-in source that watches Events on
+// the Kubernetes API server. API selects events.k8s.io/v1 or core/v1; both
+// serve the same events, so only one is watched. The last seen
+// resourceVersion is kept in StateFile, when set, so a restart resumes
+// where the previous run stopped instead of skipping ahead.
+type KubernetesConfig struct {
+	Enabled    bool   `yaml:"enabled" json:"enabled" env:"KUBERNETES_EVENTS_ENABLED"`
+	API        string `yaml:"api" json:"api" env:"KUBERNETES_EVENTS_API"`
+	Namespace  string `yaml:"namespace" json:"namespace" env:"KUBERNETES_EVENTS_NAMESPACE"`
+	Kubeconfig string `yaml:"kubeconfig" json:"kubeconfig" env:"KUBECONFIG"`
+	StateFile  string `yaml:"stateFile" json:"stateFile" env:"KUBERNETES_EVENTS_STATE_FILE"`
+}
+
+// Function to validate the Kubernetes source settings
+func (c KubernetesConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.API != "events.k8s.io/v1" && c.API != "core/v1" {
+		return fmt.Errorf("kubernetes.api: %q is not one of events.k8s.io/v1, core/v1", c.API)
+	}
+	return nil
+}
+
+// kubeEventSource feeds Kubernetes Events into the ingestion pipeline
+type kubeEventSource struct {
+	client  kubernetes.Interface
+	config  KubernetesConfig
+	retry   *retryPolicy
+	ingest  func(ctx context.Context, meta requestMeta, event Event) error
+	version string
+	saved   time.Time
+}
+
+// Function to create a Kubernetes event source. client can be any
+// kubernetes.Interface, including a fake clientset.
+func newKubeEventSource(client kubernetes.Interface, config KubernetesConfig, retry *retryPolicy, ingest func(context.Context, requestMeta, Event) error) *kubeEventSource {
+	k := &kubeEventSource{client: client, config: config, retry: retry, ingest: ingest}
+	if config.StateFile != "" {
+		data, err := os.ReadFile(config.StateFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Warning: failed to read Kubernetes watch state, starting from now: %v", err)
+		}
+		k.version = strings.TrimSpace(string(data))
+	}
+	return k
+}
+
+// Function to connect to the API server from a kubeconfig file, or from
+// the pod's service account when none is configured
+func newKubeClient(config KubernetesConfig) (kubernetes.Interface, error) {
+	var restConfig *rest.Config
+	var err error
+	if config.Kubeconfig != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", config.Kubeconfig)
+	} else {
+		restConfig, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading Kubernetes client configuration: %w", err)
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Kubernetes client: %w", err)
+	}
+	return client, nil
+}
+
+// Function to watch events until ctx is cancelled, re-establishing the
+// watch from the last resourceVersion whenever it ends. A version the API
+// server no longer has (410 Gone) means starting over from now.
+func (k *kubeEventSource) run(ctx context.Context) {
+	log.Printf("Watching Kubernetes %s events", k.config.API)
+	defer k.save(true)
+
+	failures := 0
+	for ctx.Err() == nil {
+		if k.version == "" {
+			version, err := k.currentVersion(ctx)
+			if err != nil {
+				failures++
+				log.Printf("Failed to list Kubernetes events: %v", err)
+				k.retry.wait(ctx, failures, 0)
+				continue
+			}
+			k.version = version
+		}
+
+		err := k.watch(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case apierrors.IsResourceExpired(err) || apierrors.IsGone(err):
+			log.Printf("Warning: Kubernetes watch from resourceVersion %s expired, events since then are lost", k.version)
+			k.version = ""
+			failures = 0
+		case err != nil:
+			failures++
+			log.Printf("Kubernetes event watch failed: %v", err)
+			k.retry.wait(ctx, failures, 0)
+		default:
+			// The API server closes watches after a while
+			failures = 0
+		}
+	}
+}
+
+// Function to get the resourceVersion to start watching from, skipping
+// the events that already exist
+func (k *kubeEventSource) currentVersion(ctx context.Context) (string, error) {
+	opts := metav1.ListOptions{Limit: 1}
+	if k.config.API == "core/v1" {
+		list, err := k.client.CoreV1().Events(k.config.Namespace).List(ctx, opts)
+		if err != nil {
+			return "", err
+		}
+		return list.ResourceVersion, nil
+	}
+	list, err := k.client.EventsV1().Events(k.config.Namespace).List(ctx, opts)
+	if err != nil {
+		return "", err
+	}
+	return list.ResourceVersion, nil
+}
+
+// Function to run one watch until it ends, ingesting every added or
+// updated event and advancing the resourceVersion as it goes. An event
+// that cannot be ingested ends the watch without advancing past it, so
+// the next watch delivers it again.
+func (k *kubeEventSource) watch(ctx context.Context) error {
+	opts := metav1.ListOptions{ResourceVersion: k.version, AllowWatchBookmarks: true}
+	var w watch.Interface
+	var err error
+	if k.config.API == "core/v1" {
+		w, err = k.client.CoreV1().Events(k.config.Namespace).Watch(ctx, opts)
+	} else {
+		w, err = k.client.EventsV1().Events(k.config.Namespace).Watch(ctx, opts)
+	}
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	meta := sourceMeta("kubernetes:" + k.config.API)
+	for {
+		var change watch.Event
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case change, ok = <-w.ResultChan():
+		}
+		if !ok {
+			return nil
+		}
+
+		switch change.Type {
+		case watch.Error:
+			return apierrors.FromObject(change.Object)
+		case watch.Added, watch.Modified:
+			event, ok := kubeEvent(change.Object)
+			if !ok {
+				continue
+			}
+			meta.ReceivedAt = time.Now().UTC()
+			if err := k.ingest(ctx, meta, event); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return fmt.Errorf("error ingesting Kubernetes event %s: %w", event.Metadata.Name, err)
+			}
+		}
+		if version := objectVersion(change.Object); version != "" {
+			k.version = version
+			k.save(false)
+		}
+	}
+}
+
+// Function to persist the resourceVersion, at most once a second unless
+// forced
+func (k *kubeEventSource) save(force bool) {
+	if k.config.StateFile == "" || k.version == "" || (!force && time.Since(k.saved) < time.Second) {
+		return
+	}
+	k.saved = time.Now()
+	path := k.config.StateFile
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		log.Printf("Warning: failed to save Kubernetes watch state: %v", err)
+		return
+	}
+	if err := os.WriteFile(path+".tmp", []byte(k.version), 0o640); err != nil {
+		log.Printf("Warning: failed to save Kubernetes watch state: %v", err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Printf("Warning: failed to save Kubernetes watch state: %v", err)
+	}
+}
+
+// Function to read the resourceVersion of a watched object or bookmark
+func objectVersion(obj runtime.Object) string {
+	if accessor, ok := obj.(metav1.Object); ok {
+		return accessor.GetResourceVersion()
+	}
+	return ""
+}
+
+// Function to convert a watched object into an Event
+func kubeEvent(obj runtime.Object) (Event, bool) {
+	switch ev := obj.(type) {
+	case *corev1.Event:
+		return coreEvent(ev), true
+	case *eventsv1.Event:
+		return eventsEvent(ev), true
+	}
+	return Event{}, false
+}
+
+// Function to convert a core/v1 Event
+func coreEvent(ev *corev1.Event) Event {
+	var event Event
+	event.ApiVersion, event.Kind = "v1", "Event"
+	setKubeMetadata(&event, ev.ObjectMeta)
+	event.Metadata.Reason, event.Metadata.Message = ev.Reason, ev.Message
+	event.InvolvedObject.Kind = ev.InvolvedObject.Kind
+	event.InvolvedObject.Name = ev.InvolvedObject.Name
+	event.InvolvedObject.UUID = string(ev.InvolvedObject.UID)
+	event.Action, event.Type = ev.Action, ev.Type
+	event.Count = int(ev.Count)
+	if ev.Series != nil {
+		event.Series = &EventSeries{Count: int(ev.Series.Count), LastObservedTime: kubeTime(ev.Series.LastObservedTime.Time)}
+		event.Count = max(event.Count, int(ev.Series.Count))
+	}
+	event.EventTime = firstKubeTime(ev.Series, ev.EventTime.Time, ev.LastTimestamp.Time, ev.FirstTimestamp.Time, ev.CreationTimestamp.Time)
+	return event
+}
+
+// Function to convert an events.k8s.io/v1 Event
+func eventsEvent(ev *eventsv1.Event) Event {
+	var event Event
+	event.ApiVersion, event.Kind = "events.k8s.io/v1", "Event"
+	setKubeMetadata(&event, ev.ObjectMeta)
+	event.Metadata.Reason, event.Metadata.Message = ev.Reason, ev.Note
+	event.InvolvedObject.Kind = ev.Regarding.Kind
+	event.InvolvedObject.Name = ev.Regarding.Name
+	event.InvolvedObject.UUID = string(ev.Regarding.UID)
+	event.Action, event.Type = ev.Action, ev.Type
+	event.Count = int(ev.DeprecatedCount)
+	var series *corev1.EventSeries
+	if ev.Series != nil {
+		event.Series = &EventSeries{Count: int(ev.Series.Count), LastObservedTime: kubeTime(ev.Series.LastObservedTime.Time)}
+		event.Count = max(event.Count, int(ev.Series.Count))
+		series = &corev1.EventSeries{Count: ev.Series.Count, LastObservedTime: ev.Series.LastObservedTime}
+	}
+	event.EventTime = firstKubeTime(series, ev.EventTime.Time, ev.DeprecatedLastTimestamp.Time, ev.DeprecatedFirstTimestamp.Time, ev.CreationTimestamp.Time)
+	return event
+}
+
+// Function to copy the object metadata shared by both Event APIs. The
+// event's UID correlates its updates.
+func setKubeMetadata(event *Event, meta metav1.ObjectMeta) {
+	event.Metadata.Name = meta.Name
+	event.Metadata.Labels = meta.Labels
+	if meta.DeletionTimestamp != nil {
+		event.Metadata.DeletionTimestamp = kubeTime(meta.DeletionTimestamp.Time)
+	}
+	event.CorrelationID = string(meta.UID)
+}
+
+// Function to pick when an event last happened: the series' last
+// observation, else the first of the given times that is set
+func firstKubeTime(series *corev1.EventSeries, times ...time.Time) string {
+	if series != nil && !series.LastObservedTime.IsZero() {
+		return kubeTime(series.LastObservedTime.Time)
+	}
+	for _, t := range times {
+		if !t.IsZero() {
+			return kubeTime(t)
+		}
+	}
+	return ""
+}
+
+// Function to format a Kubernetes timestamp like other event times
+func kubeTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+func TestKubeEventConversion(t *testing.T) {
+	first := metav1.NewTime(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+	last := metav1.NewTime(time.Date(2024, 5, 1, 10, 5, 0, 0, time.UTC))
+	observed := metav1.NewMicroTime(time.Date(2024, 5, 1, 10, 7, 30, 123000000, time.UTC))
+	deleted := metav1.NewTime(time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC))
+	meta := metav1.ObjectMeta{
+		Name:              "web-1.17a",
+		Namespace:         "shop",
+		UID:               "event-uid",
+		Labels:            map[string]string{"app": "web"},
+		DeletionTimestamp: &deleted,
+	}
+
+	tests := []struct {
+		name string
+		obj  runtime.Object
+		want Event
+	}{
+		{
+			name: "core/v1",
+			obj: &corev1.Event{
+				ObjectMeta:     meta,
+				InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "web-1", UID: "pod-uid"},
+				Reason:         "BackOff",
+				Message:        "Back-off restarting failed container",
+				Type:           "Warning",
+				Action:         "Restarting",
+				Count:          3,
+				FirstTimestamp: first,
+				LastTimestamp:  last,
+			},
+			want: func() Event {
+				var e Event
+				e.ApiVersion, e.Kind = "v1", "Event"
+				e.Metadata.Name, e.Metadata.Labels = "web-1.17a", map[string]string{"app": "web"}
+				e.Metadata.DeletionTimestamp = "2024-05-01T11:00:00Z"
+				e.Metadata.Reason, e.Metadata.Message = "BackOff", "Back-off restarting failed container"
+				e.InvolvedObject.Kind, e.InvolvedObject.Name, e.InvolvedObject.UUID = "Pod", "web-1", "pod-uid"
+				e.Action, e.Type, e.Count = "Restarting", "Warning", 3
+				e.EventTime = "2024-05-01T10:05:00Z"
+				e.CorrelationID = "event-uid"
+				return e
+			}(),
+		},
+		{
+			name: "core/v1 series",
+			obj: &corev1.Event{
+				ObjectMeta:     metav1.ObjectMeta{Name: "web-1.17b", UID: "series-uid"},
+				InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "web-1"},
+				Type:           "Normal",
+				Count:          2,
+				Series:         &corev1.EventSeries{Count: 7, LastObservedTime: observed},
+				LastTimestamp:  last,
+			},
+			want: func() Event {
+				var e Event
+				e.ApiVersion, e.Kind = "v1", "Event"
+				e.Metadata.Name = "web-1.17b"
+				e.InvolvedObject.Kind, e.InvolvedObject.Name = "Pod", "web-1"
+				e.Type, e.Count = "Normal", 7
+				e.Series = &EventSeries{Count: 7, LastObservedTime: "2024-05-01T10:07:30.123Z"}
+				e.EventTime = "2024-05-01T10:07:30.123Z"
+				e.CorrelationID = "series-uid"
+				return e
+			}(),
+		},
+		{
+			name: "events.k8s.io/v1",
+			obj: &eventsv1.Event{
+				ObjectMeta:               meta,
+				Regarding:                corev1.ObjectReference{Kind: "Node", Name: "node-a", UID: "node-uid"},
+				Reason:                   "NodeNotReady",
+				Note:                     "Node is not ready",
+				Type:                     "Warning",
+				Action:                   "Checking",
+				DeprecatedCount:          4,
+				DeprecatedFirstTimestamp: first,
+				Series:                   &eventsv1.EventSeries{Count: 9, LastObservedTime: observed},
+			},
+			want: func() Event {
+				var e Event
+				e.ApiVersion, e.Kind = "events.k8s.io/v1", "Event"
+				e.Metadata.Name, e.Metadata.Labels = "web-1.17a", map[string]string{"app": "web"}
+				e.Metadata.DeletionTimestamp = "2024-05-01T11:00:00Z"
+				e.Metadata.Reason, e.Metadata.Message = "NodeNotReady", "Node is not ready"
+				e.InvolvedObject.Kind, e.InvolvedObject.Name, e.InvolvedObject.UUID = "Node", "node-a", "node-uid"
+				e.Action, e.Type, e.Count = "Checking", "Warning", 9
+				e.Series = &EventSeries{Count: 9, LastObservedTime: "2024-05-01T10:07:30.123Z"}
+				e.EventTime = "2024-05-01T10:07:30.123Z"
+				e.CorrelationID = "event-uid"
+				return e
+			}(),
+		},
+		{
+			name: "events.k8s.io/v1 without series",
+			obj: &eventsv1.Event{
+				ObjectMeta: metav1.ObjectMeta{Name: "x", CreationTimestamp: first},
+				Regarding:  corev1.ObjectReference{Kind: "Deployment", Name: "web"},
+				Type:       "Normal",
+			},
+			want: func() Event {
+				var e Event
+				e.ApiVersion, e.Kind = "events.k8s.io/v1", "Event"
+				e.Metadata.Name = "x"
+				e.InvolvedObject.Kind, e.InvolvedObject.Name = "Deployment", "web"
+				e.Type = "Normal"
+				e.EventTime = "2024-05-01T10:00:00Z"
+				return e
+			}(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := kubeEvent(tt.obj)
+			if !ok {
+				t.Fatal("not converted")
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, ok := kubeEvent(&corev1.Pod{}); ok {
+		t.Error("a Pod was converted into an event")
+	}
+}
+
+// kubeHarness runs a Kubernetes event source against a fake clientset and
+// hands each watch it opens to the test
+type kubeHarness struct {
+	t        *testing.T
+	config   KubernetesConfig
+	client   *fake.Clientset
+	watches  chan kubeWatch
+	lists    chan struct{}
+	ingested chan Event
+
+	mu         sync.Mutex
+	failIngest int
+}
+
+// kubeWatch is a watch opened by the source and the resourceVersion it
+// asked for
+type kubeWatch struct {
+	version string
+	watcher *watch.RaceFreeFakeWatcher
+}
+
+// Function to set up a source for api that keeps its state in a
+// temporary file holding version, if set
+func newKubeHarness(t *testing.T, api, version, listVersion string) *kubeHarness {
+	h := &kubeHarness{
+		t:        t,
+		config:   KubernetesConfig{Enabled: true, API: api, StateFile: filepath.Join(t.TempDir(), "state")},
+		client:   fake.NewClientset(),
+		watches:  make(chan kubeWatch, 10),
+		lists:    make(chan struct{}, 10),
+		ingested: make(chan Event, 10),
+	}
+	if version != "" {
+		if err := os.WriteFile(h.config.StateFile, []byte(version+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h.client.PrependReactor("list", "events", func(k8stesting.Action) (bool, runtime.Object, error) {
+		h.lists <- struct{}{}
+		if api == "core/v1" {
+			return true, &corev1.EventList{ListMeta: metav1.ListMeta{ResourceVersion: listVersion}}, nil
+		}
+		return true, &eventsv1.EventList{ListMeta: metav1.ListMeta{ResourceVersion: listVersion}}, nil
+	})
+	h.client.PrependWatchReactor("events", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		restrictions := action.(k8stesting.WatchActionImpl).GetWatchRestrictions()
+		w := watch.NewRaceFreeFake()
+		h.watches <- kubeWatch{version: restrictions.ResourceVersion, watcher: w}
+		return true, w, nil
+	})
+	return h
+}
+
+// Function to start the source, returning a function that stops it and
+// waits for it to exit
+func (h *kubeHarness) start() func() {
+	retry, err := newRetryPolicy(RetryConfig{MaxAttempts: 5, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, Timeout: time.Second}, noop.NewMeterProvider().Meter("test"))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	ingest := func(ctx context.Context, meta requestMeta, event Event) error {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.failIngest > 0 {
+			h.failIngest--
+			return errors.New("bulk queue unavailable")
+		}
+		if meta.Path != "kubernetes:"+h.config.API {
+			h.t.Errorf("ingested with path %q", meta.Path)
+		}
+		h.ingested <- event
+		return nil
+	}
+	source := newKubeEventSource(h.client, h.config, retry, ingest)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		source.run(ctx)
+	}()
+	return func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			h.t.Fatal("source did not stop")
+		}
+	}
+}
+
+// Function to wait for the next watch and check where it starts
+func (h *kubeHarness) nextWatch(version string) *watch.RaceFreeFakeWatcher {
+	h.t.Helper()
+	select {
+	case w := <-h.watches:
+		if w.version != version {
+			h.t.Fatalf("watch started at resourceVersion %q, want %q", w.version, version)
+		}
+		return w.watcher
+	case <-time.After(5 * time.Second):
+		h.t.Fatalf("no watch from resourceVersion %q", version)
+	}
+	return nil
+}
+
+// Function to wait for the next ingested event
+func (h *kubeHarness) nextEvent() Event {
+	h.t.Helper()
+	select {
+	case event := <-h.ingested:
+		return event
+	case <-time.After(5 * time.Second):
+		h.t.Fatal("no event ingested")
+	}
+	return Event{}
+}
+
+// Function to read the saved resourceVersion
+func (h *kubeHarness) savedVersion() string {
+	h.t.Helper()
+	data, err := os.ReadFile(h.config.StateFile)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return string(data)
+}
+
+func coreTestEvent(name, version string) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: name, ResourceVersion: version},
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "web-1"},
+		Type:           "Normal",
+	}
+}
+
+func eventsTestEvent(name, version string) *eventsv1.Event {
+	return &eventsv1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: name, ResourceVersion: version},
+		Regarding:  corev1.ObjectReference{Kind: "Pod", Name: "web-1"},
+		Type:       "Normal",
+	}
+}
+
+func TestKubeEventSourceResumesFromSavedVersion(t *testing.T) {
+	h := newKubeHarness(t, "events.k8s.io/v1", "42", "1000")
+	stop := h.start()
+
+	w := h.nextWatch("42")
+	w.Add(eventsTestEvent("first", "43"))
+	w.Modify(eventsTestEvent("first", "44"))
+	w.Delete(eventsTestEvent("first", "45"))
+	w.Action(watch.Bookmark, &eventsv1.Event{ObjectMeta: metav1.ObjectMeta{ResourceVersion: "50"}})
+	if got := h.nextEvent().Metadata.Name; got != "first" {
+		t.Errorf("ingested %q", got)
+	}
+	if got := h.nextEvent().Metadata.Name; got != "first" {
+		t.Errorf("ingested %q", got)
+	}
+
+	// A watch closed by the API server resumes from the last version seen
+	w.Stop()
+	w = h.nextWatch("50")
+	w.Add(eventsTestEvent("second", "51"))
+	h.nextEvent()
+	stop()
+
+	if len(h.lists) != 0 {
+		t.Error("events were listed although a resourceVersion was saved")
+	}
+	if len(h.ingested) != 0 {
+		t.Errorf("%d unexpected events ingested, deletions included", len(h.ingested))
+	}
+	if got := h.savedVersion(); got != "51" {
+		t.Errorf("saved resourceVersion %q, want 51", got)
+	}
+
+	// The next run picks up from the saved version
+	stop = h.start()
+	h.nextWatch("51")
+	stop()
+}
+
+func TestKubeEventSourceStartsFromNowWithoutState(t *testing.T) {
+	h := newKubeHarness(t, "core/v1", "", "1000")
+	stop := h.start()
+	w := h.nextWatch("1000")
+	w.Add(coreTestEvent("new", "1001"))
+	if got := h.nextEvent().ApiVersion; got != "v1" {
+		t.Errorf("ingested a %q event from core/v1", got)
+	}
+	stop()
+	if got := h.savedVersion(); got != "1001" {
+		t.Errorf("saved resourceVersion %q, want 1001", got)
+	}
+}
+
+func TestKubeEventSourceRecoversFromExpiredVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		api    string
+		expire func(h *kubeHarness)
+	}{
+		{
+			name: "410 Expired error event",
+			api:  "core/v1",
+			expire: func(h *kubeHarness) {
+				w := h.nextWatch("42")
+				w.Error(&metav1.Status{
+					Status:  metav1.StatusFailure,
+					Code:    410,
+					Reason:  metav1.StatusReasonExpired,
+					Message: "too old resource version: 42 (900)",
+				})
+			},
+		},
+		{
+			name: "410 Gone error event",
+			api:  "events.k8s.io/v1",
+			expire: func(h *kubeHarness) {
+				w := h.nextWatch("42")
+				w.Add(eventsTestEvent("before", "43"))
+				h.nextEvent()
+				w.Error(&apierrors.NewGone("resource version gone").ErrStatus)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newKubeHarness(t, tt.api, "42", "1000")
+			stop := h.start()
+			defer stop()
+
+			tt.expire(h)
+			select {
+			case <-h.lists:
+			case <-time.After(5 * time.Second):
+				t.Fatal("events were not listed again after the watch expired")
+			}
+			w := h.nextWatch("1000")
+
+			var obj runtime.Object = coreTestEvent("after", "1001")
+			if tt.api != "core/v1" {
+				obj = eventsTestEvent("after", "1001")
+			}
+			w.Add(obj)
+			if got := h.nextEvent().Metadata.Name; got != "after" {
+				t.Errorf("ingested %q after relisting", got)
+			}
+		})
+	}
+}
+
+func TestKubeEventSourceRedeliversFailedIngest(t *testing.T) {
+	h := newKubeHarness(t, "core/v1", "42", "1000")
+	h.failIngest = 1
+	stop := h.start()
+
+	w := h.nextWatch("42")
+	w.Add(coreTestEvent("flaky", "43"))
+
+	// The failed event is not skipped: the watch restarts from before it
+	w = h.nextWatch("42")
+	w.Add(coreTestEvent("flaky", "43"))
+	if got := h.nextEvent().Metadata.Name; got != "flaky" {
+		t.Errorf("ingested %q", got)
+	}
+	stop()
+	if got := h.savedVersion(); got != "43" {
+		t.Errorf("saved resourceVersion %q, want 43", got)
+	}
+}
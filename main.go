@@ -2,13 +2,19 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"go.opentelemetry.io/otel"
@@ -26,28 +32,40 @@ type Event struct {
 	Metadata struct {
 		Name              string            `json:"name"`
 		Labels            map[string]string `json:"labels,omitempty"`
-		DeletionTimestamp string            `json:"deletionTimestamp,omitempty"`
-		Reason            string            `json:"reason,omitempty"`
-		Message           string            `json:"message,omitempty"`
+		DeletionTimestamp string            `json:"deletionTimestamp,omitempty" opensearch:"date"`
+		Reason            string            `json:"reason,omitempty" opensearch:"text,keyword"`
+		Message           string            `json:"message,omitempty" opensearch:"text,keyword"`
 	} `json:"metadata"`
 	InvolvedObject struct {
 		Kind string `json:"kind"`
 		Name string `json:"name"`
 		UUID string `json:"uuid,omitempty"`
 	} `json:"involvedObject"`
-	Action        string `json:"action"`
-	EventTime     string `json:"eventTime"`
-	Count         int    `json:"count,omitempty"`
-	Type          string `json:"type"`
-	CurrentStatus string `json:"currentStatus"`
-	CorrelationID string `json:"correlationId"`
-	UserID        string `json:"userId,omitempty"`
-	OrgUUID       string `json:"orgUuId,omitempty"`
+	Action        string       `json:"action"`
+	EventTime     string       `json:"eventTime" opensearch:"date"`
+	Count         int          `json:"count,omitempty"`
+	Series        *EventSeries `json:"series,omitempty"`
+	Type          string       `json:"type"`
+	CurrentStatus string       `json:"currentStatus"`
+	CorrelationID string       `json:"correlationId"`
+	UserID        string       `json:"userId,omitempty"`
+	OrgUUID       string       `json:"orgUuId,omitempty"`
+
+	// Attributes keeps what a source reports beyond the fields above, such
+	// as CloudEvents extensions or OpenTelemetry log attributes
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// EventSeries describes an event that keeps recurring, as Kubernetes
+// reports it
+type EventSeries struct {
+	Count            int    `json:"count"`
+	LastObservedTime string `json:"lastObservedTime" opensearch:"date"`
 }
 
 // MetricData represents the structure for storing metrics
 type MetricData struct {
-	Timestamp   string            `json:"@timestamp"`
+	Timestamp   string            `json:"@timestamp" opensearch:"date"`
 	MetricName  string            `json:"metric_name"`
 	Duration    float64           `json:"duration,omitempty"`
 	EventName   string            `json:"event_name"`
@@ -64,141 +82,63 @@ type MetricData struct {
 
 // Server structure to hold dependencies
 type Server struct {
+	config            Config
 	client            *opensearch.Client
+	indices           indexNames
+	managed           []managedIndex
+	pending           sync.WaitGroup
+	sources           sync.WaitGroup
+	ready             atomic.Bool
+	buffersMu         sync.Mutex
+	buffers           []bufferGauge
+	bulk              *bulkIndexer
+	statuses          *statusStore
+	spool             *spool
+	retry             *retryPolicy
+	deadLetters       deadLetterSink
+	admission         *admission
+	grpc              *grpcService
 	eventCounter      metric.Int64Counter
 	durationHistogram metric.Float64Histogram 
 	statusCounter     metric.Int64Counter
 	errorRateCounter  metric.Int64Counter
 }
 
-// Function to create metrics index mapping
-func createMetricsIndexMapping(ctx context.Context, client *opensearch.Client) error {
-	mapping := `{
-		"mappings": {
-			"properties": {
-				"apiVersion": { 
-					"type": "keyword"
-				},
-				"kind": { 
-					"type": "keyword"
-				},
-				"metadata": {
-					"properties": {
-						"name": { "type": "keyword" },
-						"labels": { "type": "object" },
-						"deletionTimestamp": { 
-							"type": "date" 
-						},
-						"reason": { 
-							"type": "text",
-							"fields": {
-								"keyword": {
-									"type": "keyword"
-								}
-							}
-						},
-						"message": { 
-							"type": "text",
-							"fields": {
-								"keyword": {
-									"type": "keyword"
-								}
-							}
-						}
-					}
-				},
-				"involvedObject": {
-					"properties": {
-						"kind": { "type": "keyword" },
-						"name": { "type": "keyword" },
-						"uuid": { "type": "keyword" }
-					}
-				},
-				"action": { "type": "keyword" },
-				"eventTime": { "type": "date" },
-				"count": { 
-					"type": "integer",
-					"fields": {
-						"keyword": {
-							"type": "keyword"
-						}
-					}
-				},
-				"type": { "type": "keyword" },
-				"currentStatus": { "type": "keyword" },
-				"correlationId": { "type": "keyword" },
-				"userId": { "type": "keyword" },
-				"orgUuId": { "type": "keyword" },
-
-				"@timestamp": { "type": "date" },
-				"metric_name": { "type": "keyword" },
-				"duration": { 
-					"type": "float",
-					"fields": {
-						"keyword": {
-							"type": "keyword"
-						}
-					}
-				},
-				"event_name": { "type": "keyword" },
-				"event_type": { "type": "keyword" },
-				"object_kind": { "type": "keyword" },
-				"status": { "type": "keyword" },
-				"labels": { "type": "object" },
-				"error_rate": { 
-					"type": "integer",
-					"fields": {
-						"keyword": {
-							"type": "keyword"
-						}
-					}
-				},
-				"is_warning": { "type": "boolean" },
-				"event_count": { 
-					"type": "integer",
-					"fields": {
-						"keyword": {
-							"type": "keyword"
-						}
-					}
-				}
-			}
-		}
-	}`
+// Function to register a mapping as an index template covering every
+// index name the template can render
+func putIndexTemplate(ctx context.Context, client *opensearch.Client, m managedIndex) error {
+	templateName := m.index.templateName(m.name)
+	patterns, err := json.Marshal([]string{m.index.pattern()})
+	if err != nil {
+		return fmt.Errorf("error encoding index patterns: %w", err)
+	}
+	// The mapping document is already shaped like the template body
+	body := fmt.Sprintf(`{"index_patterns": %s, "version": %d, "template": %s}`, patterns, m.mapping.version, m.mapping.body)
 
-	req := opensearchapi.IndicesCreateRequest{
-		Index: "metrics2",
-		Body:  strings.NewReader(mapping),
+	req := opensearchapi.IndicesPutIndexTemplateRequest{
+		Name: templateName,
+		Body: strings.NewReader(body),
 	}
 
 	res, err := req.Do(ctx, client)
 	if err != nil {
-		return fmt.Errorf("error creating metrics index: %w", err)
+		return fmt.Errorf("error creating index template %s: %w", templateName, err)
 	}
 	defer res.Body.Close()
 
 	if res.IsError() {
-		if res.StatusCode != 400 { // 400 means index already exists
-			respBody, _ := io.ReadAll(res.Body)
-			return fmt.Errorf("error creating metrics index: Status: %d, Response: %s", res.StatusCode, string(respBody))
-		}
+		respBody, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("error creating index template %s: Status: %d, Response: %s", templateName, res.StatusCode, string(respBody))
 	}
 	return nil
 }
 
 // Function to track event duration
-func (s *Server) trackEventDuration(ctx context.Context, event Event) error {
-
-	if event.EventTime == "" {
- 		event.EventTime = time.Now().Format(time.RFC3339)
- 	}
-
+func (s *Server) trackEventDuration(ctx context.Context, event Event) (MetricData, error) {
 	eventTime, err := time.Parse(time.RFC3339, event.EventTime)
 	if err != nil {
-        fmt.Println("Error parsing event time:", err)
-        return err
-    }
-	// Send duration metric to OpenSearch
+		return MetricData{}, fmt.Errorf("error parsing event time: %w", err)
+	}
 	metricData := MetricData{
 		Timestamp:  time.Now().Format(time.RFC3339),
 		MetricName: "event_duration",
@@ -209,15 +149,12 @@ func (s *Server) trackEventDuration(ctx context.Context, event Event) error {
 		Duration:   time.Since(eventTime).Seconds(),
 	}
 
-	if err := sendMetricToOpenSearch(ctx, s.client, metricData); err != nil {
-		return fmt.Errorf("failed to send duration metric to OpenSearch: %w", err)
-	}
 	log.Printf("Tracked duration for event: %s, type: %s", event.Metadata.Name, event.Action)
-	return nil
+	return metricData, nil
 }
 
 // Function to track event status distribution
-func (s *Server) trackEventStatus(ctx context.Context, event Event) error {
+func (s *Server) trackEventStatus(ctx context.Context, event Event) MetricData {
 	attrs := []attribute.KeyValue{
 		attribute.String("status", event.Type),
 		attribute.String("event_type", event.Action),
@@ -236,50 +173,41 @@ func (s *Server) trackEventStatus(ctx context.Context, event Event) error {
 		Type:       event.Type,
 	}
 
-	if err := sendMetricToOpenSearch(ctx, s.client, metricData); err != nil {
-		return fmt.Errorf("failed to send status metric to OpenSearch: %w", err)
-	}
-
 	log.Printf("Tracked status distribution %s for event: %s, type: %s", event.Metadata.Name, event.CurrentStatus, event.Action)
-	return nil
+	return metricData
 }
 
-// Function to track error rate
-func (s *Server) trackErrorRate(ctx context.Context, event Event) error {
-	if event.Type == "Warning" {
-		attrs := []attribute.KeyValue{
-			attribute.String("reason", event.Metadata.Reason),
-			attribute.String("involved_object_kind", event.InvolvedObject.Kind),
-		}
-		var errorRateCounterValue int
-		s.errorRateCounter.Add(ctx, 1, metric.WithAttributes(attrs...))
-		errorRateCounterValue += 1
-		// Send error rate metric to OpenSearch
-		metricData := MetricData{
-			Timestamp:   time.Now().Format(time.RFC3339),
-			MetricName:  "error_rate",
-			EventName:  event.Metadata.Name,
-			EventType:  event.Type,
-			ObjectKind: event.InvolvedObject.Kind,
-			Labels:     event.Metadata.Labels,
-			ErrorRate: errorRateCounterValue, // Increment the error rate counter by 1
-			IsWarning:  true,
-		}
+// Function to track error rate; only Warning events produce a metric
+func (s *Server) trackErrorRate(ctx context.Context, event Event) (MetricData, bool) {
+	if event.Type != "Warning" {
+		return MetricData{}, false
+	}
 
-		if err := sendMetricToOpenSearch(ctx, s.client, metricData); err != nil {
-			return fmt.Errorf("failed to send error rate metric to OpenSearch: %w", err)
-		}
+	attrs := []attribute.KeyValue{
+		attribute.String("reason", event.Metadata.Reason),
+		attribute.String("involved_object_kind", event.InvolvedObject.Kind),
+	}
+	s.errorRateCounter.Add(ctx, 1, metric.WithAttributes(attrs...))
 
-		log.Printf("Tracked warning event: %s, reason: %s, object kind: %s",
-			event.Metadata.Name, event.Metadata.Reason, event.InvolvedObject.Kind)
+	metricData := MetricData{
+		Timestamp:  time.Now().Format(time.RFC3339),
+		MetricName: "error_rate",
+		EventName:  event.Metadata.Name,
+		EventType:  event.Type,
+		ObjectKind: event.InvolvedObject.Kind,
+		Labels:     event.Metadata.Labels,
+		ErrorRate:  1,
+		IsWarning:  true,
 	}
 
-	return nil
+	log.Printf("Tracked warning event: %s, reason: %s, object kind: %s",
+		event.Metadata.Name, event.Metadata.Reason, event.InvolvedObject.Kind)
+	return metricData, true
 }
 
-var eventCounterValue int
+var eventCounterValue atomic.Int64
 // Function to track event frequency
-func trackEventFrequency(ctx context.Context, event Event, eventCounter metric.Int64Counter, client *opensearch.Client) error {
+func trackEventFrequency(ctx context.Context, event Event, eventCounter metric.Int64Counter) MetricData {
 	attrs := []attribute.KeyValue{
 		//attribute.String("event_name", event.Metadata.Name),
 		attribute.String("event_type", event.Action),
@@ -287,12 +215,7 @@ func trackEventFrequency(ctx context.Context, event Event, eventCounter metric.I
 	}
 
 	eventCounter.Add(ctx, 1, metric.WithAttributes(attrs...))
-	eventCounterValue += 1
-
-
-	if event.EventTime == "" {
- 		event.EventTime = time.Now().Format(time.RFC3339)
- 	}
+	count := eventCounterValue.Add(1)
 
 	// Parse event time
     eventTime, err := time.Parse(time.RFC3339, event.EventTime)
@@ -305,8 +228,6 @@ func trackEventFrequency(ctx context.Context, event Event, eventCounter metric.I
     // Calculate duration
     duration := time.Since(eventTime).Seconds()
 
- 
-
 	metricData := MetricData{
 		Timestamp:  time.Now().Format(time.RFC3339),
 		MetricName: "event_frequency",
@@ -314,140 +235,164 @@ func trackEventFrequency(ctx context.Context, event Event, eventCounter metric.I
 		EventType:  event.Action,
 		ObjectKind: event.InvolvedObject.Kind,
 		Labels:     event.Metadata.Labels,
-		EventCount: eventCounterValue, // Increment the event counter by 1
+		EventCount: int(count),
 		Duration:  duration,
 		IsWarning:  false,
         Type:       event.Type,
 		Event:      event,
 	}
 
-	if err := sendMetricToOpenSearch(ctx, client, metricData); err != nil {
-		return fmt.Errorf("failed to send metric to OpenSearch: %w", err)
-	}
-
 	log.Printf("Tracked event frequency for event: %s, type: %s", event.Metadata.Name, event.Action)
-
-	return nil
+	return metricData
 }
 
-// Function to send metrics to OpenSearch
-func sendMetricToOpenSearch(ctx context.Context, client *opensearch.Client, metric MetricData) error {
-
-	var event Event
-
-	if event.EventTime == "" {
- 		event.EventTime = time.Now().Format(time.RFC3339)
- 	}
-
-	jsonData, err := json.Marshal(metric)
-	if err != nil {
-		return fmt.Errorf("error marshaling metric data: %w", err)
-	}
+// outgoingDoc is a document produced for one event, before it is queued.
+// An empty id lets OpenSearch generate one.
+type outgoingDoc struct {
+	kind     string
+	index    string
+	doc      any
+	required bool
+	id       string
+}
 
-	req := opensearchapi.IndexRequest{
-		Index: "metrics2",
-		Body:  strings.NewReader(string(jsonData)),
+// Handler for processing incoming events
+func (s *Server) handleEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	res, err := req.Do(ctx, client)
+	data, err := s.readBody(w, r, s.config.Body.MaxEventBytes)
 	if err != nil {
-		return fmt.Errorf("error sending metric to OpenSearch: %w", err)
+		writeBodyError(w, err)
+		return
 	}
-	defer res.Body.Close()
-
-	if res.IsError() {
-		respBody, err := io.ReadAll(res.Body)
+	var event Event
+	header := s.idempotencyHeader(r)
+	if isCloudEvent(r) {
+		var key string
+		event, key, err = s.decodeCloudEvent(r, data)
+		if errors.Is(err, errUnsupportedDataType) {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
 		if err != nil {
-			return fmt.Errorf("error reading error response: %w", err)
+			http.Error(w, "Invalid CloudEvent: "+err.Error(), http.StatusBadRequest)
+			return
 		}
-		return fmt.Errorf("error indexing metric: Status: %d, Response: %s", res.StatusCode, string(respBody))
-	}
-
-	return nil
-}
-
-
-// Function to send event to OpenSearch
-func sendEvent(ctx context.Context, event Event, client *opensearch.Client) error {
-	// Set event time if not provided
-	if event.EventTime == "" {
-		event.EventTime = time.Now().Format(time.RFC3339)
+		// source and id identify a CloudEvent, so they serve as the
+		// producer's key unless it sent one explicitly
+		if header == "" && s.config.Idempotency.Header != "" {
+			header = key
+		}
+	} else if err := s.decodeEvent(data, &event); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	} else if err := validateEvent(event); err != nil {
+		http.Error(w, "Invalid event: "+err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	jsonData, err := json.Marshal(event)
+	wait, err := s.syncRequested(r)
 	if err != nil {
-		return fmt.Errorf("error marshaling event data: %w", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	req := opensearchapi.IndexRequest{
-		Index: "events",
-		Body:  strings.NewReader(string(jsonData)),
+	warning := event.Type == "Warning"
+	ctx, done, refused := s.accept(r.Context(), warning)
+	if refused != nil {
+		refuse(w, refused)
+		return
 	}
+	defer done()
 
-	res, err := req.Do(ctx, client)
+	ing, err := s.newIngestion(ctx, newRequestMeta(r), header, event)
 	if err != nil {
-		return fmt.Errorf("error sending event to OpenSearch: %w", err)
+		log.Printf("Failed to prepare event: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
-	defer res.Body.Close()
 
-	if res.IsError() {
-		respBody, err := io.ReadAll(res.Body)
-		if err != nil {
-			return fmt.Errorf("error reading error response: %w", err)
+	if err := s.submit(ctx, r.Context(), []*ingestion{ing}, wait)[0]; err != nil {
+		if reason, ok := s.shedReason(err); ok {
+			s.shed(w, r, reason, warning)
+			return
 		}
-		return fmt.Errorf("error indexing document: Status: %d, Response: %s", res.StatusCode, string(respBody))
-	}
-
-	return nil
-}
-
-// Handler for processing incoming events
-func (s *Server) handleEvent(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		log.Printf("Failed to spool event: %v", err)
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Failed to spool event", http.StatusServiceUnavailable)
 		return
 	}
 
-	var event Event
-	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !wait {
+		writeAccepted(w, ing.id)
 		return
 	}
+	status, ok := s.statuses.wait(r.Context(), ing.id)
+	writeProcessed(w, ing.id, status, ok)
+}
 
-	ctx := r.Context()
-	if err := trackEventFrequency(ctx, event, s.eventCounter, s.client); err != nil {
-		log.Printf("Failed to track event frequency: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
+// Function to acknowledge an event that was queued for indexing
+func writeAccepted(w http.ResponseWriter, id string) {
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "accepted",
+		"message": "Event queued for indexing",
+		"id":      id,
+	})
+}
 
-	// Track event duration (new functionality)
-	if err := s.trackEventDuration(ctx, event); err != nil {
-		log.Printf("Failed to track event duration: %v", err)
-		// Continue processing even if duration tracking fails
-	}
+// Function to answer a request that waited for its writes, listing which
+// documents were persisted. A duplicate is an event whose documents had
+// all been written before.
+func writeProcessed(w http.ResponseWriter, id string, status ingestStatus, ok bool) {
+	code, result, message := http.StatusOK, "success", "Event processed successfully"
+	switch {
+	case !ok:
+		code, result, message = http.StatusInternalServerError, "failed", "Delivery status expired"
+	case status.State == stateDuplicate:
+		result, message = "duplicate", "Event was already processed"
+	case status.State != stateIndexed:
+		code, result, message = http.StatusInternalServerError, "failed", "Event was not stored"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":    result,
+		"message":   message,
+		"id":        id,
+		"documents": status.Documents,
+	})
+}
 
-	// Track event status distribution (new functionality)
-	if err := s.trackEventStatus(ctx, event); err != nil {
-		log.Printf("Failed to track event status: %v", err)
+// Function to run the enabled trackers and collect the documents to write
+// for an event. The event and its metrics are written as a unit: the event
+// is required, and metrics only stay if it is stored (see deliver).
+func (s *Server) eventDocuments(ctx context.Context, event Event, received time.Time) []outgoingDoc {
+	metricsIndex := s.indices.metrics.render(event, received)
+	var out []outgoingDoc
+	trackers := s.config.Trackers
+	if trackers.Frequency {
+		out = append(out, outgoingDoc{kind: "event frequency metric", index: metricsIndex, doc: trackEventFrequency(ctx, event, s.eventCounter)})
+	}
+	if trackers.Duration {
+		if m, err := s.trackEventDuration(ctx, event); err != nil {
+			log.Printf("Failed to track event duration: %v", err)
+		} else {
+			out = append(out, outgoingDoc{kind: "event duration metric", index: metricsIndex, doc: m})
+		}
 	}
-
-	// Track error rate (new)
-	if err := s.trackErrorRate(ctx, event); err != nil {
-		log.Printf("Failed to track error rate: %v", err)
+	if trackers.Status {
+		out = append(out, outgoingDoc{kind: "event status metric", index: metricsIndex, doc: s.trackEventStatus(ctx, event)})
 	}
-
-	if err := sendEvent(ctx, event, s.client); err != nil {
-		log.Printf("Failed to send event to OpenSearch: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+	if trackers.ErrorRate {
+		if m, ok := s.trackErrorRate(ctx, event); ok {
+			out = append(out, outgoingDoc{kind: "error rate metric", index: metricsIndex, doc: m})
+		}
 	}
-
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "success",
-		"message": "Event processed successfully",
-	})
+	return append(out, outgoingDoc{kind: "event", index: s.indices.events.render(event, received), doc: event, required: true})
 }
 
 // Health check handler
@@ -457,6 +402,15 @@ func (s *Server) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.ready.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "starting",
+			"message": "Waiting for OpenSearch bootstrap",
+		})
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
 		"status":  "healthy",
@@ -465,29 +419,113 @@ func (s *Server) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	ctx := context.Background()
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "serve":
+		runServe(args)
+	case "init-indices":
+		os.Exit(runInitIndices(args))
+	case "check":
+		os.Exit(runCheck(args))
+	case "replay-dlq":
+		os.Exit(runReplayDLQ(args))
+	case "version":
+		printVersion(os.Stdout)
+	case "help", "-h", "--help":
+		printUsage(os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", cmd)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+}
 
-	// Initialize OpenSearch client
+// Function to load and validate the configuration for a command. It
+// returns false when the command should exit without doing anything else,
+// e.g. after --help or --print-config.
+func loadValidConfig(cmd string, args []string, commandFlags func(*flag.FlagSet)) (Config, bool) {
+	config, printConfig, err := loadConfig(cmd, args, commandFlags)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return config, false
+		}
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	if err := config.validate(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	if printConfig {
+		out, err := config.redacted()
+		if err != nil {
+			log.Fatalf("Error rendering configuration: %v", err)
+		}
+		os.Stdout.Write(out)
+		return config, false
+	}
+	return config, true
+}
+
+// Function to create the OpenSearch client described by the config
+func newOpenSearchClient(config Config) (*opensearch.Client, error) {
+	transport, err := newOpenSearchTransport(config.OpenSearch.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring TLS: %w", err)
+	}
+	// Retries are left to the retry policy, which backs off, honours
+	// Retry-After and counts them; the client's own would multiply them
 	cfg := opensearch.Config{
-		Addresses: []string{"https://localhost:9200"},
-		Username:  "admin",
-		Password:  "Wasuki@1999",
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		},
+		Addresses:           config.OpenSearch.Addresses,
+		Transport:           transport,
+		CompressRequestBody: config.OpenSearch.CompressRequests,
+		DisableRetry:        true,
+	}
+	if config.OpenSearch.AWS.SigV4 {
+		// Amazon OpenSearch Service authenticates with SigV4 instead of
+		// basic auth, so the credential provider is not used
+		cfg.Signer = newSigV4Signer(config.OpenSearch.AWS)
+	} else {
+		credentials, err := newCredentialProvider(config.OpenSearch)
+		if err != nil {
+			return nil, fmt.Errorf("error loading OpenSearch credentials: %w", err)
+		}
+		cfg.Transport = &authTransport{base: transport, provider: credentials}
 	}
 
 	client, err := opensearch.NewClient(cfg)
 	if err != nil {
-		log.Fatalf("Error creating the client: %v", err)
+		return nil, fmt.Errorf("error creating the client: %w", err)
+	}
+	return client, nil
+}
+
+// Function to run the ingest server (the serve command)
+func runServe(args []string) {
+	ctx := context.Background()
+
+	config, ok := loadValidConfig("serve", args, nil)
+	if !ok {
+		return
+	}
+
+	// Initialize OpenSearch client
+	client, err := newOpenSearchClient(config)
+	if err != nil {
+		log.Fatalf("%v", err)
 	}
 
-	// Create metrics index mapping
-	if err := createMetricsIndexMapping(ctx, client); err != nil {
-		log.Printf("Warning: Failed to create metrics index mapping: %v", err)
+	indices, err := newIndexNames(config.Indices)
+	if err != nil {
+		log.Fatalf("Invalid index names: %v", err)
+	}
+	managed, err := newManagedIndices(indices, config.Indices.FieldOverrides, config.DeadLetter.Target == "index")
+	if err != nil {
+		log.Fatalf("%v", err)
 	}
+
 	// Initialize metrics
 	// meter := otel.GetMeterProvider().Meter("event-metrics")
 	meter := otel.GetMeterProvider().Meter("event-frequency")
@@ -529,245 +567,109 @@ func main() {
 		log.Fatalf("Failed to create error rate counter: %v", err)
 	}
 
+	retry, err := newRetryPolicy(config.Retry, meter)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
 
 	// Initialize server
 	server := &Server{
+		config:            config,
 		client:            client,
+		indices:           indices,
+		managed:           managed,
 		eventCounter:      eventCounter,
 		durationHistogram: durationHistogram,
 		statusCounter:     statusCounter,
 		errorRateCounter:  errorRateCounter,
+		bulk:              newBulkIndexer(client, config.Bulk, retry),
+		retry:             retry,
+		statuses:          newStatusStore(config.Ingest),
+	}
+	server.admission, err = newAdmission(config.Backpressure, meter)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	server.bulk.start()
+	server.deadLetters, err = newDeadLetterSink(config, client, indices, retry)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	server.registerBuffer(bufferGauge{
+		name:      "bulk",
+		depth:     server.bulk.depth,
+		highWater: max(config.Bulk.QueueSize*9/10, 1),
+	})
+	if config.Spool.Enabled {
+		server.spool, err = openSpool(config.Spool)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		server.registerBuffer(bufferGauge{
+			name:      "spool",
+			depth:     server.spool.pending,
+			highWater: int(config.Spool.MaxBytes * 9 / 10),
+			unit:      "bytes",
+		})
+	}
+
+	// Built-in event sources start once the indices are in place
+	var sources []func(context.Context)
+	if config.Kubernetes.Enabled {
+		kube, err := newKubeClient(config.Kubernetes)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		sources = append(sources, newKubeEventSource(kube, config.Kubernetes, retry, server.ingestEvent).run)
+	}
+	if config.Syslog.enabled() {
+		syslog, err := newSyslogSource(config.Syslog, meter, server.ingestEvent)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		sources = append(sources, syslog.run)
 	}
 
-	// Set up HTTP routes
-	http.HandleFunc("/event", server.handleEvent)
-	http.HandleFunc("/health", server.handleHealthCheck)
+	if config.GRPC.ListenAddress != "" {
+		server.grpc = newGRPCService(server)
+	}
 
-	// Start the server
-	port := ":8080"
-	log.Printf("Starting server on port %s", port)
-	if err := http.ListenAndServe(port, nil); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	// Set up HTTP routes
+	mux := http.NewServeMux()
+	mux.HandleFunc("/event", server.handleEvent)
+	mux.HandleFunc("/event/status/", server.handleEventStatus)
+	mux.HandleFunc("/events", server.handleEvents)
+	mux.HandleFunc("/v1/logs", server.handleOTLPLogs)
+	mux.HandleFunc("/health", server.handleHealthCheck)
+	mux.HandleFunc("/livez", server.handleLivez)
+	mux.HandleFunc("/readyz", server.handleReadyz)
+	mux.HandleFunc("/healthz", server.handleReadyz)
+
+	// Start the server and drain it on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Bootstrap in the background so probes can report not-ready meanwhile
+	server.sources.Add(len(sources))
+	go func() {
+		if err := server.bootstrap(ctx); err != nil {
+			log.Printf("Bootstrap failed: %v", err)
+			server.sources.Add(-len(sources))
+			return
+		}
+		for _, run := range sources {
+			go func() {
+				defer server.sources.Done()
+				run(ctx)
+			}()
+		}
+		if server.spool != nil {
+			server.shipSpool()
+		}
+	}()
+	if err := server.serve(ctx, mux); err != nil {
+		log.Printf("Shutdown incomplete: %v", err)
+		os.Exit(1)
 	}
 }
-
-
-// package main
-
-// import (
-// 	"context"
-// 	"crypto/tls"
-// 	"encoding/json"
-// 	"fmt"
-// 	"io"
-// 	"log"
-// 	"net/http"
-// 	"strings"
-// 	"time"
-
-// 	"github.com/opensearch-project/opensearch-go"
-// 	"github.com/opensearch-project/opensearch-go/opensearchapi"
-// )
-
-// // Event structure definition
-// type Event struct {
-// 	APIVersion string `json:"apiVersion,omitempty"`
-// 	Kind       string `json:"kind,omitempty"`
-// 	Metadata   struct {
-// 		Name              string            `json:"name"`
-// 		Labels            map[string]string `json:"labels,omitempty"`
-// 		DeletionTimestamp string            `json:"deletionTimestamp,omitempty"`
-// 		Reason            string            `json:"reason,omitempty"`
-// 		Message           string            `json:"message,omitempty"`
-// 	} `json:"metadata"`
-// 	InvolvedObject struct {
-// 		Kind string `json:"kind"`
-// 		Name string `json:"name"`
-// 		UUID string `json:"uuid,omitempty"`
-// 	} `json:"involvedObject"`
-// 	Action        string `json:"action"`
-// 	EventTime     string `json:"eventTime"`
-// 	Count         int    `json:"count,omitempty"`
-// 	Type          string `json:"type"`
-// 	CurrentStatus string `json:"currentStatus"`
-// 	CorrelationID string `json:"correlationId"`
-// 	UserID        string `json:"userId,omitempty"`
-// 	OrgUUID       string `json:"orgUuId,omitempty"`
-// }
-
-// // Server structure to hold dependencies
-// type Server struct {
-// 	client *opensearch.Client
-// }
-
-// // Function to create events index mapping
-// func createEventsIndexMapping(ctx context.Context, client *opensearch.Client) error {
-// 	mapping := `{
-// 		"mappings": {
-// 			"properties": {
-// 				"apiVersion": { "type": "keyword" },
-// 				"kind": { "type": "keyword" },
-// 				"metadata": {
-// 					"properties": {
-// 						"name": { "type": "keyword" },
-// 						"labels": { "type": "object" },
-// 						"deletionTimestamp": { "type": "date" },
-// 						"reason": { "type": "text" },
-// 						"message": { "type": "text" }
-// 					}
-// 				},
-// 				"involvedObject": {
-// 					"properties": {
-// 						"kind": { "type": "keyword" },
-// 						"name": { "type": "keyword" },
-// 						"uuid": { "type": "keyword" }
-// 					}
-// 				},
-// 				"action": { "type": "keyword" },
-// 				"eventTime": { "type": "date" },
-// 				"count": { "type": "integer" },
-// 				"type": { "type": "keyword" },
-// 				"currentStatus": { "type": "keyword" },
-// 				"correlationId": { "type": "keyword" },
-// 				"userId": { "type": "keyword" },
-// 				"orgUuId": { "type": "keyword" }
-// 			}
-// 		}
-// 	}`
-
-// 	req := opensearchapi.IndicesCreateRequest{
-// 		Index: "events",
-// 		Body:  strings.NewReader(mapping),
-// 	}
-
-// 	res, err := req.Do(ctx, client)
-// 	if err != nil {
-// 		return fmt.Errorf("error creating events index: %w", err)
-// 	}
-// 	defer res.Body.Close()
-
-// 	if res.IsError() {
-// 		if res.StatusCode != 400 { // 400 means index already exists
-// 			respBody, _ := io.ReadAll(res.Body)
-// 			return fmt.Errorf("error creating events index: Status: %d, Response: %s", res.StatusCode, string(respBody))
-// 		}
-// 	}
-// 	return nil
-// }
-
-// // Function to send event to OpenSearch
-// func sendEventToOpenSearch(ctx context.Context, client *opensearch.Client, event Event) error {
-// 	// Set event time if not provided
-// 	if event.EventTime == "" {
-// 		event.EventTime = time.Now().Format(time.RFC3339)
-// 	}
-
-// 	jsonData, err := json.Marshal(event)
-// 	if err != nil {
-// 		return fmt.Errorf("error marshaling event data: %w", err)
-// 	}
-
-// 	req := opensearchapi.IndexRequest{
-// 		Index: "events",
-// 		Body:  strings.NewReader(string(jsonData)),
-// 	}
-
-// 	res, err := req.Do(ctx, client)
-// 	if err != nil {
-// 		return fmt.Errorf("error sending event to OpenSearch: %w", err)
-// 	}
-// 	defer res.Body.Close()
-
-// 	if res.IsError() {
-// 		respBody, err := io.ReadAll(res.Body)
-// 		if err != nil {
-// 			return fmt.Errorf("error reading error response: %w", err)
-// 		}
-// 		return fmt.Errorf("error indexing event: Status: %d, Response: %s", res.StatusCode, string(respBody))
-// 	}
-
-// 	return nil
-// }
-
-// // Handler for processing incoming events
-// func (s *Server) handleEvent(w http.ResponseWriter, r *http.Request) {
-// 	if r.Method != http.MethodPost {
-// 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-// 		return
-// 	}
-
-// 	var event Event
-// 	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-// 		http.Error(w, "Invalid request body", http.StatusBadRequest)
-// 		return
-// 	}
-
-// 	ctx := r.Context()
-// 	if err := sendEventToOpenSearch(ctx, s.client, event); err != nil {
-// 		log.Printf("Failed to send event to OpenSearch: %v", err)
-// 		http.Error(w, "Internal server error", http.StatusInternalServerError)
-// 		return
-// 	}
-
-// 	w.WriteHeader(http.StatusOK)
-// 	json.NewEncoder(w).Encode(map[string]string{
-// 		"status":  "success",
-// 		"message": "Event processed successfully",
-// 	})
-// }
-
-// // Health check handler
-// func (s *Server) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
-// 	if r.Method != http.MethodGet {
-// 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-// 		return
-// 	}
-
-// 	w.WriteHeader(http.StatusOK)
-// 	json.NewEncoder(w).Encode(map[string]string{
-// 		"status":  "healthy",
-// 		"message": "Service is running",
-// 	})
-// }
-
-// func main() {
-// 	ctx := context.Background()
-
-// 	// Initialize OpenSearch client
-// 	cfg := opensearch.Config{
-// 		Addresses: []string{"https://localhost:9200"},
-// 		Username:  "admin",
-// 		Password:  "Wasuki@1999",
-// 		Transport: &http.Transport{
-// 			TLSClientConfig: &tls.Config{
-// 				InsecureSkipVerify: true,
-// 			},
-// 		},
-// 	}
-
-// 	client, err := opensearch.NewClient(cfg)
-// 	if err != nil {
-// 		log.Fatalf("Error creating the client: %v", err)
-// 	}
-
-// 	// Create events index mapping
-// 	if err := createEventsIndexMapping(ctx, client); err != nil {
-// 		log.Printf("Warning: Failed to create events index mapping: %v", err)
-// 	}
-
-// 	// Initialize server
-// 	server := &Server{
-// 		client: client,
-// 	}
-
-// 	// Set up HTTP routes
-// 	http.HandleFunc("/event", server.handleEvent)
-// 	http.HandleFunc("/health", server.handleHealthCheck)
-
-// 	// Start the server
-// 	port := ":8080"
-// 	log.Printf("Starting server on port %s", port)
-// 	if err := http.ListenAndServe(port, nil); err != nil {
-// 		log.Fatalf("Failed to start server: %v", err)
-// 	}
-// }
\ No newline at end of file
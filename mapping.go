@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+// mappingVersion is the schema version stamped into every generated
+// mapping. Bump it whenever the Event or MetricData structs change in a
+// way that needs existing indices upgraded.
+const mappingVersion = 4
+
+// fieldOverrides replaces the generated mapping of individual fields,
+// keyed by dotted JSON path, e.g. {"metadata.labels": {"type": "flattened"}}
+type fieldOverrides map[string]map[string]any
+
+// indexMapping is a generated mapping document ready to send to OpenSearch
+type indexMapping struct {
+	version  int
+	checksum string
+	body     string
+}
+
+// mappingMeta is the _meta block stored alongside a generated mapping
+type mappingMeta struct {
+	Version   int    `json:"version"`
+	Checksum  string `json:"checksum"`
+	ManagedBy string `json:"managed_by"`
+}
+
+// managedIndex is an index, or templated family of indices, whose mapping
+// this service owns
+type managedIndex struct {
+	name    string
+	index   *indexTemplate
+	mapping indexMapping
+}
+
+// Function to build the managed indices from the index templates and the
+// configured field overrides, including the dead-letter index when it is
+// in use
+func newManagedIndices(indices indexNames, overrides map[string]fieldOverrides, deadLetters bool) ([]managedIndex, error) {
+	events, err := generateMapping(reflect.TypeOf(Event{}), overrides["events"])
+	if err != nil {
+		return nil, fmt.Errorf("error generating events mapping: %w", err)
+	}
+	metrics, err := generateMapping(reflect.TypeOf(MetricData{}), overrides["metrics"])
+	if err != nil {
+		return nil, fmt.Errorf("error generating metrics mapping: %w", err)
+	}
+	managed := []managedIndex{
+		{name: "events", index: indices.events, mapping: events},
+		{name: "metrics", index: indices.metrics, mapping: metrics},
+	}
+
+	if deadLetters {
+		mapping, err := generateMapping(reflect.TypeOf(deadLetter{}), nil)
+		if err != nil {
+			return nil, fmt.Errorf("error generating dead-letter mapping: %w", err)
+		}
+		managed = append(managed, managedIndex{name: "dead-letters", index: indices.deadLetters, mapping: mapping})
+	}
+	return managed, nil
+}
+
+// Function to generate a mapping document from a struct's json tags. Field
+// types follow the Go type unless an `opensearch` tag or an override says
+// otherwise; `opensearch:"text,keyword"` adds a keyword sub-field and
+// `opensearch:"disabled"` keeps a field in _source without parsing it.
+func generateMapping(t reflect.Type, overrides fieldOverrides) (indexMapping, error) {
+	properties := generateProperties(t, "", overrides)
+
+	canonical, err := json.Marshal(properties)
+	if err != nil {
+		return indexMapping{}, err
+	}
+	sum := sha256.Sum256(canonical)
+	checksum := hex.EncodeToString(sum[:])[:16]
+
+	body, err := json.Marshal(map[string]any{
+		"mappings": map[string]any{
+			"_meta": mappingMeta{
+				Version:   mappingVersion,
+				Checksum:  checksum,
+				ManagedBy: "go-opensearch-logging",
+			},
+			"properties": properties,
+		},
+	})
+	if err != nil {
+		return indexMapping{}, err
+	}
+	return indexMapping{version: mappingVersion, checksum: checksum, body: string(body)}, nil
+}
+
+// Function to map each exported, JSON-visible field of a struct
+func generateProperties(t reflect.Type, prefix string, overrides fieldOverrides) map[string]any {
+	properties := make(map[string]any)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		path := prefix + name
+		if override, ok := overrides[path]; ok {
+			properties[name] = override
+			continue
+		}
+		properties[name] = fieldMapping(field, path, overrides)
+	}
+	return properties
+}
+
+// Function to choose the mapping of a single field
+func fieldMapping(field reflect.StructField, path string, overrides fieldOverrides) map[string]any {
+	switch field.Tag.Get("opensearch") {
+	case "":
+	case "disabled":
+		return map[string]any{"type": "object", "enabled": false}
+	case "text,keyword":
+		return map[string]any{
+			"type": "text",
+			"fields": map[string]any{
+				"keyword": map[string]any{"type": "keyword", "ignore_above": 256},
+			},
+		}
+	default:
+		return map[string]any{"type": field.Tag.Get("opensearch")}
+	}
+
+	t := field.Type
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return map[string]any{"properties": generateProperties(t, path+".", overrides)}
+	case reflect.Map:
+		return map[string]any{"type": "object"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int16, reflect.Int8:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "long"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "float"}
+	default:
+		return map[string]any{"type": "keyword"}
+	}
+}
+
+// Function to create a managed index, or register its index template when
+// the name varies per event, and upgrade any existing indices whose
+// mapping is older than the generated one
+func installIndex(ctx context.Context, client *opensearch.Client, m managedIndex) error {
+	if err := ensureIndex(ctx, client, m); err != nil {
+		return err
+	}
+	return upgradeIndexMapping(ctx, client, m)
+}
+
+// Function to find the existing indices with an outdated mapping and apply
+// the current one. OpenSearch only accepts additive changes here; a
+// conflicting field type is reported as an error.
+func upgradeIndexMapping(ctx context.Context, client *opensearch.Client, m managedIndex) error {
+	allowNoIndices := true
+	res, err := opensearchapi.IndicesGetMappingRequest{
+		Index:          []string{m.index.pattern()},
+		AllowNoIndices: &allowNoIndices,
+	}.Do(ctx, client)
+	if err != nil {
+		return fmt.Errorf("error fetching %s mappings: %w", m.name, err)
+	}
+	defer res.Body.Close()
+	respBody, _ := io.ReadAll(res.Body)
+	if res.IsError() {
+		return fmt.Errorf("error fetching %s mappings: Status: %d, Response: %s", m.name, res.StatusCode, string(respBody))
+	}
+
+	var live map[string]struct {
+		Mappings struct {
+			Meta mappingMeta `json:"_meta"`
+		} `json:"mappings"`
+	}
+	if err := json.Unmarshal(respBody, &live); err != nil {
+		return fmt.Errorf("error parsing %s mappings: %w", m.name, err)
+	}
+
+	var outdated []string
+	for index, state := range live {
+		meta := state.Mappings.Meta
+		switch {
+		case meta.Version > m.mapping.version:
+			log.Printf("Warning: index %s has mapping v%d, newer than v%d; leaving it alone", index, meta.Version, m.mapping.version)
+		case meta.Version < m.mapping.version || meta.Checksum != m.mapping.checksum:
+			outdated = append(outdated, index)
+		}
+	}
+	if len(outdated) == 0 {
+		return nil
+	}
+	sort.Strings(outdated)
+
+	var doc struct {
+		Mappings json.RawMessage `json:"mappings"`
+	}
+	if err := json.Unmarshal([]byte(m.mapping.body), &doc); err != nil {
+		return fmt.Errorf("error parsing %s mapping: %w", m.name, err)
+	}
+
+	putRes, err := opensearchapi.IndicesPutMappingRequest{
+		Index: outdated,
+		Body:  bytes.NewReader(doc.Mappings),
+	}.Do(ctx, client)
+	if err != nil {
+		return fmt.Errorf("error upgrading %s mapping: %w", m.name, err)
+	}
+	defer putRes.Body.Close()
+	if putRes.IsError() {
+		putBody, _ := io.ReadAll(putRes.Body)
+		return fmt.Errorf("error upgrading %s mapping: Status: %d, Response: %s", m.name, putRes.StatusCode, string(putBody))
+	}
+
+	log.Printf("Upgraded %s mapping to v%d (%s) on %s", m.name, m.mapping.version, m.mapping.checksum, strings.Join(outdated, ", "))
+	return nil
+}
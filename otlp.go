@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Handler for OTLP/HTTP log exports, in protobuf or JSON. Each log record
+// becomes an event; records that cannot be stored are reported back as
+// rejected in the partial success, which exporters do not retry.
+func (s *Server) handleOTLPLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-protobuf" && mediaType != "application/json" {
+		http.Error(w, "Content-Type must be application/x-protobuf or application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	wait, err := s.syncRequested(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := s.readBody(w, r, s.config.Batch.MaxBytes)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	var export collogspb.ExportLogsServiceRequest
+	if mediaType == "application/json" {
+		err = s.unmarshalOTLPJSON(data, &export)
+	} else {
+		err = proto.Unmarshal(data, &export)
+	}
+	if err != nil {
+		http.Error(w, "Invalid OTLP logs request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events := otlpEvents(&export)
+	if len(events) > s.config.Batch.MaxItems {
+		http.Error(w, fmt.Sprintf("Export exceeds %d log records", s.config.Batch.MaxItems), http.StatusRequestEntityTooLarge)
+		return
+	}
+	warning := false
+	for _, event := range events {
+		warning = warning || event.Type == "Warning"
+	}
+
+	// Exporters retry a whole export on 429 and 503, so saturation rejects
+	// it before any record is written
+	ctx, done, refused := s.accept(r.Context(), warning)
+	if refused != nil {
+		refuse(w, refused)
+		return
+	}
+	defer done()
+
+	meta := newRequestMeta(r)
+	var rejected int64
+	var firstErr string
+	reject := func(err string) {
+		if rejected == 0 {
+			firstErr = err
+		}
+		rejected++
+	}
+	var ings []*ingestion
+	for _, event := range events {
+		if err := validateEvent(event); err != nil {
+			reject("invalid log record: " + err.Error())
+			continue
+		}
+		ing, err := s.newIngestion(ctx, meta, "", event)
+		if err != nil {
+			log.Printf("Failed to prepare event: %v", err)
+			reject("internal server error")
+			continue
+		}
+		ings = append(ings, ing)
+	}
+
+	for n, err := range s.submit(ctx, r.Context(), ings, wait) {
+		if err != nil {
+			if reason, ok := s.shedReason(err); ok {
+				s.admission.countShed(r.Context(), reason, ings[n].warning)
+				err = fmt.Errorf("service saturated (%s)", reason)
+			}
+			reject(err.Error())
+			continue
+		}
+		if !wait {
+			continue
+		}
+		var item batchItem
+		status, ok := s.statuses.wait(r.Context(), ings[n].id)
+		item.setStatus(status, ok)
+		if item.Status >= 400 {
+			reject(item.Result + ": " + item.Error)
+		}
+	}
+
+	response := &collogspb.ExportLogsServiceResponse{}
+	if rejected > 0 {
+		response.PartialSuccess = &collogspb.ExportLogsPartialSuccess{
+			RejectedLogRecords: rejected,
+			ErrorMessage:       fmt.Sprintf("%d of %d log records were not stored, the first because: %s", rejected, len(events), firstErr),
+		}
+	}
+	var body []byte
+	if mediaType == "application/json" {
+		body, err = protojson.Marshal(response)
+	} else {
+		body, err = proto.Marshal(response)
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// Function to decode an OTLP/JSON request. It differs from the canonical
+// protobuf JSON mapping in that trace and span IDs are hex rather than
+// base64, so those are converted first.
+func (s *Server) unmarshalOTLPJSON(data []byte, export *collogspb.ExportLogsServiceRequest) error {
+	if err := checkDepth(data, s.config.Body.MaxDepth); err != nil {
+		return err
+	}
+	var generic map[string]any
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return err
+	}
+	for _, resource := range list(generic["resourceLogs"]) {
+		for _, scope := range list(field(resource, "scopeLogs")) {
+			for _, record := range list(field(scope, "logRecords")) {
+				fields, _ := record.(map[string]any)
+				for _, name := range []string{"traceId", "spanId"} {
+					id, ok := fields[name].(string)
+					if !ok || id == "" {
+						continue
+					}
+					decoded, err := hex.DecodeString(id)
+					if err != nil {
+						return fmt.Errorf("invalid %s %q: %w", name, id, err)
+					}
+					fields[name] = base64.StdEncoding.EncodeToString(decoded)
+				}
+			}
+		}
+	}
+	converted, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(converted, export)
+}
+
+// Function to read a field of a decoded JSON object, nil if absent
+func field(v any, name string) any {
+	m, _ := v.(map[string]any)
+	return m[name]
+}
+
+// Function to read a decoded JSON array, nil if v is not one
+func list(v any) []any {
+	l, _ := v.([]any)
+	return l
+}
+
+// Function to convert the log records of an export into events. Resource
+// attributes become labels, with service.name and service.instance.id
+// naming the involved object; record attributes are kept as attributes.
+// Their keys are flattened, so service.name is stored as service_name.
+func otlpEvents(export *collogspb.ExportLogsServiceRequest) []Event {
+	var events []Event
+	for _, resourceLogs := range export.GetResourceLogs() {
+		labels := map[string]string{}
+		for _, kv := range resourceLogs.GetResource().GetAttributes() {
+			labels[flatKey(kv.GetKey())] = otlpString(kv.GetValue())
+		}
+		for _, scopeLogs := range resourceLogs.GetScopeLogs() {
+			for _, record := range scopeLogs.GetLogRecords() {
+				events = append(events, otlpEvent(labels, scopeLogs.GetScope(), record))
+			}
+		}
+	}
+	return events
+}
+
+// Function to convert one log record into an event
+func otlpEvent(labels map[string]string, scope *commonpb.InstrumentationScope, record *logspb.LogRecord) Event {
+	var event Event
+	event.Kind = "LogRecord"
+	event.Metadata.Name = record.GetEventName()
+	if len(labels) > 0 {
+		event.Metadata.Labels = labels
+	}
+	event.Metadata.Message = otlpString(record.GetBody())
+	// A service that does not name itself is unknown_service, as the
+	// OpenTelemetry SDKs call it
+	event.InvolvedObject.Kind, event.InvolvedObject.Name = "Service", "unknown_service"
+	if service := labels["service_name"]; service != "" {
+		event.InvolvedObject.Name = service
+		event.InvolvedObject.UUID = labels["service_instance_id"]
+	}
+	event.Type = severityType(record.GetSeverityNumber(), record.GetSeverityText())
+
+	// The trace ID correlates the records of a request; the span is kept
+	// as an attribute
+	event.CorrelationID = hex.EncodeToString(record.GetTraceId())
+	if event.CorrelationID == "" {
+		event.CorrelationID = hex.EncodeToString(record.GetSpanId())
+	}
+
+	timestamp := record.GetTimeUnixNano()
+	if timestamp == 0 {
+		timestamp = record.GetObservedTimeUnixNano()
+	}
+	if timestamp != 0 {
+		event.EventTime = time.Unix(0, int64(timestamp)).UTC().Format(time.RFC3339Nano)
+	}
+
+	attrs := map[string]string{}
+	for _, kv := range record.GetAttributes() {
+		attrs[flatKey(kv.GetKey())] = otlpString(kv.GetValue())
+	}
+	if len(record.GetSpanId()) > 0 {
+		attrs["span_id"] = hex.EncodeToString(record.GetSpanId())
+	}
+	if record.GetSeverityText() != "" {
+		attrs["severity"] = record.GetSeverityText()
+	}
+	if scope.GetName() != "" {
+		attrs["scope"] = scope.GetName()
+	}
+	if len(attrs) > 0 {
+		event.Attributes = attrs
+	}
+	return event
+}
+
+// Function to map a log severity to an event type: WARN and above is a
+// Warning. Records without a severity number are judged by their text.
+func severityType(number logspb.SeverityNumber, text string) string {
+	if number == logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED {
+		text = strings.ToUpper(text)
+		for _, prefix := range []string{"WARN", "ERR", "FATAL", "CRIT", "ALERT", "EMERG"} {
+			if strings.HasPrefix(text, prefix) {
+				return "Warning"
+			}
+		}
+		return "Normal"
+	}
+	if number >= logspb.SeverityNumber_SEVERITY_NUMBER_WARN {
+		return "Warning"
+	}
+	return "Normal"
+}
+
+// Function to render an OTLP value as a string; arrays and maps are
+// rendered as JSON
+func otlpString(v *commonpb.AnyValue) string {
+	switch value := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return value.StringValue
+	case nil:
+		return ""
+	}
+	data, err := json.Marshal(otlpValue(v))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// Function to convert an OTLP value into its plain Go equivalent
+func otlpValue(v *commonpb.AnyValue) any {
+	switch value := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return value.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return value.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return value.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return value.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(value.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		values := []any{}
+		for _, item := range value.ArrayValue.GetValues() {
+			values = append(values, otlpValue(item))
+		}
+		return values
+	case *commonpb.AnyValue_KvlistValue:
+		values := map[string]any{}
+		for _, kv := range value.KvlistValue.GetValues() {
+			values[kv.GetKey()] = otlpValue(kv.GetValue())
+		}
+		return values
+	}
+	return nil
+}
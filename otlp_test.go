@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+)
+
+// Function to decode an OTLP/JSON export into events
+func otlpJSONEvents(t *testing.T, body string) ([]Event, error) {
+	t.Helper()
+	s := &Server{config: defaultConfig()}
+	var export collogspb.ExportLogsServiceRequest
+	if err := s.unmarshalOTLPJSON([]byte(body), &export); err != nil {
+		return nil, err
+	}
+	return otlpEvents(&export), nil
+}
+
+func TestOTLPFlattensAttributeKeys(t *testing.T) {
+	events, err := otlpJSONEvents(t, `{"resourceLogs": [{
+		"resource": {"attributes": [
+			{"key": "service.name", "value": {"stringValue": "checkout"}},
+			{"key": "service.instance.id", "value": {"stringValue": "pod-1"}},
+			{"key": "deployment.environment", "value": {"stringValue": "prod"}},
+			{"key": "deployment.environment.name", "value": {"stringValue": "prod-eu"}}
+		]},
+		"scopeLogs": [{"logRecords": [{
+			"body": {"stringValue": "hello"},
+			"attributes": [
+				{"key": "http.status", "value": {"intValue": "500"}},
+				{"key": "http.status.text", "value": {"stringValue": "Internal Server Error"}}
+			]
+		}]}]
+	}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	event := events[0]
+
+	wantLabels := map[string]string{
+		"service_name":                "checkout",
+		"service_instance_id":         "pod-1",
+		"deployment_environment":      "prod",
+		"deployment_environment_name": "prod-eu",
+	}
+	if !reflect.DeepEqual(event.Metadata.Labels, wantLabels) {
+		t.Errorf("labels %v, want %v", event.Metadata.Labels, wantLabels)
+	}
+	wantAttrs := map[string]string{"http_status": "500", "http_status_text": "Internal Server Error"}
+	if !reflect.DeepEqual(event.Attributes, wantAttrs) {
+		t.Errorf("attributes %v, want %v", event.Attributes, wantAttrs)
+	}
+	if event.InvolvedObject.Name != "checkout" || event.InvolvedObject.UUID != "pod-1" {
+		t.Errorf("involved object %s/%s, want checkout/pod-1", event.InvolvedObject.Name, event.InvolvedObject.UUID)
+	}
+}
+
+func TestOTLPJSONHexIDs(t *testing.T) {
+	tests := []struct {
+		name        string
+		record      string
+		correlation string
+		spanID      string
+		err         string
+	}{
+		{
+			name:        "trace and span",
+			record:      `{"traceId": "5b8efff798038103d269b633813fc60c", "spanId": "eee19b7ec3c1b174"}`,
+			correlation: "5b8efff798038103d269b633813fc60c",
+			spanID:      "eee19b7ec3c1b174",
+		},
+		{
+			name:        "span only correlates by span",
+			record:      `{"spanId": "eee19b7ec3c1b174"}`,
+			correlation: "eee19b7ec3c1b174",
+			spanID:      "eee19b7ec3c1b174",
+		},
+		{
+			name:   "empty IDs",
+			record: `{"traceId": "", "spanId": ""}`,
+		},
+		{
+			name:   "base64 is not hex",
+			record: `{"traceId": "W47/95gDgQPSabYzgT/GDA=="}`,
+			err:    "invalid traceId",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := otlpJSONEvents(t, `{"resourceLogs": [{"scopeLogs": [{"logRecords": [`+tt.record+`]}]}]}`)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != 1 {
+				t.Fatalf("got %d events, want 1", len(events))
+			}
+			if events[0].CorrelationID != tt.correlation {
+				t.Errorf("correlationId %q, want %q", events[0].CorrelationID, tt.correlation)
+			}
+			if got := events[0].Attributes["span_id"]; got != tt.spanID {
+				t.Errorf("span_id %q, want %q", got, tt.spanID)
+			}
+		})
+	}
+}
+
+func TestSeverityType(t *testing.T) {
+	tests := []struct {
+		number logspb.SeverityNumber
+		text   string
+		want   string
+	}{
+		{logspb.SeverityNumber_SEVERITY_NUMBER_INFO, "", "Normal"},
+		{logspb.SeverityNumber_SEVERITY_NUMBER_INFO4, "WARNING", "Normal"},
+		{logspb.SeverityNumber_SEVERITY_NUMBER_WARN, "", "Warning"},
+		{logspb.SeverityNumber_SEVERITY_NUMBER_FATAL4, "", "Warning"},
+		{logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED, "", "Normal"},
+		{logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED, "info", "Normal"},
+		{logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED, "warn", "Warning"},
+		{logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED, "Error", "Warning"},
+		{logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED, "critical", "Warning"},
+		{logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED, "emerg", "Warning"},
+	}
+	for _, tt := range tests {
+		if got := severityType(tt.number, tt.text); got != tt.want {
+			t.Errorf("severityType(%v, %q) = %s, want %s", tt.number, tt.text, got, tt.want)
+		}
+	}
+}
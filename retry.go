@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// RetryConfig controls how failed OpenSearch writes are retried. Timeout
+// bounds a bulk request including all of its retries.
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"maxAttempts" json:"maxAttempts" env:"RETRY_MAX_ATTEMPTS"`
+	InitialBackoff time.Duration `yaml:"initialBackoff" json:"initialBackoff" env:"RETRY_INITIAL_BACKOFF"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff" json:"maxBackoff" env:"RETRY_MAX_BACKOFF"`
+	Timeout        time.Duration `yaml:"timeout" json:"timeout" env:"RETRY_TIMEOUT"`
+}
+
+// Function to validate the retry settings
+func (c RetryConfig) validate() error {
+	var errs []error
+	if c.MaxAttempts <= 0 {
+		errs = append(errs, errors.New("retry.maxAttempts: must be positive"))
+	}
+	if c.InitialBackoff <= 0 || c.MaxBackoff < c.InitialBackoff {
+		errs = append(errs, errors.New("retry: initialBackoff must be positive and not exceed maxBackoff"))
+	}
+	if c.Timeout <= 0 {
+		errs = append(errs, errors.New("retry.timeout: must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// statusError is an OpenSearch request that failed as a whole
+type statusError struct {
+	Status     int
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("error sending bulk request: Status: %d, Response: %s", e.Status, e.Body)
+}
+
+// retryPolicy decides which failures to retry and how long to wait, and
+// counts what it does
+type retryPolicy struct {
+	config    RetryConfig
+	retries   metric.Int64Counter
+	exhausted metric.Int64Counter
+}
+
+// Function to create a retry policy and its metrics
+func newRetryPolicy(config RetryConfig, meter metric.Meter) (*retryPolicy, error) {
+	retries, err := meter.Int64Counter(
+		"opensearch_write_retries",
+		metric.WithDescription("Documents retried after a retryable OpenSearch failure"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create retry counter: %w", err)
+	}
+	exhausted, err := meter.Int64Counter(
+		"opensearch_write_retries_exhausted",
+		metric.WithDescription("Documents that were still failing when their retries ran out"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create retry exhaustion counter: %w", err)
+	}
+	return &retryPolicy{config: config, retries: retries, exhausted: exhausted}, nil
+}
+
+// Function to classify a failed write. Network errors, a connection cut
+// short, 429 and 502/503/504 are retryable; 400 (mapping errors), 401, 403
+// and any other status are permanent, as are cancellation and errors
+// encoding or parsing a request. Deadlines are enforced by wait, not here,
+// so a write cut short by one is still worth retrying later.
+func retryable(err error) bool {
+	var itemErr *bulkItemError
+	if errors.As(err, &itemErr) {
+		return retryableStatus(itemErr.Status)
+	}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return retryableStatus(statusErr.Status)
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// Function to tell whether an HTTP status is worth retrying
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Function to label a retryable failure for the retry metrics
+func retryReason(err error) string {
+	var itemErr *bulkItemError
+	if errors.As(err, &itemErr) {
+		return strconv.Itoa(itemErr.Status)
+	}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return strconv.Itoa(statusErr.Status)
+	}
+	return "transport"
+}
+
+// Function to compute the delay before the given retry (1 for the first):
+// exponential from the initial backoff, capped, with equal jitter so that
+// clients retrying together spread out
+func (p *retryPolicy) backoff(attempt int) time.Duration {
+	d := p.config.InitialBackoff
+	for i := 1; i < attempt && d < p.config.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, p.config.MaxBackoff)
+	return d/2 + rand.N(d/2+1)
+}
+
+// Function to wait before the given retry, at least as long as the server
+// asked for with Retry-After. It fails straight away if the wait would
+// outlast ctx's deadline.
+func (p *retryPolicy) wait(ctx context.Context, attempt int, retryAfter time.Duration) error {
+	delay := max(p.backoff(attempt), retryAfter)
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return fmt.Errorf("retrying in %s would pass the deadline: %w", delay, context.DeadlineExceeded)
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// Function to count documents being retried
+func (p *retryPolicy) countRetries(ctx context.Context, n int, reason string) {
+	p.retries.Add(ctx, int64(n), metric.WithAttributes(attribute.String("reason", reason)))
+}
+
+// Function to count documents whose retries ran out
+func (p *retryPolicy) countExhausted(ctx context.Context, n int) {
+	p.exhausted.Add(ctx, int64(n))
+}
+
+// Function to parse a Retry-After header given in seconds or as an HTTP
+// date; 0 if absent or invalid
+func parseRetryAfter(header string) time.Duration {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
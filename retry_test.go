@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	jsonErr := json.Unmarshal([]byte("{"), &map[string]any{})
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "connection refused", err: &url.Error{Op: "Post", URL: "http://localhost:9200/_bulk", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}, want: true},
+		{name: "timeout", err: &url.Error{Op: "Post", URL: "http://localhost:9200/_bulk", Err: os.ErrDeadlineExceeded}, want: true},
+		{name: "deadline", err: fmt.Errorf("error sending bulk request: %w", context.DeadlineExceeded), want: true},
+		{name: "connection reset", err: fmt.Errorf("error reading bulk response: %w", syscall.ECONNRESET), want: true},
+		{name: "truncated response", err: fmt.Errorf("error reading bulk response: %w", io.ErrUnexpectedEOF), want: true},
+		{name: "cancelled", err: context.Canceled},
+		{name: "cancelled request", err: &url.Error{Op: "Post", URL: "http://localhost:9200/_bulk", Err: context.Canceled}},
+		{name: "unparsable response", err: fmt.Errorf("error parsing bulk response: %w", jsonErr)},
+		{name: "item count mismatch", err: errors.New("bulk response has 1 items for 2 documents")},
+		{name: "429", err: &statusError{Status: http.StatusTooManyRequests}, want: true},
+		{name: "502", err: &statusError{Status: http.StatusBadGateway}, want: true},
+		{name: "503", err: &statusError{Status: http.StatusServiceUnavailable}, want: true},
+		{name: "504", err: &statusError{Status: http.StatusGatewayTimeout}, want: true},
+		{name: "500", err: &statusError{Status: http.StatusInternalServerError}},
+		{name: "401", err: &statusError{Status: http.StatusUnauthorized}},
+		{name: "403", err: &statusError{Status: http.StatusForbidden}},
+		{name: "item 429", err: &bulkItemError{Status: http.StatusTooManyRequests}, want: true},
+		{name: "item mapping error", err: &bulkItemError{Status: http.StatusBadRequest, Type: "mapper_parsing_exception"}},
+		{name: "item conflict", err: &bulkItemError{Status: http.StatusConflict}},
+		{name: "wrapped item error", err: fmt.Errorf("%w (gave up retrying)", &bulkItemError{Status: http.StatusServiceUnavailable}), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		min    time.Duration
+		max    time.Duration
+	}{
+		{name: "absent"},
+		{name: "seconds", header: "7", min: 7 * time.Second, max: 7 * time.Second},
+		{name: "padded seconds", header: " 2 ", min: 2 * time.Second, max: 2 * time.Second},
+		{name: "zero", header: "0"},
+		{name: "negative", header: "-5"},
+		{name: "garbage", header: "soon"},
+		{name: "future date", header: time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), min: 58 * time.Second, max: time.Minute},
+		{name: "past date", header: time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseRetryAfter(tt.header)
+			if got < tt.min || got > tt.max {
+				t.Errorf("parseRetryAfter(%q) = %s, want between %s and %s", tt.header, got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := &retryPolicy{config: RetryConfig{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}}
+	tests := []struct {
+		attempt int
+		ceiling time.Duration
+	}{
+		{attempt: 1, ceiling: 100 * time.Millisecond},
+		{attempt: 2, ceiling: 200 * time.Millisecond},
+		{attempt: 4, ceiling: 800 * time.Millisecond},
+		{attempt: 5, ceiling: time.Second},
+		{attempt: 50, ceiling: time.Second},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			if d := p.backoff(tt.attempt); d < tt.ceiling/2 || d > tt.ceiling {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", tt.attempt, d, tt.ceiling/2, tt.ceiling)
+			}
+		}
+	}
+}
+
+func TestRetryWaitHonoursDeadline(t *testing.T) {
+	p := &retryPolicy{config: RetryConfig{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if err := p.wait(ctx, 1, time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wait past the deadline returned %v", err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("wait past the deadline slept instead of failing straight away")
+	}
+	if err := p.wait(ctx, 1, 0); err != nil {
+		t.Fatalf("wait within the deadline returned %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Function to run the HTTP server until ctx is cancelled, then stop
+// accepting connections and drain in-flight handlers and pending
+// OpenSearch writes. It returns an error if the drain did not complete
+// within the configured shutdown timeout.
+func (s *Server) serve(ctx context.Context, handler http.Handler) error {
+	httpServer := &http.Server{
+		Addr:              s.config.Server.ListenAddress,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Every listener is bound before any serves, so that a port in use
+	// stops the service before it accepts anything
+	addr := httpServer.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	httpListener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start server: %w", err)
+	}
+	var grpcListener net.Listener
+	if s.grpc != nil {
+		grpcListener, err = net.Listen("tcp", s.config.GRPC.ListenAddress)
+		if err != nil {
+			httpListener.Close()
+			return fmt.Errorf("failed to start gRPC server: %w", err)
+		}
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Starting server on port %s", httpServer.Addr)
+		serveErr <- httpServer.Serve(httpListener)
+	}()
+
+	// A nil channel never delivers, so without gRPC only HTTP is watched
+	var grpcErr chan error
+	if s.grpc != nil {
+		grpcErr = make(chan error, 1)
+		go func() {
+			log.Printf("Starting gRPC server on %s", grpcListener.Addr())
+			grpcErr <- s.grpc.server.Serve(grpcListener)
+		}()
+		go s.grpc.watchHealth(ctx, s)
+	}
+
+	select {
+	case err := <-serveErr:
+		if s.grpc != nil {
+			s.grpc.server.Stop()
+		}
+		return fmt.Errorf("HTTP server failed: %w", err)
+	case err := <-grpcErr:
+		httpServer.Close()
+		return fmt.Errorf("gRPC server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	timeout := s.config.Server.ShutdownTimeout
+	log.Printf("Shutting down, draining in-flight requests for up to %s", timeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var errs []error
+	if s.grpc != nil {
+		if err := s.grpc.stop(drainCtx); err != nil {
+			errs = append(errs, fmt.Errorf("in-flight gRPC calls not drained: %w", err))
+		}
+	}
+	if err := httpServer.Shutdown(drainCtx); err != nil {
+		errs = append(errs, fmt.Errorf("in-flight requests not drained: %w", err))
+	}
+	if err := waitForGroup(drainCtx, &s.sources); err != nil {
+		errs = append(errs, fmt.Errorf("event sources not stopped: %w", err))
+	}
+	if err := waitForGroup(drainCtx, &s.pending); err != nil {
+		errs = append(errs, fmt.Errorf("pending OpenSearch writes not drained: %w", err))
+	}
+	if s.spool != nil {
+		if err := s.spool.close(drainCtx); err != nil {
+			errs = append(errs, fmt.Errorf("spool not closed: %w", err))
+		}
+	}
+	if s.bulk != nil {
+		if err := s.bulk.close(drainCtx); err != nil {
+			errs = append(errs, fmt.Errorf("bulk indexer not flushed: %w", err))
+		}
+	}
+	if s.deadLetters != nil {
+		if err := s.deadLetters.close(drainCtx); err != nil {
+			errs = append(errs, fmt.Errorf("dead letters not flushed: %w", err))
+		}
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	log.Printf("Drain complete")
+	return nil
+}
+
+// Function to wait for a WaitGroup, giving up when ctx is done
+func waitForGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeReleasesHTTPWhenGRPCCannotBind(t *testing.T) {
+	// Hold the gRPC port so that its bind fails
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpAddr := free.Addr().String()
+	free.Close()
+
+	s := &Server{config: defaultConfig()}
+	s.config.Server.ListenAddress = httpAddr
+	s.config.GRPC.ListenAddress = taken.Addr().String()
+	s.grpc = newGRPCService(s)
+
+	err = s.serve(t.Context(), http.NotFoundHandler())
+	if err == nil || !strings.Contains(err.Error(), "failed to start gRPC server") {
+		t.Fatalf("serve error %v, want a gRPC bind failure", err)
+	}
+
+	// The HTTP port must have been released, not left serving; give a
+	// leaked server time to bind before checking
+	time.Sleep(100 * time.Millisecond)
+	l, err := net.Listen("tcp", httpAddr)
+	if err != nil {
+		t.Fatalf("HTTP port still in use: %v", err)
+	}
+	l.Close()
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// AWSConfig enables SigV4 request signing for Amazon OpenSearch Service
+type AWSConfig struct {
+	SigV4                 bool   `yaml:"sigv4" json:"sigv4" env:"OPENSEARCH_AWS_SIGV4"`
+	Region                string `yaml:"region" json:"region" env:"AWS_REGION"`
+	Service               string `yaml:"service" json:"service" env:"OPENSEARCH_AWS_SERVICE"`
+	Profile               string `yaml:"profile" json:"profile" env:"AWS_PROFILE"`
+	SharedCredentialsFile string `yaml:"sharedCredentialsFile" json:"sharedCredentialsFile" env:"AWS_SHARED_CREDENTIALS_FILE"`
+	RoleARN               string `yaml:"roleArn" json:"roleArn" env:"AWS_ROLE_ARN"`
+	WebIdentityTokenFile  string `yaml:"webIdentityTokenFile" json:"webIdentityTokenFile" env:"AWS_WEB_IDENTITY_TOKEN_FILE"`
+	RoleSessionName       string `yaml:"roleSessionName" json:"roleSessionName" env:"AWS_ROLE_SESSION_NAME"`
+	STSEndpoint           string `yaml:"stsEndpoint" json:"stsEndpoint" env:"AWS_STS_ENDPOINT"`
+}
+
+// Function to validate the SigV4 settings
+func (c AWSConfig) validate() error {
+	if !c.SigV4 {
+		return nil
+	}
+	var errs []error
+	if c.Region == "" {
+		errs = append(errs, errors.New("opensearch.aws.region: required when sigv4 is enabled"))
+	}
+	if c.Service != "es" && c.Service != "aoss" {
+		errs = append(errs, fmt.Errorf("opensearch.aws.service: %q is not one of es, aoss", c.Service))
+	}
+	if (c.RoleARN == "") != (c.WebIdentityTokenFile == "") {
+		errs = append(errs, errors.New("opensearch.aws: roleArn and webIdentityTokenFile must be set together"))
+	}
+	return errors.Join(errs...)
+}
+
+// awsCredentials is a set of (possibly temporary) AWS credentials
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time
+}
+
+// Function to report whether the credentials are close enough to expiry
+// that they should be refreshed before signing
+func (c awsCredentials) expired(now time.Time) bool {
+	return !c.Expires.IsZero() && now.Add(5*time.Minute).After(c.Expires)
+}
+
+// How long credentials from the environment or the shared credentials
+// file are used before being read again, so that rotated keys are picked up
+const awsStaticCredentialsTTL = 15 * time.Minute
+
+// awsCredentialChain resolves credentials from, in order, the environment,
+// a web-identity token file and the shared credentials file. Credentials
+// close to expiry are refreshed once for all callers, which keep signing
+// with the current ones until the refresh completes.
+type awsCredentialChain struct {
+	config  AWSConfig
+	client  *http.Client
+	now     func() time.Time
+	refresh singleflight.Group
+
+	mu     sync.Mutex
+	cached awsCredentials
+}
+
+// Function to return valid credentials. Callers only wait for a refresh
+// when there are no credentials yet or the current ones have expired.
+func (c *awsCredentialChain) retrieve(ctx context.Context) (awsCredentials, error) {
+	now := c.now()
+	c.mu.Lock()
+	cached := c.cached
+	c.mu.Unlock()
+
+	if cached.AccessKeyID != "" && !cached.expired(now) {
+		return cached, nil
+	}
+	result := c.refresh.DoChan("", func() (any, error) {
+		return c.resolve()
+	})
+	if cached.AccessKeyID != "" && now.Before(cached.Expires) {
+		return cached, nil
+	}
+
+	select {
+	case res := <-result:
+		if res.Err != nil {
+			return awsCredentials{}, res.Err
+		}
+		return res.Val.(awsCredentials), nil
+	case <-ctx.Done():
+		return awsCredentials{}, ctx.Err()
+	}
+}
+
+// Function to resolve credentials from the chain and cache them. It is
+// not tied to any one request, as callers share its result.
+func (c *awsCredentialChain) resolve() (awsCredentials, error) {
+	ctx := context.Background()
+	var errs []error
+	for _, source := range []func(context.Context) (awsCredentials, error){
+		c.fromEnv,
+		c.fromWebIdentity,
+		c.fromSharedFile,
+	} {
+		creds, err := source(ctx)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if creds.AccessKeyID != "" {
+			c.mu.Lock()
+			c.cached = creds
+			c.mu.Unlock()
+			return creds, nil
+		}
+	}
+	if len(errs) == 0 {
+		errs = append(errs, errors.New("no AWS credentials found in environment, web identity or shared credentials file"))
+	}
+	err := errors.Join(errs...)
+	log.Printf("Warning: refreshing AWS credentials failed: %v", err)
+	return awsCredentials{}, err
+}
+
+// Function to read credentials from the standard AWS environment variables
+func (c *awsCredentialChain) fromEnv(context.Context) (awsCredentials, error) {
+	id := os.Getenv("AWS_ACCESS_KEY_ID")
+	secret := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if id == "" || secret == "" {
+		return awsCredentials{}, nil
+	}
+	return awsCredentials{
+		AccessKeyID:     id,
+		SecretAccessKey: secret,
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Expires:         c.now().Add(awsStaticCredentialsTTL),
+	}, nil
+}
+
+// Function to read a named profile from the shared credentials file
+func (c *awsCredentialChain) fromSharedFile(context.Context) (awsCredentials, error) {
+	path := c.config.SharedCredentialsFile
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return awsCredentials{}, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := c.config.Profile
+	if profile == "" {
+		profile = "default"
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return awsCredentials{}, nil
+		}
+		return awsCredentials{}, fmt.Errorf("error opening shared credentials file: %w", err)
+	}
+	defer f.Close()
+
+	var creds awsCredentials
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			creds.SessionToken = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return awsCredentials{}, fmt.Errorf("error reading shared credentials file: %w", err)
+	}
+	if creds.AccessKeyID != "" && creds.SecretAccessKey == "" {
+		return awsCredentials{}, fmt.Errorf("profile %q in %s has no aws_secret_access_key", profile, path)
+	}
+	creds.Expires = c.now().Add(awsStaticCredentialsTTL)
+	return creds, nil
+}
+
+// assumeRoleWithWebIdentityResponse is the subset of the STS response we use
+type assumeRoleWithWebIdentityResponse struct {
+	Result struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"Credentials"`
+	} `xml:"AssumeRoleWithWebIdentityResult"`
+}
+
+// Function to exchange a web-identity token (e.g. an EKS service account
+// token) for temporary credentials via STS
+func (c *awsCredentialChain) fromWebIdentity(ctx context.Context) (awsCredentials, error) {
+	if c.config.WebIdentityTokenFile == "" || c.config.RoleARN == "" {
+		return awsCredentials{}, nil
+	}
+
+	// The token file is rotated by the kubelet, so read it on every exchange
+	token, err := os.ReadFile(c.config.WebIdentityTokenFile)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("error reading web identity token: %w", err)
+	}
+
+	endpoint := c.config.STSEndpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://sts.%s.amazonaws.com/", c.config.Region)
+	}
+	sessionName := c.config.RoleSessionName
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("go-opensearch-logging-%d", time.Now().Unix())
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {c.config.RoleARN},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("error building STS request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("error calling STS: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("error reading STS response: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return awsCredentials{}, fmt.Errorf("error assuming role: Status: %d, Response: %s", res.StatusCode, string(body))
+	}
+
+	var out assumeRoleWithWebIdentityResponse
+	if err := xml.Unmarshal(body, &out); err != nil {
+		return awsCredentials{}, fmt.Errorf("error parsing STS response: %w", err)
+	}
+	creds := out.Result.Credentials
+	return awsCredentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		Expires:         creds.Expiration,
+	}, nil
+}
+
+// sigV4Signer signs OpenSearch requests with AWS Signature Version 4. It
+// satisfies the opensearch-go signer.Signer interface.
+type sigV4Signer struct {
+	region      string
+	service     string
+	credentials *awsCredentialChain
+	now         func() time.Time
+}
+
+// Function to build a signer from the AWS config
+func newSigV4Signer(c AWSConfig) *sigV4Signer {
+	return &sigV4Signer{
+		region:  c.Region,
+		service: c.Service,
+		credentials: &awsCredentialChain{
+			config: c,
+			client: &http.Client{Timeout: 30 * time.Second},
+			now:    time.Now,
+		},
+		now: time.Now,
+	}
+}
+
+// SignRequest adds the SigV4 headers. The transport calls it again on each
+// retry, so any previous signature is replaced.
+func (s *sigV4Signer) SignRequest(req *http.Request) error {
+	creds, err := s.credentials.retrieve(req.Context())
+	if err != nil {
+		return fmt.Errorf("error resolving AWS credentials: %w", err)
+	}
+
+	payload, err := readRequestBody(req)
+	if err != nil {
+		return err
+	}
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Del("Authorization")
+	req.Header.Del("X-Amz-Security-Token")
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	signV4(req, payloadHash, creds, s.region, s.service, s.now().UTC())
+	return nil
+}
+
+// Function to read the request body for hashing without consuming it
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("error reading request body for signing: %w", err)
+		}
+		defer body.Close()
+		return io.ReadAll(body)
+	}
+
+	payload, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading request body for signing: %w", err)
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(payload))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(payload)), nil
+	}
+	return payload, nil
+}
+
+// Function to compute and set the SigV4 Authorization header. The host and
+// every x-amz-* header present on the request are signed.
+func signV4(req *http.Request, payloadHash string, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.Join(values, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name)
+		canonicalHeaders.WriteByte(':')
+		canonicalHeaders.WriteString(strings.Join(strings.Fields(headers[name]), " "))
+		canonicalHeaders.WriteByte('\n')
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEncode(path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+// Function to build the canonical query string: keys and values encoded
+// and sorted by key, then value
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), values[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Function to percent-encode everything outside the SigV4 unreserved set;
+// slashes are kept when encoding a path
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sigV4StandIn is an OpenSearch stand-in that checks the SigV4 signature of
+// every request against its own implementation of the algorithm
+type sigV4StandIn struct {
+	t            *testing.T
+	accessKeyID  string
+	secret       string
+	sessionToken string
+	region       string
+	service      string
+
+	mu       sync.Mutex
+	verified []string
+}
+
+func (s *sigV4StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := s.verify(r); err != nil {
+		s.t.Errorf("%s %s: %v", r.Method, r.URL, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	s.mu.Lock()
+	s.verified = append(s.verified, r.Method+" "+r.URL.Path+" "+r.Header.Get("Content-Encoding"))
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if strings.HasSuffix(r.URL.Path, "/_bulk") {
+		fmt.Fprint(w, `{"took":1,"errors":false,"items":[]}`)
+		return
+	}
+	fmt.Fprint(w, `{"version":{"number":"2.11.0","distribution":"opensearch"}}`)
+}
+
+// Function to recompute the signature of a request and compare it with
+// the one in its Authorization header
+func (s *sigV4StandIn) verify(r *http.Request) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	if got := r.Header.Get("X-Amz-Content-Sha256"); got != payloadHash {
+		return fmt.Errorf("X-Amz-Content-Sha256 is %q, the body hashes to %q", got, payloadHash)
+	}
+	if got := r.Header.Get("X-Amz-Security-Token"); got != s.sessionToken {
+		return fmt.Errorf("X-Amz-Security-Token is %q, want %q", got, s.sessionToken)
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	signedAt, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil {
+		return fmt.Errorf("invalid X-Amz-Date %q: %v", amzDate, err)
+	}
+	if d := time.Since(signedAt); d < -time.Minute || d > 5*time.Minute {
+		return fmt.Errorf("X-Amz-Date %s is %s off", amzDate, d)
+	}
+
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return fmt.Errorf("unexpected Authorization %q", r.Header.Get("Authorization"))
+	}
+	parts := map[string]string{}
+	for _, part := range strings.Split(auth, ", ") {
+		key, value, _ := strings.Cut(part, "=")
+		parts[key] = value
+	}
+	date := signedAt.Format("20060102")
+	scope := date + "/" + s.region + "/" + s.service + "/aws4_request"
+	if want := s.accessKeyID + "/" + scope; parts["Credential"] != want {
+		return fmt.Errorf("Credential is %q, want %q", parts["Credential"], want)
+	}
+
+	signed := strings.Split(parts["SignedHeaders"], ";")
+	for _, required := range []string{"host", "x-amz-content-sha256", "x-amz-date", "x-amz-security-token"} {
+		if !contains(signed, required) {
+			return fmt.Errorf("%s is not signed", required)
+		}
+	}
+	var headers strings.Builder
+	for _, name := range signed {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		fmt.Fprintf(&headers, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+
+	query := r.URL.Query()
+	var keys []string
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			pairs = append(pairs, url.QueryEscape(key)+"="+strings.ReplaceAll(url.QueryEscape(value), "+", "%20"))
+		}
+	}
+
+	canonical := strings.Join([]string{
+		r.Method, r.URL.EscapedPath(), strings.Join(pairs, "&"),
+		headers.String(), parts["SignedHeaders"], payloadHash,
+	}, "\n")
+	canonicalSum := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalSum[:])
+
+	key := []byte("AWS4" + s.secret)
+	for _, step := range []string{date, s.region, s.service, "aws4_request"} {
+		key = mac(key, step)
+	}
+	if want := hex.EncodeToString(mac(key, stringToSign)); parts["Signature"] != want {
+		return fmt.Errorf("signature %s does not match %s for canonical request:\n%s", parts["Signature"], want, canonical)
+	}
+	return nil
+}
+
+func mac(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSigV4SignsRequests(t *testing.T) {
+	standIn := &sigV4StandIn{
+		t:            t,
+		accessKeyID:  "AKIDEXAMPLE",
+		secret:       "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		sessionToken: "session/token+with=odd chars",
+		region:       "eu-west-1",
+		service:      "es",
+	}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("AWS_ACCESS_KEY_ID", standIn.accessKeyID)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", standIn.secret)
+	t.Setenv("AWS_SESSION_TOKEN", standIn.sessionToken)
+
+	bulk := `{"index":{"_index":"events"}}` + "\n" + `{"message":"hello world"}` + "\n"
+	for _, compress := range []bool{false, true} {
+		config := defaultConfig()
+		config.OpenSearch.Addresses = []string{server.URL}
+		config.OpenSearch.CompressRequests = compress
+		config.OpenSearch.AWS.SigV4 = true
+		config.OpenSearch.AWS.Region = standIn.region
+		config.OpenSearch.AWS.Service = standIn.service
+		client, err := newOpenSearchClient(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := client.Info()
+		if err != nil || res.IsError() {
+			t.Fatalf("GET (compress=%v): %v %v", compress, err, res)
+		}
+		res.Body.Close()
+
+		res, err = client.Bulk(strings.NewReader(bulk), client.Bulk.WithIndex("events"), client.Bulk.WithRefresh("wait_for"))
+		if err != nil || res.IsError() {
+			t.Fatalf("POST _bulk (compress=%v): %v %v", compress, err, res)
+		}
+		res.Body.Close()
+	}
+
+	// Each client checks the server version before its first request, and
+	// only bodies are compressed
+	want := []string{"GET / ", "GET / ", "POST /events/_bulk ", "GET / ", "GET / ", "POST /events/_bulk gzip"}
+	if strings.Join(standIn.verified, "|") != strings.Join(want, "|") {
+		t.Errorf("verified %q, want %q", standIn.verified, want)
+	}
+}
+
+func TestSigV4SignatureChangesWithBody(t *testing.T) {
+	creds := awsCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	sign := func(body string) string {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost:9200/_bulk", bytes.NewReader([]byte(body)))
+		signV4(req, sha256Hex([]byte(body)), creds, "us-east-1", "es", now)
+		if got := req.Header.Get("X-Amz-Date"); got != "20240102T030405Z" {
+			t.Errorf("X-Amz-Date = %q", got)
+		}
+		return req.Header.Get("Authorization")
+	}
+	if sign("a") == sign("b") {
+		t.Error("different bodies produced the same signature")
+	}
+}
+
+func TestCredentialChain(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("Action") != "AssumeRoleWithWebIdentity" || r.Form.Get("WebIdentityToken") != "web-token" ||
+			r.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/logging" {
+			http.Error(w, "bad request: "+r.Form.Encode(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>
+			<AccessKeyId>STSKEY</AccessKeyId><SecretAccessKey>stssecret</SecretAccessKey>
+			<SessionToken>ststoken</SessionToken><Expiration>2024-01-02T04:04:05Z</Expiration>
+			</Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`)
+	}))
+	defer sts.Close()
+
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	sharedFile := filepath.Join(dir, "credentials")
+	os.WriteFile(sharedFile, []byte("[default]\naws_access_key_id = FILEKEY\naws_secret_access_key = filesecret\n\n"+
+		"[other]\naws_access_key_id=OTHERKEY\naws_secret_access_key=othersecret\naws_session_token=othertoken\n\n"+
+		"[broken]\naws_access_key_id = BROKEN\n"), 0o600)
+	tokenFile := filepath.Join(dir, "token")
+	os.WriteFile(tokenFile, []byte("web-token\n"), 0o600)
+
+	env := map[string]string{"AWS_ACCESS_KEY_ID": "ENVKEY", "AWS_SECRET_ACCESS_KEY": "envsecret", "AWS_SESSION_TOKEN": "envtoken"}
+	webIdentity := AWSConfig{Region: "eu-west-1", RoleARN: "arn:aws:iam::123456789012:role/logging", WebIdentityTokenFile: tokenFile, STSEndpoint: sts.URL}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		config  AWSConfig
+		want    awsCredentials
+		wantErr string
+	}{
+		{
+			name: "environment",
+			env:  env,
+			want: awsCredentials{"ENVKEY", "envsecret", "envtoken", now.Add(awsStaticCredentialsTTL)},
+		},
+		{
+			name:   "environment wins over the other sources",
+			env:    env,
+			config: AWSConfig{SharedCredentialsFile: sharedFile, RoleARN: webIdentity.RoleARN, WebIdentityTokenFile: tokenFile, STSEndpoint: sts.URL},
+			want:   awsCredentials{"ENVKEY", "envsecret", "envtoken", now.Add(awsStaticCredentialsTTL)},
+		},
+		{
+			name:   "environment without a secret is skipped",
+			env:    map[string]string{"AWS_ACCESS_KEY_ID": "ENVKEY"},
+			config: AWSConfig{SharedCredentialsFile: sharedFile},
+			want:   awsCredentials{"FILEKEY", "filesecret", "", now.Add(awsStaticCredentialsTTL)},
+		},
+		{
+			name:   "web identity wins over the shared file",
+			config: AWSConfig{Region: "eu-west-1", RoleARN: webIdentity.RoleARN, WebIdentityTokenFile: tokenFile, STSEndpoint: sts.URL, SharedCredentialsFile: sharedFile},
+			want:   awsCredentials{"STSKEY", "stssecret", "ststoken", time.Date(2024, 1, 2, 4, 4, 5, 0, time.UTC)},
+		},
+		{
+			name:   "shared file default profile",
+			config: AWSConfig{SharedCredentialsFile: sharedFile},
+			want:   awsCredentials{"FILEKEY", "filesecret", "", now.Add(awsStaticCredentialsTTL)},
+		},
+		{
+			name:   "shared file named profile",
+			config: AWSConfig{SharedCredentialsFile: sharedFile, Profile: "other"},
+			want:   awsCredentials{"OTHERKEY", "othersecret", "othertoken", now.Add(awsStaticCredentialsTTL)},
+		},
+		{
+			name:    "shared file profile without a secret",
+			config:  AWSConfig{SharedCredentialsFile: sharedFile, Profile: "broken"},
+			wantErr: "has no aws_secret_access_key",
+		},
+		{
+			name:    "web identity failure",
+			config:  AWSConfig{RoleARN: "arn:aws:iam::123456789012:role/other", WebIdentityTokenFile: tokenFile, STSEndpoint: sts.URL},
+			wantErr: "error assuming role: Status: 400",
+		},
+		{
+			name:    "nothing configured",
+			config:  AWSConfig{SharedCredentialsFile: filepath.Join(dir, "missing")},
+			wantErr: "no AWS credentials found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"} {
+				t.Setenv(name, tt.env[name])
+			}
+			chain := &awsCredentialChain{config: tt.config, client: sts.Client(), now: func() time.Time { return now }}
+			got, err := chain.retrieve(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Expires.Equal(tt.want.Expires) {
+				t.Errorf("expires = %v, want %v", got.Expires, tt.want.Expires)
+			}
+			got.Expires, tt.want.Expires = time.Time{}, time.Time{}
+			if got != tt.want {
+				t.Errorf("credentials = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCredentialChainPicksUpRotatedEnvironment(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("AWS_ACCESS_KEY_ID", "OLDKEY")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "oldsecret")
+	chain := &awsCredentialChain{now: func() time.Time { return now }}
+
+	retrieve := func() string {
+		creds, err := chain.retrieve(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return creds.AccessKeyID
+	}
+	if got := retrieve(); got != "OLDKEY" {
+		t.Fatalf("got %s", got)
+	}
+	t.Setenv("AWS_ACCESS_KEY_ID", "NEWKEY")
+	if got := retrieve(); got != "OLDKEY" {
+		t.Errorf("credentials re-read before their expiry: got %s", got)
+	}
+
+	// Once they expire the rotated keys must be used
+	now = now.Add(awsStaticCredentialsTTL)
+	if got := retrieve(); got != "NEWKEY" {
+		t.Errorf("rotated credentials not picked up: got %s", got)
+	}
+}
+
+func TestCredentialChainRefreshDoesNotBlock(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	release := make(chan struct{})
+	var calls int
+	var mu sync.Mutex
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		fmt.Fprint(w, `<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>
+			<AccessKeyId>NEWKEY</AccessKeyId><SecretAccessKey>s</SecretAccessKey>
+			<Expiration>2024-01-02T05:00:00Z</Expiration>
+			</Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`)
+	}))
+	defer sts.Close()
+	defer close(release)
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(tokenFile, []byte("token"), 0o600)
+	chain := &awsCredentialChain{
+		config: AWSConfig{RoleARN: "arn", WebIdentityTokenFile: tokenFile, STSEndpoint: sts.URL},
+		client: sts.Client(),
+		now:    func() time.Time { return now },
+	}
+
+	// Inside the refresh window the current credentials keep being used
+	// while a single refresh is in flight
+	chain.cached = awsCredentials{AccessKeyID: "OLDKEY", SecretAccessKey: "s", Expires: now.Add(time.Minute)}
+	for range 5 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		creds, err := chain.retrieve(ctx)
+		cancel()
+		if err != nil || creds.AccessKeyID != "OLDKEY" {
+			t.Fatalf("retrieve = %+v, %v; want the current credentials", creds, err)
+		}
+	}
+
+	// Past expiry callers wait, but only as long as their context allows
+	now = now.Add(2 * time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := chain.retrieve(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("retrieve with an expired cache = %v, want deadline exceeded", err)
+	}
+
+	release <- struct{}{}
+	creds, err := chain.retrieve(context.Background())
+	if err != nil || creds.AccessKeyID != "NEWKEY" {
+		t.Fatalf("retrieve after refresh = %+v, %v", creds, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 1 {
+		t.Errorf("STS called %d times, want 1", calls)
+	}
+}
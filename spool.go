@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpoolConfig controls the disk-backed write-ahead spool. When enabled,
+// accepted events are appended to the spool before they are acknowledged
+// and shipped to OpenSearch from there in order, so an outage or a
+// restart does not lose them.
+type SpoolConfig struct {
+	Enabled      bool          `yaml:"enabled" json:"enabled" env:"SPOOL_ENABLED"`
+	Dir          string        `yaml:"dir" json:"dir" env:"SPOOL_DIR"`
+	SegmentBytes int64         `yaml:"segmentBytes" json:"segmentBytes" env:"SPOOL_SEGMENT_BYTES"`
+	MaxBytes     int64         `yaml:"maxBytes" json:"maxBytes" env:"SPOOL_MAX_BYTES"`
+	MaxAge       time.Duration `yaml:"maxAge" json:"maxAge" env:"SPOOL_MAX_AGE"`
+	SyncWrites   bool          `yaml:"syncWrites" json:"syncWrites" env:"SPOOL_SYNC_WRITES"`
+	BatchRecords int           `yaml:"batchRecords" json:"batchRecords" env:"SPOOL_BATCH_RECORDS"`
+}
+
+// Function to validate the spool settings
+func (c SpoolConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	var errs []error
+	if c.Dir == "" {
+		errs = append(errs, errors.New("spool.dir: required when the spool is enabled"))
+	}
+	// The shipped part of the oldest segment stays on disk until the
+	// shipper moves past it, so one segment's worth of the cap is not
+	// available for new records
+	if c.SegmentBytes <= 0 || c.MaxBytes < 2*c.SegmentBytes {
+		errs = append(errs, errors.New("spool: segmentBytes must be positive and maxBytes at least twice segmentBytes"))
+	}
+	if c.MaxAge <= 0 {
+		errs = append(errs, errors.New("spool.maxAge: must be positive"))
+	}
+	if c.BatchRecords <= 0 {
+		errs = append(errs, errors.New("spool.batchRecords: must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// errSpoolFull is returned when an append would exceed spool.maxBytes
+var errSpoolFull = errors.New("spool is full")
+
+// errSpoolTorn marks a record cut short by a crash or otherwise corrupt
+var errSpoolTorn = errors.New("torn or corrupt spool record")
+
+// spoolRecord is everything written for one accepted event
+type spoolRecord struct {
+	ID       string      `json:"id"`
+	Accepted time.Time   `json:"accepted"`
+	Request  requestMeta `json:"request"`
+	Docs     []spoolDoc  `json:"docs"`
+}
+
+// spoolDoc is a single document of a spooled event
+type spoolDoc struct {
+	Kind     string          `json:"kind"`
+	Index    string          `json:"index"`
+	Required bool            `json:"required"`
+	ID       string          `json:"id,omitempty"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// spoolPosition is a record boundary in the spool
+type spoolPosition struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// spool is a write-ahead log split into numbered segment files. Records
+// are framed as a big-endian length, a CRC-32 of the payload and the JSON
+// payload. A checkpoint file records how far the shipper has got; whole
+// segments behind it are deleted.
+type spool struct {
+	config SpoolConfig
+
+	mu         sync.Mutex
+	closed     bool
+	segments   []uint64
+	active     *os.File
+	activeSize int64
+	totalBytes int64
+	commit     spoolPosition
+	notify     chan struct{}
+
+	shipping sync.WaitGroup
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// Function to open the spool directory, recovering from a crash by
+// truncating a torn record at the end of the newest segment
+func openSpool(c SpoolConfig) (*spool, error) {
+	if err := os.MkdirAll(c.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("error creating spool directory: %w", err)
+	}
+	sp := &spool{config: c, notify: make(chan struct{}, 1)}
+	sp.ctx, sp.cancel = context.WithCancel(context.Background())
+
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading spool directory: %w", err)
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".wal")
+		if !ok {
+			continue
+		}
+		if seg, err := strconv.ParseUint(name, 10, 64); err == nil {
+			sp.segments = append(sp.segments, seg)
+		}
+	}
+	sort.Slice(sp.segments, func(i, j int) bool { return sp.segments[i] < sp.segments[j] })
+
+	if err := sp.readCheckpoint(); err != nil {
+		return nil, err
+	}
+	// A crash between writing the checkpoint and deleting the segments
+	// behind it leaves them on disk
+	for len(sp.segments) > 0 && sp.segments[0] < sp.commit.Segment {
+		if err := os.Remove(sp.segmentPath(sp.segments[0])); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("error removing shipped spool segment: %w", err)
+		}
+		sp.segments = sp.segments[1:]
+	}
+	if len(sp.segments) == 0 {
+		sp.segments = []uint64{max(sp.commit.Segment, 1)}
+	}
+	if sp.commit.Segment < sp.segments[0] {
+		sp.commit = spoolPosition{Segment: sp.segments[0]}
+	}
+
+	last := sp.segments[len(sp.segments)-1]
+	f, err := os.OpenFile(sp.segmentPath(last), os.O_CREATE|os.O_RDWR, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("error opening spool segment: %w", err)
+	}
+	_, end, err := scanSegment(f, 0, -1, 0)
+	if errors.Is(err, errSpoolTorn) {
+		log.Printf("Warning: truncating torn record at offset %d of spool segment %d", end, last)
+		if err := f.Truncate(end); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("error truncating spool segment: %w", err)
+		}
+	} else if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error scanning spool segment: %w", err)
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error opening spool segment: %w", err)
+	}
+	sp.active, sp.activeSize = f, end
+
+	for _, seg := range sp.segments {
+		if info, err := os.Stat(sp.segmentPath(seg)); err == nil {
+			sp.totalBytes += info.Size()
+		}
+	}
+	return sp, nil
+}
+
+// Function to build the file name of a segment
+func (sp *spool) segmentPath(seg uint64) string {
+	return filepath.Join(sp.config.Dir, fmt.Sprintf("%016d.wal", seg))
+}
+
+// Function to load the checkpoint, starting from the oldest segment when
+// there is none
+func (sp *spool) readCheckpoint() error {
+	data, err := os.ReadFile(filepath.Join(sp.config.Dir, "checkpoint"))
+	if errors.Is(err, os.ErrNotExist) {
+		if len(sp.segments) > 0 {
+			sp.commit = spoolPosition{Segment: sp.segments[0]}
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading spool checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, &sp.commit); err != nil {
+		return fmt.Errorf("error parsing spool checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Function to append a record, rolling over to a new segment when the
+// active one is full
+func (sp *spool) append(rec spoolRecord) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("error encoding spool record: %w", err)
+	}
+	frame := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	copy(frame[8:], payload)
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.closed {
+		return errors.New("spool is closed")
+	}
+	// Rotate first: a full segment, even once shipped, is only deleted
+	// after the next segment exists
+	if sp.activeSize > 0 && sp.activeSize+int64(len(frame)) > sp.config.SegmentBytes {
+		if err := sp.rotate(); err != nil {
+			return err
+		}
+	}
+	if sp.totalBytes+int64(len(frame)) > sp.config.MaxBytes {
+		return errSpoolFull
+	}
+
+	if _, err := sp.active.Write(frame); err != nil {
+		// Drop the partial frame so the next append starts clean
+		sp.active.Truncate(sp.activeSize)
+		sp.active.Seek(sp.activeSize, io.SeekStart)
+		return fmt.Errorf("error writing to spool: %w", err)
+	}
+	if sp.config.SyncWrites {
+		if err := sp.active.Sync(); err != nil {
+			return fmt.Errorf("error syncing spool: %w", err)
+		}
+	}
+	sp.activeSize += int64(len(frame))
+	sp.totalBytes += int64(len(frame))
+
+	select {
+	case sp.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Function to seal the active segment and start the next one. Callers
+// must hold sp.mu.
+func (sp *spool) rotate() error {
+	if err := sp.active.Sync(); err != nil {
+		return fmt.Errorf("error syncing spool segment: %w", err)
+	}
+	sp.active.Close()
+
+	next := sp.segments[len(sp.segments)-1] + 1
+	f, err := os.OpenFile(sp.segmentPath(next), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("error creating spool segment: %w", err)
+	}
+	sp.segments = append(sp.segments, next)
+	sp.active, sp.activeSize = f, 0
+	return nil
+}
+
+// Function to read up to max records starting at pos. It returns the
+// position after the last record read; sealed segments that have been
+// read to the end are stepped over.
+func (sp *spool) read(pos spoolPosition, max int) ([]spoolRecord, spoolPosition, error) {
+	sp.mu.Lock()
+	segments := append([]uint64(nil), sp.segments...)
+	activeSize := sp.activeSize
+	sp.mu.Unlock()
+	activeSeg := segments[len(segments)-1]
+
+	for {
+		limit := int64(-1)
+		if pos.Segment == activeSeg {
+			limit = activeSize
+		}
+
+		f, err := os.Open(sp.segmentPath(pos.Segment))
+		if err != nil {
+			return nil, pos, fmt.Errorf("error opening spool segment: %w", err)
+		}
+		recs, end, err := scanSegment(f, pos.Offset, limit, max)
+		f.Close()
+		if errors.Is(err, errSpoolTorn) && pos.Segment != activeSeg {
+			log.Printf("Warning: skipping the corrupt remainder of spool segment %d from offset %d", pos.Segment, end)
+			err = nil
+		}
+		if err != nil {
+			return nil, pos, err
+		}
+		pos.Offset = end
+		if len(recs) > 0 || pos.Segment == activeSeg {
+			return recs, pos, nil
+		}
+
+		i := sort.Search(len(segments), func(i int) bool { return segments[i] > pos.Segment })
+		pos = spoolPosition{Segment: segments[i]}
+	}
+}
+
+// Function to decode up to max records (0 for all) of a segment, from
+// offset up to limit bytes (-1 for the whole file). It returns the offset
+// after the last good record; errSpoolTorn means decoding stopped early.
+func scanSegment(f *os.File, offset, limit int64, max int) ([]spoolRecord, int64, error) {
+	if limit < 0 {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, offset, err
+		}
+		limit = info.Size()
+	}
+	r := bufio.NewReader(io.NewSectionReader(f, offset, limit-offset))
+
+	var recs []spoolRecord
+	end := offset
+	for max <= 0 || len(recs) < max {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return recs, end, nil
+			}
+			return recs, end, errSpoolTorn
+		}
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		if end+8+size > limit {
+			return recs, end, errSpoolTorn
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return recs, end, errSpoolTorn
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+			return recs, end, errSpoolTorn
+		}
+		var rec spoolRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return recs, end, errSpoolTorn
+		}
+		recs = append(recs, rec)
+		end += 8 + size
+	}
+	return recs, end, nil
+}
+
+// Function to record that everything before pos has been shipped and
+// delete the segments it leaves behind
+func (sp *spool) commitTo(pos spoolPosition) error {
+	data, err := json.Marshal(pos)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(sp.config.Dir, "checkpoint")
+	if err := os.WriteFile(path+".tmp", data, 0o640); err != nil {
+		return fmt.Errorf("error writing spool checkpoint: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("error writing spool checkpoint: %w", err)
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.commit = pos
+	for len(sp.segments) > 1 && sp.segments[0] < pos.Segment {
+		seg := sp.segments[0]
+		if info, err := os.Stat(sp.segmentPath(seg)); err == nil {
+			sp.totalBytes -= info.Size()
+		}
+		if err := os.Remove(sp.segmentPath(seg)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error removing shipped spool segment: %w", err)
+		}
+		sp.segments = sp.segments[1:]
+	}
+	return nil
+}
+
+// Function to report the bytes spooled but not yet shipped
+func (sp *spool) pending() int {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return int(sp.totalBytes - sp.commit.Offset)
+}
+
+// Function to wait for an append, or poll again after a while
+func (sp *spool) wait(ctx context.Context) bool {
+	select {
+	case <-sp.notify:
+		return true
+	case <-time.After(time.Second):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Function to stop accepting records, stop the shipper and close the
+// active segment. Unshipped records stay on disk for the next start.
+func (sp *spool) close(ctx context.Context) error {
+	sp.mu.Lock()
+	sp.closed = true
+	sp.mu.Unlock()
+
+	sp.cancel()
+	if err := waitForGroup(ctx, &sp.shipping); err != nil {
+		return err
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if err := sp.active.Sync(); err != nil {
+		return fmt.Errorf("error syncing spool: %w", err)
+	}
+	return sp.active.Close()
+}
+
+// Function to ship spooled records to OpenSearch in order until the spool
+// is closed. A batch is committed only once every document in it has
+// either been written or failed permanently.
+func (s *Server) shipSpool() {
+	sp := s.spool
+	sp.mu.Lock()
+	if sp.closed {
+		sp.mu.Unlock()
+		return
+	}
+	sp.shipping.Add(1)
+	pos := sp.commit
+	sp.mu.Unlock()
+	defer sp.shipping.Done()
+
+	ctx := sp.ctx
+	failures := 0
+	for ctx.Err() == nil {
+		recs, next, err := sp.read(pos, s.config.Spool.BatchRecords)
+		if err != nil {
+			failures++
+			log.Printf("Failed to read spool: %v", err)
+			s.retry.wait(ctx, failures, 0)
+			continue
+		}
+		failures = 0
+
+		if len(recs) > 0 && !s.shipRecords(ctx, recs) {
+			return
+		}
+		if next != pos {
+			if err := sp.commitTo(next); err != nil {
+				log.Printf("Failed to commit spool position: %v", err)
+			}
+			pos = next
+		}
+		if len(recs) == 0 && !sp.wait(ctx) {
+			return
+		}
+	}
+}
+
+// Function to write a batch of spooled records, retrying documents that
+// failed for a transient reason. Each record is written as a unit and its
+// outcome recorded once every document has a final result. It returns
+// false if ctx ended first.
+func (s *Server) shipRecords(ctx context.Context, recs []spoolRecord) bool {
+	ings := make([]*ingestion, len(recs))
+	results := make([][]bulkResult, len(recs))
+	pending := make([][]int, len(recs))
+	for r, rec := range recs {
+		ings[r] = rec.ingestion()
+		results[r] = make([]bulkResult, len(rec.Docs))
+		for d := range rec.Docs {
+			pending[r] = append(pending[r], d)
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		var units [][]bulkDoc
+		var unitRecs []int
+		for r, docs := range pending {
+			if len(docs) == 0 {
+				continue
+			}
+			// Documents spooled for longer than maxAge are given up on
+			if age := time.Since(recs[r].Accepted); age > s.config.Spool.MaxAge {
+				err := fmt.Errorf("%w for %s", errSpoolExpired, age.Round(time.Second))
+				for _, d := range docs {
+					results[r][d] = bulkResult{Index: recs[r].Docs[d].Index, Err: err}
+				}
+				pending[r] = nil
+				s.deliver(ings[r], results[r])
+				continue
+			}
+			unit := make([]bulkDoc, len(docs))
+			for n, d := range docs {
+				unit[n] = ings[r].docs[d]
+			}
+			units = append(units, unit)
+			unitRecs = append(unitRecs, r)
+		}
+		if len(units) == 0 {
+			return true
+		}
+
+		// The bulk indexer has already retried; keep going for as long as
+		// the spool holds the documents, since OpenSearch may be down for
+		// a while
+		retrying := 0
+		var lastErr error
+		written := s.bulk.indexUnits(ctx, units)
+		// Records cut short by shutdown stay in the spool for the next run
+		if ctx.Err() != nil {
+			return false
+		}
+		for u, unitResults := range written {
+			r := unitRecs[u]
+			var retry []int
+			for n, d := range pending[r] {
+				results[r][d] = unitResults[n]
+				if err := unitResults[n].Err; err != nil && retryable(err) {
+					retry = append(retry, d)
+					lastErr = err
+					s.statuses.mark(recs[r].ID, d, stateRetrying, err)
+				}
+			}
+			pending[r] = retry
+			retrying += len(retry)
+			if len(retry) == 0 {
+				s.deliver(ings[r], results[r])
+			}
+		}
+		if retrying == 0 {
+			return true
+		}
+
+		log.Printf("Warning: %d spooled documents not written, retrying: %v", retrying, lastErr)
+		if err := s.retry.wait(ctx, attempt, 0); err != nil {
+			return false
+		}
+	}
+}
+
+// Function to rebuild the ingestion of a spooled event
+func (rec spoolRecord) ingestion() *ingestion {
+	ing := &ingestion{id: rec.ID, meta: rec.Request}
+	for _, d := range rec.Docs {
+		o := outgoingDoc{kind: d.Kind, index: d.Index, required: d.Required, id: d.ID}
+		ing.out = append(ing.out, o)
+		ing.docs = append(ing.docs, o.bulkDoc(d.Body))
+	}
+	return ing
+}
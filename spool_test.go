@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Function to open a spool in dir with segments of segmentBytes
+func openTestSpool(t *testing.T, dir string, segmentBytes, maxBytes int64) *spool {
+	t.Helper()
+	sp, err := openSpool(SpoolConfig{Enabled: true, Dir: dir, SegmentBytes: segmentBytes, MaxBytes: maxBytes, MaxAge: time.Hour, BatchRecords: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sp
+}
+
+// Function to build the record of event n
+func testSpoolRecord(n int) spoolRecord {
+	return spoolRecord{
+		ID:       fmt.Sprintf("event-%03d", n),
+		Accepted: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		Docs:     []spoolDoc{{Kind: "event", Index: "events", Required: true, Body: json.RawMessage(fmt.Sprintf(`{"n":%d}`, n))}},
+	}
+}
+
+// Function to compute the size of the frame a record is written as
+func testFrameSize(t *testing.T, rec spoolRecord) int64 {
+	t.Helper()
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int64(8 + len(payload))
+}
+
+// Function to append the records of events from..to-1
+func appendTestRecords(t *testing.T, sp *spool, from, to int) {
+	t.Helper()
+	for n := from; n < to; n++ {
+		if err := sp.append(testSpoolRecord(n)); err != nil {
+			t.Fatalf("append %d: %v", n, err)
+		}
+	}
+}
+
+// Function to read every record from pos on, returning their IDs and the
+// position after them
+func readTestSpool(t *testing.T, sp *spool, pos spoolPosition) ([]string, spoolPosition) {
+	t.Helper()
+	var ids []string
+	for {
+		recs, next, err := sp.read(pos, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range recs {
+			ids = append(ids, rec.ID)
+		}
+		if len(recs) == 0 {
+			return ids, next
+		}
+		pos = next
+	}
+}
+
+// Function to list the IDs of events from..to-1
+func testIDs(from, to int) []string {
+	var ids []string
+	for n := from; n < to; n++ {
+		ids = append(ids, testSpoolRecord(n).ID)
+	}
+	return ids
+}
+
+// Function to close a spool, failing the test on error
+func closeTestSpool(t *testing.T, sp *spool) {
+	t.Helper()
+	if err := sp.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpoolRecoversTornRecord(t *testing.T) {
+	frame := testFrameSize(t, testSpoolRecord(0))
+	tests := []struct {
+		name string
+		// damage is applied to the segment holding three whole records
+		damage func(data []byte) []byte
+	}{
+		{"cut in the header", func(data []byte) []byte { return data[:2*frame+5] }},
+		{"cut after the header", func(data []byte) []byte { return data[:2*frame+8] }},
+		{"cut in the payload", func(data []byte) []byte { return data[:3*frame-1] }},
+		{"bad checksum", func(data []byte) []byte {
+			data[2*frame+4] ^= 0xff
+			return data
+		}},
+		{"overwritten payload", func(data []byte) []byte {
+			copy(data[2*frame+8:], "not json")
+			return data
+		}},
+		{"length past the end", func(data []byte) []byte {
+			data[2*frame] = 0x7f
+			return data
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sp := openTestSpool(t, dir, 1<<20, 4<<20)
+			appendTestRecords(t, sp, 0, 3)
+			closeTestSpool(t, sp)
+
+			path := sp.segmentPath(1)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(data)) != 3*frame {
+				t.Fatalf("segment holds %d bytes, want %d", len(data), 3*frame)
+			}
+			if err := os.WriteFile(path, tt.damage(data), 0o640); err != nil {
+				t.Fatal(err)
+			}
+
+			sp = openTestSpool(t, dir, 1<<20, 4<<20)
+			defer closeTestSpool(t, sp)
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != 2*frame {
+				t.Errorf("segment truncated to %d bytes, want %d", info.Size(), 2*frame)
+			}
+
+			// The torn record is gone and appends continue after the last
+			// good one
+			appendTestRecords(t, sp, 3, 4)
+			ids, _ := readTestSpool(t, sp, sp.commit)
+			if want := []string{"event-000", "event-001", "event-003"}; !slices.Equal(ids, want) {
+				t.Errorf("read %v, want %v", ids, want)
+			}
+		})
+	}
+}
+
+func TestSpoolRotatesAtSegmentSize(t *testing.T) {
+	frame := testFrameSize(t, testSpoolRecord(0))
+	tests := []struct {
+		name         string
+		segmentBytes int64
+		records      int
+		segments     []uint64
+	}{
+		{"one record per segment", frame, 4, []uint64{1, 2, 3, 4}},
+		{"two records per segment", 2 * frame, 5, []uint64{1, 2, 3}},
+		{"record larger than a segment", frame / 2, 3, []uint64{1, 2, 3}},
+		{"everything in one segment", 10 * frame, 5, []uint64{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sp := openTestSpool(t, dir, tt.segmentBytes, 100*frame)
+			appendTestRecords(t, sp, 0, tt.records)
+			if !slices.Equal(sp.segments, tt.segments) {
+				t.Errorf("segments %v, want %v", sp.segments, tt.segments)
+			}
+			for _, seg := range tt.segments {
+				info, err := os.Stat(sp.segmentPath(seg))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Size() > max(tt.segmentBytes, frame) {
+					t.Errorf("segment %d holds %d bytes, over %d", seg, info.Size(), tt.segmentBytes)
+				}
+			}
+
+			// Records come back in the order they were appended, across
+			// segments and after a restart
+			ids, _ := readTestSpool(t, sp, sp.commit)
+			if want := testIDs(0, tt.records); !slices.Equal(ids, want) {
+				t.Errorf("read %v, want %v", ids, want)
+			}
+			closeTestSpool(t, sp)
+			sp = openTestSpool(t, dir, tt.segmentBytes, 100*frame)
+			defer closeTestSpool(t, sp)
+			ids, _ = readTestSpool(t, sp, sp.commit)
+			if want := testIDs(0, tt.records); !slices.Equal(ids, want) {
+				t.Errorf("read %v after reopening, want %v", ids, want)
+			}
+		})
+	}
+}
+
+func TestSpoolRefusesAppendsOverMaxBytes(t *testing.T) {
+	frame := testFrameSize(t, testSpoolRecord(0))
+	dir := t.TempDir()
+	sp := openTestSpool(t, dir, 2*frame, 4*frame)
+	defer closeTestSpool(t, sp)
+
+	appendTestRecords(t, sp, 0, 4)
+	if err := sp.append(testSpoolRecord(4)); !errors.Is(err, errSpoolFull) {
+		t.Fatalf("append to a full spool: %v, want %v", err, errSpoolFull)
+	}
+	if sp.pending() != int(4*frame) {
+		t.Errorf("pending %d bytes, want %d", sp.pending(), 4*frame)
+	}
+
+	// Shipping the first segment frees its space
+	_, next := readTestSpool(t, sp, spoolPosition{Segment: 1})
+	if err := sp.commitTo(spoolPosition{Segment: 2}); err != nil {
+		t.Fatal(err)
+	}
+	appendTestRecords(t, sp, 4, 6)
+	if err := sp.append(testSpoolRecord(6)); !errors.Is(err, errSpoolFull) {
+		t.Errorf("append over the cap after commit: %v, want %v", err, errSpoolFull)
+	}
+	ids, _ := readTestSpool(t, sp, next)
+	if want := testIDs(4, 6); !slices.Equal(ids, want) {
+		t.Errorf("read %v after commit, want %v", ids, want)
+	}
+}
+
+func TestSpoolCommit(t *testing.T) {
+	frame := testFrameSize(t, testSpoolRecord(0))
+	tests := []struct {
+		name      string
+		commitAt  int // records shipped
+		remaining []uint64
+	}{
+		{"nothing shipped", 0, []uint64{1, 2, 3}},
+		{"part of a segment", 1, []uint64{1, 2, 3}},
+		{"to the end of a segment", 2, []uint64{1, 2, 3}},
+		{"into the next segment", 3, []uint64{2, 3}},
+		{"into the active segment", 5, []uint64{3}},
+		{"everything", 6, []uint64{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sp := openTestSpool(t, dir, 2*frame, 100*frame)
+			appendTestRecords(t, sp, 0, 6)
+
+			// Ship commitAt records the way the shipper does
+			pos := sp.commit
+			for shipped := 0; shipped < tt.commitAt; {
+				recs, next, err := sp.read(pos, tt.commitAt-shipped)
+				if err != nil {
+					t.Fatal(err)
+				}
+				shipped += len(recs)
+				pos = next
+			}
+			if err := sp.commitTo(pos); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(sp.segments, tt.remaining) {
+				t.Errorf("segments %v, want %v", sp.segments, tt.remaining)
+			}
+			for _, seg := range []uint64{1, 2, 3} {
+				_, err := os.Stat(sp.segmentPath(seg))
+				if kept := slices.Contains(tt.remaining, seg); kept != (err == nil) {
+					t.Errorf("segment %d on disk: %v, want %v", seg, err == nil, kept)
+				}
+			}
+			closeTestSpool(t, sp)
+
+			// A restart replays only what was not shipped, in order
+			sp = openTestSpool(t, dir, 2*frame, 100*frame)
+			defer closeTestSpool(t, sp)
+			if sp.commit != pos {
+				t.Errorf("reopened at %+v, want %+v", sp.commit, pos)
+			}
+			ids, _ := readTestSpool(t, sp, sp.commit)
+			if want := testIDs(tt.commitAt, 6); !slices.Equal(ids, want) {
+				t.Errorf("replayed %v, want %v", ids, want)
+			}
+		})
+	}
+}
+
+func TestSpoolRemovesSegmentsLeftBehindCheckpoint(t *testing.T) {
+	frame := testFrameSize(t, testSpoolRecord(0))
+	dir := t.TempDir()
+	sp := openTestSpool(t, dir, frame, 100*frame)
+	appendTestRecords(t, sp, 0, 3)
+	closeTestSpool(t, sp)
+
+	// A crash after writing the checkpoint but before deleting the
+	// segments it leaves behind
+	if err := os.WriteFile(filepath.Join(dir, "checkpoint"), []byte(`{"segment":3,"offset":0}`), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	sp = openTestSpool(t, dir, frame, 100*frame)
+	defer closeTestSpool(t, sp)
+	if !slices.Equal(sp.segments, []uint64{3}) {
+		t.Errorf("segments %v, want [3]", sp.segments)
+	}
+	if sp.pending() != int(frame) {
+		t.Errorf("pending %d bytes, want %d", sp.pending(), frame)
+	}
+	ids, _ := readTestSpool(t, sp, sp.commit)
+	if want := testIDs(2, 3); !slices.Equal(ids, want) {
+		t.Errorf("replayed %v, want %v", ids, want)
+	}
+}
+
+func TestSpoolSkipsCorruptSealedSegment(t *testing.T) {
+	frame := testFrameSize(t, testSpoolRecord(0))
+	dir := t.TempDir()
+	sp := openTestSpool(t, dir, 2*frame, 100*frame)
+	defer closeTestSpool(t, sp)
+	appendTestRecords(t, sp, 0, 5)
+
+	// Corrupt the second record of the first, sealed, segment: the rest of
+	// that segment is lost, the following segments are not
+	path := sp.segmentPath(1)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[frame+4] ^= 0xff
+	if err := os.WriteFile(path, data, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	ids, _ := readTestSpool(t, sp, sp.commit)
+	if want := []string{"event-000", "event-002", "event-003", "event-004"}; !slices.Equal(ids, want) {
+		t.Errorf("read %v, want %v", ids, want)
+	}
+}
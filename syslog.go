@@ -0,0 +1,669 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// SyslogConfig controls the syslog listeners. Each transport is off unless
+// its address is set; TLS (RFC 5425) also needs a certificate and key, and
+// requires client certificates when a client CA is set. Messages that do
+// not parse are counted and a sample is logged every FailureSampleInterval.
+type SyslogConfig struct {
+	UDPAddress            string        `yaml:"udpAddress" json:"udpAddress" env:"SYSLOG_UDP_ADDRESS"`
+	TCPAddress            string        `yaml:"tcpAddress" json:"tcpAddress" env:"SYSLOG_TCP_ADDRESS"`
+	TLSAddress            string        `yaml:"tlsAddress" json:"tlsAddress" env:"SYSLOG_TLS_ADDRESS"`
+	TLSCertFile           string        `yaml:"tlsCertFile" json:"tlsCertFile" env:"SYSLOG_TLS_CERT_FILE"`
+	TLSKeyFile            string        `yaml:"tlsKeyFile" json:"tlsKeyFile" env:"SYSLOG_TLS_KEY_FILE"`
+	TLSClientCAFile       string        `yaml:"tlsClientCAFile" json:"tlsClientCAFile" env:"SYSLOG_TLS_CLIENT_CA_FILE"`
+	MaxMessageBytes       int           `yaml:"maxMessageBytes" json:"maxMessageBytes" env:"SYSLOG_MAX_MESSAGE_BYTES"`
+	FailureSampleInterval time.Duration `yaml:"failureSampleInterval" json:"failureSampleInterval" env:"SYSLOG_FAILURE_SAMPLE_INTERVAL"`
+}
+
+// Function to tell whether any syslog listener is configured
+func (c SyslogConfig) enabled() bool {
+	return c.UDPAddress != "" || c.TCPAddress != "" || c.TLSAddress != ""
+}
+
+// minSyslogMessageBytes is the message size RFC 5424 requires every
+// receiver to accept
+const minSyslogMessageBytes = 480
+
+// Function to validate the syslog settings
+func (c SyslogConfig) validate() error {
+	var errs []error
+	for name, addr := range map[string]string{"udpAddress": c.UDPAddress, "tcpAddress": c.TCPAddress, "tlsAddress": c.TLSAddress} {
+		if addr == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("syslog.%s: %q is not a valid host:port: %v", name, addr, err))
+		}
+	}
+	if c.TLSAddress != "" {
+		if c.TLSCertFile == "" || c.TLSKeyFile == "" {
+			errs = append(errs, errors.New("syslog: tlsCertFile and tlsKeyFile are required with tlsAddress"))
+		}
+		for name, path := range map[string]string{"tlsCertFile": c.TLSCertFile, "tlsKeyFile": c.TLSKeyFile, "tlsClientCAFile": c.TLSClientCAFile} {
+			if path == "" {
+				continue
+			}
+			if _, err := os.Stat(path); err != nil {
+				errs = append(errs, fmt.Errorf("syslog.%s: %v", name, err))
+			}
+		}
+	}
+	if c.MaxMessageBytes < minSyslogMessageBytes {
+		errs = append(errs, fmt.Errorf("syslog.maxMessageBytes: must be at least %d", minSyslogMessageBytes))
+	}
+	if c.FailureSampleInterval <= 0 {
+		errs = append(errs, errors.New("syslog.failureSampleInterval: must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+var (
+	errSyslogPriority       = errors.New("invalid priority")
+	errSyslogHeader         = errors.New("invalid header")
+	errSyslogTimestamp      = errors.New("invalid timestamp")
+	errSyslogStructuredData = errors.New("invalid structured data")
+	errSyslogTooLong        = errors.New("message too long")
+	errSyslogFraming        = errors.New("invalid framing")
+)
+
+// syslogFailureReasons labels the parse failure counter
+var syslogFailureReasons = []struct {
+	err    error
+	reason string
+}{
+	{errSyslogPriority, "priority"},
+	{errSyslogHeader, "header"},
+	{errSyslogTimestamp, "timestamp"},
+	{errSyslogStructuredData, "structured_data"},
+	{errSyslogTooLong, "too_long"},
+	{errSyslogFraming, "framing"},
+}
+
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// syslogMessage is a parsed RFC 5424 or RFC 3164 message. Absent fields
+// (NILVALUE in RFC 5424) are empty.
+type syslogMessage struct {
+	format         string
+	facility       int
+	severity       int
+	timestamp      time.Time
+	hostname       string
+	appName        string
+	procID         string
+	msgID          string
+	structuredData map[string]string
+	message        string
+}
+
+// Function to parse a syslog message, telling the formats apart by the
+// version that follows the priority in RFC 5424. now dates RFC 3164
+// timestamps, which have no year.
+func parseSyslog(data []byte, now time.Time) (syslogMessage, error) {
+	line := strings.TrimRight(string(data), "\r\n\x00")
+	if !strings.HasPrefix(line, "<") {
+		return syslogMessage{}, errSyslogPriority
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return syslogMessage{}, errSyslogPriority
+	}
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return syslogMessage{}, fmt.Errorf("%w: %q", errSyslogPriority, line[1:end])
+	}
+
+	msg := syslogMessage{facility: pri / 8, severity: pri % 8}
+	rest := line[end+1:]
+	if version, after, ok := strings.Cut(rest, " "); ok && version == "1" {
+		return parseRFC5424(msg, after)
+	}
+	return parseRFC3164(msg, rest, now)
+}
+
+// Function to parse the part of an RFC 5424 message after the version:
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func parseRFC5424(msg syslogMessage, rest string) (syslogMessage, error) {
+	msg.format = "rfc5424"
+	fields := strings.SplitN(rest, " ", 6)
+	if len(fields) < 6 {
+		return msg, errSyslogHeader
+	}
+	nilValue := func(s string) string {
+		if s == "-" {
+			return ""
+		}
+		return s
+	}
+
+	if fields[0] != "-" {
+		t, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return msg, fmt.Errorf("%w: %q", errSyslogTimestamp, fields[0])
+		}
+		msg.timestamp = t
+	}
+	msg.hostname, msg.appName = nilValue(fields[1]), nilValue(fields[2])
+	msg.procID, msg.msgID = nilValue(fields[3]), nilValue(fields[4])
+
+	sd, message, err := parseStructuredData(fields[5])
+	if err != nil {
+		return msg, err
+	}
+	msg.structuredData = sd
+	msg.message = strings.TrimPrefix(message, "\ufeff")
+	return msg, nil
+}
+
+// Function to parse RFC 5424 structured data and return the message after
+// it. Each parameter becomes an "SD-ID.name" entry, with any dots in the
+// SD-ID or name replaced by underscores: labels are indexed as dotted
+// paths, and a key may not be both a value and the parent of another.
+// Elements without parameters carry nothing to keep.
+func parseStructuredData(s string) (map[string]string, string, error) {
+	if rest, ok := strings.CutPrefix(s, "-"); ok {
+		if rest == "" {
+			return nil, "", nil
+		}
+		if rest[0] != ' ' {
+			return nil, "", errSyslogStructuredData
+		}
+		return nil, rest[1:], nil
+	}
+	if !strings.HasPrefix(s, "[") {
+		return nil, "", errSyslogStructuredData
+	}
+
+	sd := map[string]string{}
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		i++
+		start := i
+		for i < len(s) && s[i] != ' ' && s[i] != ']' {
+			i++
+		}
+		id := s[start:i]
+		if id == "" || i == len(s) {
+			return nil, "", errSyslogStructuredData
+		}
+
+		for i < len(s) && s[i] == ' ' {
+			i++
+			eq := strings.IndexByte(s[i:], '=')
+			if eq <= 0 || strings.ContainsAny(s[i:i+eq], " ]\"") {
+				return nil, "", errSyslogStructuredData
+			}
+			name := s[i : i+eq]
+			i += eq + 1
+			if i == len(s) || s[i] != '"' {
+				return nil, "", errSyslogStructuredData
+			}
+			i++
+
+			// Values escape '"', '\' and ']' with a backslash
+			var value strings.Builder
+			closed := false
+			for i < len(s) && !closed {
+				switch c := s[i]; {
+				case c == '\\' && i+1 < len(s) && strings.IndexByte(`"\]`, s[i+1]) >= 0:
+					value.WriteByte(s[i+1])
+					i += 2
+				case c == '"':
+					closed = true
+					i++
+				default:
+					value.WriteByte(c)
+					i++
+				}
+			}
+			if !closed {
+				return nil, "", errSyslogStructuredData
+			}
+			sd[flatKey(id)+"."+flatKey(name)] = value.String()
+		}
+		if i == len(s) || s[i] != ']' {
+			return nil, "", errSyslogStructuredData
+		}
+		i++
+	}
+
+	if i == len(s) {
+		return sd, "", nil
+	}
+	if s[i] != ' ' {
+		return nil, "", errSyslogStructuredData
+	}
+	return sd, s[i+1:], nil
+}
+
+// Function to parse the part of an RFC 3164 message after the priority:
+// TIMESTAMP [HOSTNAME] [TAG[PID]:] MSG. Many senders deviate from the RFC,
+// so the hostname and tag are optional and an RFC 3339 timestamp is
+// accepted too.
+func parseRFC3164(msg syslogMessage, rest string, now time.Time) (syslogMessage, error) {
+	msg.format = "rfc3164"
+	if len(rest) >= len(time.Stamp) {
+		if t, err := time.ParseInLocation(time.Stamp, rest[:len(time.Stamp)], time.Local); err == nil {
+			// No year is sent: take the latest year in which the date
+			// exists (Feb 29 only does in leap years) and is not ahead of
+			// now, allowing for a day of clock skew around New Year
+			for year := now.Year() + 1; ; year-- {
+				date := time.Date(year, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
+				if date.Day() == t.Day() && !date.After(now.Add(24*time.Hour)) {
+					msg.timestamp = date
+					break
+				}
+			}
+			rest = strings.TrimPrefix(rest[len(time.Stamp):], " ")
+		}
+	}
+	if msg.timestamp.IsZero() {
+		token, after, _ := strings.Cut(rest, " ")
+		t, err := time.Parse(time.RFC3339Nano, token)
+		if err != nil {
+			return msg, fmt.Errorf("%w: %q", errSyslogTimestamp, token)
+		}
+		msg.timestamp, rest = t, after
+	}
+
+	// The hostname is left out by some senders, in which case the next
+	// word is already the tag
+	if host, after, ok := strings.Cut(rest, " "); ok && !strings.HasSuffix(host, ":") && !strings.Contains(host, "[") {
+		msg.hostname, rest = host, after
+	}
+	msg.appName, msg.procID, msg.message = parseTag(rest)
+	return msg, nil
+}
+
+// Function to split an RFC 3164 tag ("app[pid]: ") off the message. If
+// there is none, the whole text is the message.
+func parseTag(s string) (app, pid, message string) {
+	end := strings.IndexAny(s, ":[ ")
+	if end <= 0 || end > 48 || s[end] == ' ' {
+		return "", "", s
+	}
+	app, rest := s[:end], s[end:]
+	if rest[0] == '[' {
+		close := strings.IndexByte(rest, ']')
+		if close < 0 {
+			return "", "", s
+		}
+		pid, rest = rest[1:close], rest[close+1:]
+	}
+	rest, ok := strings.CutPrefix(rest, ":")
+	if !ok && pid == "" {
+		return "", "", s
+	}
+	return app, pid, strings.TrimPrefix(rest, " ")
+}
+
+// Function to convert a syslog message into an Event. Severities from
+// warning up make a Warning; the host and app name identify the involved
+// object, falling back to the sender's address for the host.
+func syslogEvent(msg syslogMessage, remote net.Addr) Event {
+	var event Event
+	event.Kind = "Syslog"
+	event.Metadata.Labels = msg.structuredData
+	event.Metadata.Message = msg.message
+	event.InvolvedObject.Kind = msg.appName
+	event.InvolvedObject.Name = msg.hostname
+	if event.InvolvedObject.Name == "" && remote != nil {
+		event.InvolvedObject.Name = remote.String()
+		if host, _, err := net.SplitHostPort(remote.String()); err == nil {
+			event.InvolvedObject.Name = host
+		}
+	}
+	event.Action = msg.msgID
+	event.Type = "Normal"
+	if msg.severity <= 4 {
+		event.Type = "Warning"
+	}
+	if !msg.timestamp.IsZero() {
+		event.EventTime = msg.timestamp.UTC().Format(time.RFC3339Nano)
+	}
+
+	event.Attributes = map[string]string{
+		"format":   msg.format,
+		"facility": syslogFacilities[msg.facility],
+		"severity": syslogSeverities[msg.severity],
+	}
+	if msg.procID != "" {
+		event.Attributes["procid"] = msg.procID
+	}
+	return event
+}
+
+// syslogFailures counts messages that could not be parsed and logs a
+// sample of them, at most one per interval
+type syslogFailures struct {
+	counter  metric.Int64Counter
+	interval time.Duration
+
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+// Function to record a message that could not be parsed
+func (f *syslogFailures) record(ctx context.Context, transport string, remote net.Addr, data []byte, err error) {
+	reason := "other"
+	for _, r := range syslogFailureReasons {
+		if errors.Is(err, r.err) {
+			reason = r.reason
+			break
+		}
+	}
+	f.counter.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("transport", transport),
+		attribute.String("reason", reason),
+	))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if time.Since(f.last) < f.interval {
+		f.suppressed++
+		return
+	}
+	if len(data) > 256 {
+		data = data[:256]
+	}
+	log.Printf("Warning: dropped syslog message from %s over %s (%d more since the last sample): %v: %q", remote, transport, f.suppressed, err, data)
+	f.last, f.suppressed = time.Now(), 0
+}
+
+// syslogSource receives syslog messages and feeds them into the ingestion
+// pipeline
+type syslogSource struct {
+	config   SyslogConfig
+	ingest   func(ctx context.Context, meta requestMeta, event Event) error
+	failures *syslogFailures
+	udp      net.PacketConn
+	tcp      net.Listener
+	tls      net.Listener
+}
+
+// Function to create the syslog source and bind its listeners, so that a
+// bad address fails at startup
+func newSyslogSource(config SyslogConfig, meter metric.Meter, ingest func(context.Context, requestMeta, Event) error) (*syslogSource, error) {
+	counter, err := meter.Int64Counter(
+		"syslog_parse_failures",
+		metric.WithDescription("Syslog messages dropped because they could not be parsed"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating syslog failure counter: %w", err)
+	}
+	src := &syslogSource{
+		config:   config,
+		ingest:   ingest,
+		failures: &syslogFailures{counter: counter, interval: config.FailureSampleInterval},
+	}
+
+	if config.UDPAddress != "" {
+		if src.udp, err = net.ListenPacket("udp", config.UDPAddress); err != nil {
+			src.close()
+			return nil, fmt.Errorf("error listening for syslog over UDP: %w", err)
+		}
+	}
+	if config.TCPAddress != "" {
+		if src.tcp, err = net.Listen("tcp", config.TCPAddress); err != nil {
+			src.close()
+			return nil, fmt.Errorf("error listening for syslog over TCP: %w", err)
+		}
+	}
+	if config.TLSAddress != "" {
+		tlsConfig, err := syslogTLSConfig(config)
+		if err != nil {
+			src.close()
+			return nil, err
+		}
+		listener, err := net.Listen("tcp", config.TLSAddress)
+		if err != nil {
+			src.close()
+			return nil, fmt.Errorf("error listening for syslog over TLS: %w", err)
+		}
+		src.tls = tls.NewListener(listener, tlsConfig)
+	}
+	return src, nil
+}
+
+// Function to build the TLS listener configuration. Certificates are
+// reloaded when they change on disk, like the OpenSearch client's.
+func syslogTLSConfig(c SyslogConfig) (*tls.Config, error) {
+	reloader := &certReloader{caFile: c.TLSClientCAFile, certFile: c.TLSCertFile, keyFile: c.TLSKeyFile}
+	if _, err := reloader.clientCertificate(nil); err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return reloader.clientCertificate(nil)
+		},
+	}
+	if c.TLSClientCAFile == "" {
+		return config, nil
+	}
+
+	if _, err := reloader.roots(); err != nil {
+		return nil, err
+	}
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		pool, err := reloader.roots()
+		if err != nil {
+			return nil, err
+		}
+		perClient := config.Clone()
+		perClient.GetConfigForClient = nil
+		perClient.ClientCAs = pool
+		perClient.ClientAuth = tls.RequireAndVerifyClientCert
+		return perClient, nil
+	}
+	return config, nil
+}
+
+// Function to close the listeners
+func (src *syslogSource) close() {
+	for _, c := range []io.Closer{src.udp, src.tcp, src.tls} {
+		if c != nil {
+			c.Close()
+		}
+	}
+}
+
+// Function to receive messages on every listener until ctx is cancelled
+func (src *syslogSource) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	if src.udp != nil {
+		log.Printf("Receiving syslog over UDP on %s", src.udp.LocalAddr())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			src.serveUDP(ctx)
+		}()
+	}
+	for transport, listener := range map[string]net.Listener{"tcp": src.tcp, "tls": src.tls} {
+		if listener == nil {
+			continue
+		}
+		log.Printf("Receiving syslog over %s on %s", strings.ToUpper(transport), listener.Addr())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			src.serveStream(ctx, transport, listener)
+		}()
+	}
+
+	<-ctx.Done()
+	src.close()
+	wg.Wait()
+}
+
+// Function to receive one message per datagram. A datagram larger than
+// the buffer is cut short by the read, so the buffer has room for one byte
+// more than a message may have, to tell that it was too long.
+func (src *syslogSource) serveUDP(ctx context.Context) {
+	buf := make([]byte, src.config.MaxMessageBytes+1)
+	for {
+		n, remote, err := src.udp.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Syslog UDP listener failed: %v", err)
+			}
+			return
+		}
+		if n > src.config.MaxMessageBytes {
+			src.failures.record(ctx, "udp", remote, buf[:n], errSyslogTooLong)
+			continue
+		}
+		if !src.handle(ctx, "udp", remote, buf[:n]) {
+			return
+		}
+	}
+}
+
+// Function to accept stream connections until the listener is closed
+func (src *syslogSource) serveStream(ctx context.Context, transport string, listener net.Listener) {
+	var conns sync.WaitGroup
+	defer conns.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Syslog %s listener failed: %v", strings.ToUpper(transport), err)
+			}
+			return
+		}
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			stop := context.AfterFunc(ctx, func() { conn.Close() })
+			defer stop()
+			defer conn.Close()
+			src.serveConn(ctx, transport, conn)
+		}()
+	}
+}
+
+// Function to read the messages of a stream connection, framed by octet
+// counting or by newlines (RFC 6587)
+func (src *syslogSource) serveConn(ctx context.Context, transport string, conn net.Conn) {
+	r := bufio.NewReaderSize(conn, src.config.MaxMessageBytes+1)
+	for {
+		data, err := readSyslogFrame(r, src.config.MaxMessageBytes)
+		switch {
+		case errors.Is(err, errSyslogTooLong):
+			src.failures.record(ctx, transport, conn.RemoteAddr(), data, err)
+			continue
+		case errors.Is(err, errSyslogFraming):
+			// The stream cannot be resynchronised
+			src.failures.record(ctx, transport, conn.RemoteAddr(), data, err)
+			return
+		case err != nil:
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				log.Printf("Syslog %s connection from %s failed: %v", strings.ToUpper(transport), conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if !src.handle(ctx, transport, conn.RemoteAddr(), data) {
+			return
+		}
+	}
+}
+
+// Function to read the next message from a stream. A frame over maxBytes
+// is skipped and reported as errSyslogTooLong with its start.
+func readSyslogFrame(r *bufio.Reader, maxBytes int) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] >= '1' && first[0] <= '9' {
+		// Octet counting: "<length> <message>"
+		prefix, err := r.ReadSlice(' ')
+		if err != nil {
+			if errors.Is(err, bufio.ErrBufferFull) || errors.Is(err, io.EOF) {
+				return prefix, errSyslogFraming
+			}
+			return nil, err
+		}
+		n, err := strconv.Atoi(string(prefix[:len(prefix)-1]))
+		if err != nil {
+			return prefix, errSyslogFraming
+		}
+		if n > maxBytes {
+			head, _ := r.Peek(min(n, 256))
+			head = append([]byte(nil), head...)
+			if _, err := r.Discard(n); err != nil {
+				return nil, err
+			}
+			return head, fmt.Errorf("%w (%d bytes)", errSyslogTooLong, n)
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+
+	// Non-transparent framing: one message per line
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		head := append([]byte(nil), line[:min(len(line), 256)]...)
+		for errors.Is(err, bufio.ErrBufferFull) {
+			_, err = r.ReadSlice('\n')
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		return head, errSyslogTooLong
+	}
+	if err != nil && !(errors.Is(err, io.EOF) && len(line) > 0) {
+		return nil, err
+	}
+	return append([]byte(nil), line...), nil
+}
+
+// Function to parse and ingest one message. It returns false once ctx is
+// cancelled and the message could not be handed over.
+func (src *syslogSource) handle(ctx context.Context, transport string, remote net.Addr, data []byte) bool {
+	msg, err := parseSyslog(data, time.Now())
+	if err != nil {
+		src.failures.record(ctx, transport, remote, data, err)
+		return true
+	}
+
+	meta := sourceMeta("syslog/" + transport)
+	meta.RemoteAddr = remote.String()
+	if err := src.ingest(ctx, meta, syslogEvent(msg, remote)); err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		log.Printf("Failed to ingest syslog message from %s: %v", remote, err)
+	}
+	return true
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// Function to compare parsed messages, timestamps by the instant they
+// name
+func checkSyslogMessage(t *testing.T, got, want syslogMessage) {
+	t.Helper()
+	if !got.timestamp.Equal(want.timestamp) {
+		t.Errorf("timestamp %v, want %v", got.timestamp, want.timestamp)
+	}
+	got.timestamp, want.timestamp = time.Time{}, time.Time{}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseRFC5424(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want syslogMessage
+		err  error
+	}{
+		{
+			name: "every field",
+			line: "<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog 8710 ID47 [exampleSDID@32473 iut=\"3\" eventSource=\"Application\"] An application event\n",
+			want: syslogMessage{
+				format: "rfc5424", facility: 20, severity: 5,
+				timestamp: time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC),
+				hostname:  "mymachine.example.com", appName: "evntslog", procID: "8710", msgID: "ID47",
+				structuredData: map[string]string{"exampleSDID@32473.iut": "3", "exampleSDID@32473.eventSource": "Application"},
+				message:        "An application event",
+			},
+		},
+		{
+			name: "NILVALUEs and no message",
+			line: "<34>1 - - - - - -",
+			want: syslogMessage{format: "rfc5424", facility: 4, severity: 2},
+		},
+		{
+			name: "NILVALUE structured data then a message",
+			line: "<34>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc - - - %% It's time to make the do-nuts.",
+			want: syslogMessage{
+				format: "rfc5424", facility: 4, severity: 2,
+				timestamp: time.Date(2003, 8, 24, 12, 14, 15, 3000, time.UTC),
+				hostname:  "192.0.2.1", appName: "myproc",
+				message: "%% It's time to make the do-nuts.",
+			},
+		},
+		{
+			name: "BOM before the message",
+			line: "<13>1 - host app - - - \ufeffcafé",
+			want: syslogMessage{format: "rfc5424", facility: 1, severity: 5, hostname: "host", appName: "app", message: "café"},
+		},
+		{
+			name: "escapes in values",
+			line: `<13>1 - - - - - [x@1 a="say \"hi\"" b="[1\]" c="back\\slash" d="\n"] msg`,
+			want: syslogMessage{
+				format: "rfc5424", facility: 1, severity: 5,
+				structuredData: map[string]string{"x@1.a": `say "hi"`, "x@1.b": "[1]", "x@1.c": `back\slash`, "x@1.d": `\n`},
+				message:        "msg",
+			},
+		},
+		{
+			name: "elements without parameters and dotted names",
+			line: `<13>1 - - - - - [timeQuality][ex.sd@1 a.b="1"][origin ip="192.0.2.1"]`,
+			want: syslogMessage{
+				format: "rfc5424", facility: 1, severity: 5,
+				structuredData: map[string]string{"ex_sd@1.a_b": "1", "origin.ip": "192.0.2.1"},
+			},
+		},
+		{name: "unterminated value", line: `<13>1 - - - - - [x@1 a="open] msg`, err: errSyslogStructuredData},
+		{name: "unterminated element", line: `<13>1 - - - - - [x@1 a="1"`, err: errSyslogStructuredData},
+		{name: "unquoted value", line: `<13>1 - - - - - [x@1 a=1] msg`, err: errSyslogStructuredData},
+		{name: "no space after structured data", line: `<13>1 - - - - - [x@1]msg`, err: errSyslogStructuredData},
+		{name: "no structured data", line: `<13>1 - - - - - msg`, err: errSyslogStructuredData},
+		{name: "missing fields", line: "<13>1 - host app", err: errSyslogHeader},
+		{name: "bad timestamp", line: "<13>1 2003-10-11 host app - - -", err: errSyslogTimestamp},
+		{name: "priority too large", line: "<192>1 - - - - - -", err: errSyslogPriority},
+		{name: "no priority", line: "1 - - - - - -", err: errSyslogPriority},
+		{name: "unterminated priority", line: "<13 1 - - - - - -", err: errSyslogPriority},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSyslog([]byte(tt.line), time.Now())
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSyslogMessage(t, got, tt.want)
+		})
+	}
+}
+
+func TestParseRFC3164(t *testing.T) {
+	local := func(year int, month time.Month, day, hour, min, sec int) time.Time {
+		return time.Date(year, month, day, hour, min, sec, 0, time.Local)
+	}
+	now := local(2025, 6, 15, 12, 0, 0)
+	tests := []struct {
+		name string
+		line string
+		now  time.Time
+		want syslogMessage
+		err  error
+	}{
+		{
+			name: "hostname and tag",
+			line: "<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8",
+			want: syslogMessage{
+				format: "rfc3164", facility: 4, severity: 2, timestamp: local(2024, 10, 11, 22, 14, 15),
+				hostname: "mymachine", appName: "su", message: "'su root' failed for lonvick on /dev/pts/8",
+			},
+		},
+		{
+			name: "tag with pid",
+			line: "<13>Jun  1 08:00:00 web-1 nginx[4242]: started",
+			want: syslogMessage{
+				format: "rfc3164", facility: 1, severity: 5, timestamp: local(2025, 6, 1, 8, 0, 0),
+				hostname: "web-1", appName: "nginx", procID: "4242", message: "started",
+			},
+		},
+		{
+			name: "no hostname",
+			line: "<13>Jun 15 11:00:00 cron[7]: job done",
+			want: syslogMessage{
+				format: "rfc3164", facility: 1, severity: 5, timestamp: local(2025, 6, 15, 11, 0, 0),
+				appName: "cron", procID: "7", message: "job done",
+			},
+		},
+		{
+			name: "no tag",
+			line: "<13>Jun 15 11:00:00 web-1 just some text",
+			want: syslogMessage{
+				format: "rfc3164", facility: 1, severity: 5, timestamp: local(2025, 6, 15, 11, 0, 0),
+				hostname: "web-1", message: "just some text",
+			},
+		},
+		{
+			name: "RFC 3339 timestamp",
+			line: "<13>2025-06-15T10:00:00.5Z web-1 app: hello",
+			want: syslogMessage{
+				format: "rfc3164", facility: 1, severity: 5, timestamp: time.Date(2025, 6, 15, 10, 0, 0, 500000000, time.UTC),
+				hostname: "web-1", appName: "app", message: "hello",
+			},
+		},
+		{
+			name: "December received in January",
+			line: "<13>Dec 31 23:59:59 host app: last year",
+			now:  local(2025, 1, 1, 0, 0, 5),
+			want: syslogMessage{
+				format: "rfc3164", facility: 1, severity: 5, timestamp: local(2024, 12, 31, 23, 59, 59),
+				hostname: "host", appName: "app", message: "last year",
+			},
+		},
+		{
+			name: "January received in late December",
+			line: "<13>Jan  1 00:00:01 host app: clock ahead",
+			now:  local(2024, 12, 31, 23, 59, 0),
+			want: syslogMessage{
+				format: "rfc3164", facility: 1, severity: 5, timestamp: local(2025, 1, 1, 0, 0, 1),
+				hostname: "host", appName: "app", message: "clock ahead",
+			},
+		},
+		{
+			name: "Feb 29 in a leap year",
+			line: "<13>Feb 29 12:00:00 host app: leap",
+			now:  local(2024, 3, 1, 0, 0, 0),
+			want: syslogMessage{
+				format: "rfc3164", facility: 1, severity: 5, timestamp: local(2024, 2, 29, 12, 0, 0),
+				hostname: "host", appName: "app", message: "leap",
+			},
+		},
+		{
+			name: "Feb 29 received after a leap year",
+			line: "<13>Feb 29 12:00:00 host app: leap",
+			now:  local(2025, 1, 10, 0, 0, 0),
+			want: syslogMessage{
+				format: "rfc3164", facility: 1, severity: 5, timestamp: local(2024, 2, 29, 12, 0, 0),
+				hostname: "host", appName: "app", message: "leap",
+			},
+		},
+		{name: "no timestamp", line: "<13>hello world", err: errSyslogTimestamp},
+		{name: "invalid date", line: "<13>Feb 30 12:00:00 host app: never", err: errSyslogTimestamp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := now
+			if !tt.now.IsZero() {
+				received = tt.now
+			}
+			got, err := parseSyslog([]byte(tt.line), received)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSyslogMessage(t, got, tt.want)
+		})
+	}
+}
+
+func TestParseTag(t *testing.T) {
+	tests := []struct {
+		in, app, pid, message string
+	}{
+		{"su: failed", "su", "", "failed"},
+		{"sshd[42]: accepted", "sshd", "42", "accepted"},
+		{"sshd[42] accepted", "sshd", "42", "accepted"},
+		{"no tag here", "", "", "no tag here"},
+		{": empty tag", "", "", ": empty tag"},
+		{"app[unterminated: x", "", "", "app[unterminated: x"},
+		{strings.Repeat("a", 49) + ": too long", "", "", strings.Repeat("a", 49) + ": too long"},
+	}
+	for _, tt := range tests {
+		app, pid, message := parseTag(tt.in)
+		if app != tt.app || pid != tt.pid || message != tt.message {
+			t.Errorf("parseTag(%q) = %q, %q, %q, want %q, %q, %q", tt.in, app, pid, message, tt.app, tt.pid, tt.message)
+		}
+	}
+}
+
+// syslogFrame is a message or error read from a stream
+type syslogFrame struct {
+	data string
+	err  error
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	const maxBytes = 32
+	long := strings.Repeat("x", 40)
+	tests := []struct {
+		name   string
+		stream string
+		want   []syslogFrame
+	}{
+		{
+			name:   "octet counting",
+			stream: "5 hello11 hello world",
+			want:   []syslogFrame{{data: "hello"}, {data: "hello world"}},
+		},
+		{
+			name:   "octet counted frames may hold newlines",
+			stream: "6 a\nb\nc\n3 end",
+			want:   []syslogFrame{{data: "a\nb\nc\n"}, {data: "end"}},
+		},
+		{
+			name:   "newlines",
+			stream: "first\nsecond\nlast",
+			want:   []syslogFrame{{data: "first\n"}, {data: "second\n"}, {data: "last"}},
+		},
+		{
+			name:   "mixed",
+			stream: "<13>line\n4 four",
+			want:   []syslogFrame{{data: "<13>line\n"}, {data: "four"}},
+		},
+		{
+			name:   "overlong octet counted frame",
+			stream: "40 " + long + "2 ok",
+			want:   []syslogFrame{{data: long[:maxBytes+1], err: errSyslogTooLong}, {data: "ok"}},
+		},
+		{
+			name:   "overlong line",
+			stream: long + "\nok\n",
+			want:   []syslogFrame{{data: long[:maxBytes+1], err: errSyslogTooLong}, {data: "ok\n"}},
+		},
+		{
+			name:   "frame at the limit",
+			stream: "32 " + long[:maxBytes],
+			want:   []syslogFrame{{data: long[:maxBytes]}},
+		},
+		{
+			name:   "length that is not a number",
+			stream: "12x hello",
+			want:   []syslogFrame{{data: "12x ", err: errSyslogFraming}},
+		},
+		{
+			name:   "length without a message",
+			stream: "12",
+			want:   []syslogFrame{{data: "12", err: errSyslogFraming}},
+		},
+		{
+			name:   "length too long to be one",
+			stream: "1" + strings.Repeat("0", 40) + " x",
+			want:   []syslogFrame{{data: "1" + strings.Repeat("0", maxBytes), err: errSyslogFraming}},
+		},
+		{
+			name:   "stream ends inside a frame",
+			stream: "10 short",
+			want:   []syslogFrame{{err: io.ErrUnexpectedEOF}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The listener sizes the buffer the same way
+			r := bufio.NewReaderSize(strings.NewReader(tt.stream), maxBytes+1)
+			for i, want := range tt.want {
+				data, err := readSyslogFrame(r, maxBytes)
+				if !errors.Is(err, want.err) {
+					t.Fatalf("frame %d: error %v, want %v", i, err, want.err)
+				}
+				if string(data) != want.data {
+					t.Errorf("frame %d: %q, want %q", i, data, want.data)
+				}
+			}
+			// A stream with bad framing is dropped, not read on
+			if last := tt.want[len(tt.want)-1]; last.err == nil || errors.Is(last.err, errSyslogTooLong) {
+				if _, err := readSyslogFrame(r, maxBytes); !errors.Is(err, io.EOF) {
+					t.Errorf("read past the last frame: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSyslogUDPDropsOverlongDatagrams(t *testing.T) {
+	ingested := make(chan Event, 10)
+	config := SyslogConfig{UDPAddress: "127.0.0.1:0", MaxMessageBytes: minSyslogMessageBytes, FailureSampleInterval: time.Minute}
+	src, err := newSyslogSource(config, noop.NewMeterProvider().Meter("test"), func(ctx context.Context, meta requestMeta, event Event) error {
+		ingested <- event
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		src.run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	conn, err := net.Dial("udp", src.udp.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	header := "<13>1 - host app - TOOLONG - "
+	for _, message := range []string{
+		header + strings.Repeat("x", minSyslogMessageBytes+1-len(header)),
+		"<13>1 - host app - FITS - " + strings.Repeat("x", 100),
+	} {
+		if _, err := conn.Write([]byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case event := <-ingested:
+		if event.Action != "FITS" {
+			t.Errorf("ingested %q, want only the datagram that fits", event.Action)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event ingested")
+	}
+	select {
+	case event := <-ingested:
+		t.Errorf("also ingested %q", event.Action)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSConfig holds the trust and client-certificate settings used when
+// talking to OpenSearch
+type TLSConfig struct {
+	CAFile             string `yaml:"caFile" json:"caFile" env:"OPENSEARCH_TLS_CA_FILE"`
+	CertFile           string `yaml:"certFile" json:"certFile" env:"OPENSEARCH_TLS_CERT_FILE"`
+	KeyFile            string `yaml:"keyFile" json:"keyFile" env:"OPENSEARCH_TLS_KEY_FILE"`
+	ServerName         string `yaml:"serverName" json:"serverName" env:"OPENSEARCH_TLS_SERVER_NAME"`
+	MinVersion         string `yaml:"minVersion" json:"minVersion" env:"OPENSEARCH_TLS_MIN_VERSION"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify" json:"insecureSkipVerify" env:"OPENSEARCH_TLS_INSECURE_SKIP_VERIFY"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Function to validate the TLS settings without touching the network
+func (c TLSConfig) validate() error {
+	var errs []error
+	if _, ok := tlsVersions[c.MinVersion]; !ok {
+		errs = append(errs, fmt.Errorf("opensearch.tls.minVersion: %q is not one of 1.0, 1.1, 1.2, 1.3", c.MinVersion))
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		errs = append(errs, errors.New("opensearch.tls: certFile and keyFile must be set together"))
+	}
+	for name, path := range map[string]string{"caFile": c.CAFile, "certFile": c.CertFile, "keyFile": c.KeyFile} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, fmt.Errorf("opensearch.tls.%s: %v", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// certReloader keeps the CA pool and client certificate in sync with the
+// files on disk so rotated certificates are picked up without a restart
+type certReloader struct {
+	caFile   string
+	certFile string
+	keyFile  string
+
+	mu          sync.Mutex
+	pool        *x509.CertPool
+	caModTime   time.Time
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// Function to return the CA pool, re-reading the bundle if it changed
+func (r *certReloader) roots() (*x509.CertPool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, err := os.Stat(r.caFile)
+	if err != nil {
+		if r.pool != nil {
+			log.Printf("Warning: keeping previous CA bundle, stat failed: %v", err)
+			return r.pool, nil
+		}
+		return nil, fmt.Errorf("error reading CA bundle: %w", err)
+	}
+	if r.pool != nil && info.ModTime().Equal(r.caModTime) {
+		return r.pool, nil
+	}
+
+	pem, err := os.ReadFile(r.caFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		if r.pool != nil {
+			log.Printf("Warning: keeping previous CA bundle, %s contains no certificates", r.caFile)
+			return r.pool, nil
+		}
+		return nil, fmt.Errorf("CA bundle %s contains no certificates", r.caFile)
+	}
+	if r.pool != nil {
+		log.Printf("Reloaded CA bundle from %s", r.caFile)
+	}
+	r.pool = pool
+	r.caModTime = info.ModTime()
+	return r.pool, nil
+}
+
+// Function to return the client certificate, re-reading it if it changed
+func (r *certReloader) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	certInfo, certErr := os.Stat(r.certFile)
+	keyInfo, keyErr := os.Stat(r.keyFile)
+	if certErr != nil || keyErr != nil {
+		if r.cert != nil {
+			log.Printf("Warning: keeping previous client certificate, stat failed: %v", errors.Join(certErr, keyErr))
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("error reading client certificate: %w", errors.Join(certErr, keyErr))
+	}
+	if r.cert != nil && certInfo.ModTime().Equal(r.certModTime) && keyInfo.ModTime().Equal(r.keyModTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		// During rotation the certificate and key are rarely replaced
+		// atomically, so keep serving the old pair until both match
+		if r.cert != nil {
+			log.Printf("Warning: keeping previous client certificate: %v", err)
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("error loading client certificate: %w", err)
+	}
+	if r.cert != nil {
+		log.Printf("Reloaded client certificate from %s", r.certFile)
+	}
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+	return r.cert, nil
+}
+
+// Function to verify the server chain against the current CA pool and
+// the certificate against serverName, or the name sent in the handshake.
+// Without a name to check, any certificate from the CA would pass, so the
+// connection is refused.
+func (r *certReloader) verifyConnection(serverName string) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("server presented no certificates")
+		}
+		name := serverName
+		if name == "" {
+			name = cs.ServerName
+		}
+		if name == "" {
+			return errors.New("no server name to verify the certificate against")
+		}
+		roots, err := r.roots()
+		if err != nil {
+			return err
+		}
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+			return err
+		}
+		// VerifyHostname matches IP addresses against the IP SANs
+		return cs.PeerCertificates[0].VerifyHostname(name)
+	}
+}
+
+// Function to build the tls.Config for the OpenSearch transport, along
+// with the reloader behind its certificates
+func buildTLSConfig(c TLSConfig) (*tls.Config, *certReloader, error) {
+	tlsCfg := &tls.Config{
+		MinVersion: tlsVersions[c.MinVersion],
+		ServerName: c.ServerName,
+	}
+
+	reloader := &certReloader{caFile: c.CAFile, certFile: c.CertFile, keyFile: c.KeyFile}
+
+	switch {
+	case c.InsecureSkipVerify:
+		log.Printf("Warning: TLS certificate verification for OpenSearch is disabled")
+		tlsCfg.InsecureSkipVerify = true
+	case c.CAFile != "":
+		// Load once up front so a bad bundle fails at startup
+		if _, err := reloader.roots(); err != nil {
+			return nil, nil, err
+		}
+		// The standard verifier pins RootCAs for the lifetime of the
+		// config; verify ourselves so a rotated bundle takes effect
+		tlsCfg.InsecureSkipVerify = true
+		tlsCfg.VerifyConnection = reloader.verifyConnection(c.ServerName)
+	}
+
+	if c.CertFile != "" {
+		if _, err := reloader.clientCertificate(nil); err != nil {
+			return nil, nil, err
+		}
+		tlsCfg.GetClientCertificate = reloader.clientCertificate
+	}
+
+	return tlsCfg, reloader, nil
+}
+
+// Function to build the HTTP transport used by the OpenSearch client
+func newOpenSearchTransport(c TLSConfig) (*http.Transport, error) {
+	tlsCfg, reloader, err := buildTLSConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+
+	// The handshake state carries no server name for IP addresses, so
+	// unless one is configured, check each certificate against the host
+	// the connection dials
+	if tlsCfg.VerifyConnection != nil && tlsCfg.ServerName == "" {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			cfg := tlsCfg.Clone()
+			cfg.ServerName = host
+			cfg.VerifyConnection = reloader.verifyConnection(host)
+			return (&tls.Dialer{NetDialer: dialer, Config: cfg}).DialContext(ctx, network, addr)
+		}
+	}
+	return transport, nil
+}